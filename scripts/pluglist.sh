@@ -2,7 +2,11 @@
 #
 # Spits out a list of plugin binaries we can build with "make" based on what's
 # in src/plugins.  The ImageMagick decoder is explicitly skipped to avoid
-# unnecessary dependencies since JP2s are the primary need.
+# unnecessary dependencies since JP2s are the primary need.  Only directories
+# with an "so" subpackage are plugins; others, like the registry, are support
+# code.
 for plugdir in $(find ./src/plugins -mindepth 1 -maxdepth 1 -type d -not -name "imagick-decoder"); do
-  echo bin/plugins/${plugdir##*/}.so
+  if [ -d "$plugdir/so" ]; then
+    echo bin/plugins/${plugdir##*/}.so
+  fi
 done
@@ -0,0 +1,119 @@
+// Package auth implements the pieces of the IIIF Authorization Flow API 2.0
+// which don't depend on RAIS's HTTP handling: credential checking, signed
+// tokens, and the JSON structures for services and their responses.  It also
+// signs expiring image URLs, a lighter-weight alternative to the full flow.
+package auth
+
+import "rais/src/presentation"
+
+// Context is the JSON-LD context URI for Authorization Flow 2.0 responses
+const Context = "http://iiif.io/api/auth/2/context.json"
+
+// CredentialChecker verifies a user's login credentials.  Implementations
+// must be safe for concurrent use.
+type CredentialChecker interface {
+	CheckCredentials(username, password string) (bool, error)
+}
+
+// ProbeService is the service block added to a restricted image's info.json.
+// Clients call it to find out whether they can see the image, and it links to
+// the services used for logging in.
+type ProbeService struct {
+	ID      string          `json:"id"`
+	Type    string          `json:"type"`
+	Service []AccessService `json:"service"`
+}
+
+// AccessService describes the page a user visits to log in
+type AccessService struct {
+	ID      string             `json:"id"`
+	Type    string             `json:"type"`
+	Profile string             `json:"profile"`
+	Label   presentation.Label `json:"label"`
+	Service []interface{}      `json:"service"`
+}
+
+// TokenService describes the endpoint clients use to get an access token
+// once the user has logged in
+type TokenService struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// LogoutService describes the endpoint which logs the user out
+type LogoutService struct {
+	ID    string             `json:"id"`
+	Type  string             `json:"type"`
+	Label presentation.Label `json:"label"`
+}
+
+// Endpoints holds the URLs of the access, token, and logout services, which
+// are the same for all images
+type Endpoints struct {
+	Access string
+	Token  string
+	Logout string
+}
+
+// NewProbeService returns the service block for an image whose probe service
+// lives at the given URL
+func NewProbeService(probe string, e Endpoints) ProbeService {
+	return ProbeService{
+		ID:   probe,
+		Type: "AuthProbeService2",
+		Service: []AccessService{{
+			ID:      e.Access,
+			Type:    "AuthAccessService2",
+			Profile: "active",
+			Label:   presentation.NewLabel("Log in to view this image"),
+			Service: []interface{}{
+				TokenService{ID: e.Token, Type: "AuthAccessTokenService2"},
+				LogoutService{ID: e.Logout, Type: "AuthLogoutService2", Label: presentation.NewLabel("Log out")},
+			},
+		}},
+	}
+}
+
+// ProbeResult is the response from a probe service
+type ProbeResult struct {
+	Context string             `json:"@context"`
+	Type    string             `json:"type"`
+	Status  int                `json:"status"`
+	Heading presentation.Label `json:"heading,omitempty"`
+	Note    presentation.Label `json:"note,omitempty"`
+}
+
+// NewProbeResult returns a probe response with the given HTTP status, which
+// is 200 when the user can see the image, and 401 when they can't
+func NewProbeResult(status int) ProbeResult {
+	var r = ProbeResult{Context: Context, Type: "AuthProbeResult2", Status: status}
+	if status != 200 {
+		r.Heading = presentation.NewLabel("This image is restricted")
+		r.Note = presentation.NewLabel("Please log in to view it")
+	}
+	return r
+}
+
+// AccessToken is the token service's response when a user is logged in
+type AccessToken struct {
+	Context     string `json:"@context"`
+	Type        string `json:"type"`
+	AccessToken string `json:"accessToken"`
+	ExpiresIn   int    `json:"expiresIn"`
+	MessageID   string `json:"messageId"`
+}
+
+// AccessTokenError is the token service's response when a token can't be
+// issued.  Profile is one of the error profiles defined by the
+// specification, such as "missingAspect" when the user isn't logged in.
+type AccessTokenError struct {
+	Context   string `json:"@context"`
+	Type      string `json:"type"`
+	Profile   string `json:"profile"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// NewAccessTokenError returns an error response with the given profile
+func NewAccessTokenError(profile, messageID string) AccessTokenError {
+	return AccessTokenError{Context: Context, Type: "AuthAccessTokenError2", Profile: profile, MessageID: messageID}
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Htpasswd is a CredentialChecker backed by an Apache-style htpasswd file.
+// Only the SHA1 ("{SHA}") and Apache MD5 ("$apr1$") hash formats are
+// supported; bcrypt hashes are rejected when the file is parsed.
+type Htpasswd struct {
+	users map[string]string
+}
+
+// LoadHtpasswd reads and parses the htpasswd file at the given path
+func LoadHtpasswd(path string) (*Htpasswd, error) {
+	var f, err = os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
+
+// ParseHtpasswd reads "user:hash" lines from r.  Blank lines and lines
+// starting with "#" are ignored.
+func ParseHtpasswd(r io.Reader) (*Htpasswd, error) {
+	var h = &Htpasswd{users: make(map[string]string)}
+	var scanner = bufio.NewScanner(r)
+	var lineNum int
+	for scanner.Scan() {
+		lineNum++
+		var line = strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		var parts = strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("line %d: expected \"user:hash\"", lineNum)
+		}
+		var hash = parts[1]
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$apr1$") {
+			return nil, fmt.Errorf("line %d: unsupported hash format for user %q", lineNum, parts[0])
+		}
+		h.users[parts[0]] = hash
+	}
+
+	return h, scanner.Err()
+}
+
+// CheckCredentials implements CredentialChecker
+func (h *Htpasswd) CheckCredentials(username, password string) (bool, error) {
+	var hash, ok = h.users[username]
+	if !ok {
+		return false, nil
+	}
+
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		var sum = sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		var salt = strings.SplitN(hash[len("$apr1$"):], "$", 2)[0]
+		computed = apr1(password, salt)
+	}
+
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1, nil
+}
+
+// apr1 computes Apache's variant of the MD5-based crypt algorithm
+func apr1(password, salt string) string {
+	const magic = "$apr1$"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	var pw = []byte(password)
+
+	var alt = md5.New()
+	alt.Write(pw)
+	alt.Write([]byte(salt))
+	alt.Write(pw)
+	var altSum = alt.Sum(nil)
+
+	var ctx = md5.New()
+	ctx.Write(pw)
+	ctx.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		if i > 16 {
+			ctx.Write(altSum)
+		} else {
+			ctx.Write(altSum[:i])
+		}
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 == 1 {
+			ctx.Write([]byte{0})
+		} else {
+			ctx.Write(pw[:1])
+		}
+	}
+	var final = ctx.Sum(nil)
+
+	// The algorithm's designers wanted it to be slow, so it's rehashed a
+	// thousand times in a pattern which depends on the iteration
+	for i := 0; i < 1000; i++ {
+		var round = md5.New()
+		if i&1 == 1 {
+			round.Write(pw)
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write(pw)
+		}
+		if i&1 == 1 {
+			round.Write(final)
+		} else {
+			round.Write(pw)
+		}
+		final = round.Sum(nil)
+	}
+
+	var out strings.Builder
+	out.WriteString(magic + salt + "$")
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		crypt64(&out, uint(final[g[0]])<<16|uint(final[g[1]])<<8|uint(final[g[2]]), 4)
+	}
+	crypt64(&out, uint(final[11]), 2)
+
+	return out.String()
+}
+
+// crypt64 writes n characters of v using crypt's base64 alphabet, least
+// significant bits first
+func crypt64(out *strings.Builder, v uint, n int) {
+	const alphabet = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	for ; n > 0; n-- {
+		out.WriteByte(alphabet[v&0x3f])
+		v >>= 6
+	}
+}
@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHtpasswd(t *testing.T) {
+	var data = `
+# Generated with htpasswd -s and openssl passwd -apr1
+sha:{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=
+md5:$apr1$abcdefgh$FBwExRW4dCc8aL.OvjpIE1
+`
+	var h, err = ParseHtpasswd(strings.NewReader(data))
+	if err != nil {
+		t.Fatalf("Unable to parse htpasswd data: %s", err)
+	}
+
+	var tests = map[string]struct {
+		user, pass string
+		expected   bool
+	}{
+		"sha":            {user: "sha", pass: "secret", expected: true},
+		"sha bad pass":   {user: "sha", pass: "Secret", expected: false},
+		"apr1":           {user: "md5", pass: "password", expected: true},
+		"apr1 bad pass":  {user: "md5", pass: "passwor", expected: false},
+		"unknown user":   {user: "nobody", pass: "secret", expected: false},
+		"user mixup":     {user: "sha", pass: "password", expected: false},
+		"empty password": {user: "md5", pass: "", expected: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var ok, err = h.CheckCredentials(tc.user, tc.pass)
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			if ok != tc.expected {
+				t.Errorf("Expected CheckCredentials(%q, %q) to be %t", tc.user, tc.pass, tc.expected)
+			}
+		})
+	}
+}
+
+func TestHtpasswdInvalid(t *testing.T) {
+	var tests = map[string]string{
+		"bcrypt":     "user:$2y$05$abcdefghijklmnopqrstuOeXUIQyG2mUw9Y2ON6rJ8pu1qF7L4E3O",
+		"plain text": "user:secret",
+		"no hash":    "user",
+		"no user":    ":{SHA}5en6G6MezRroT3XKqkdPOmY/BfQ=",
+	}
+
+	for name, line := range tests {
+		t.Run(name, func(t *testing.T) {
+			var _, err = ParseHtpasswd(strings.NewReader(line))
+			if err == nil {
+				t.Errorf("Expected an error parsing %q", line)
+			}
+		})
+	}
+}
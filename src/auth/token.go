@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Token errors
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token has expired")
+)
+
+var b64 = base64.RawURLEncoding
+
+// Signer issues and verifies short-lived tokens identifying a user.  Tokens
+// are HMAC-signed rather than stored, so any server with the same secret can
+// verify them, and changing the secret invalidates every outstanding token.
+type Signer struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewSigner returns a Signer which uses the given secret
+func NewSigner(secret []byte) *Signer {
+	return &Signer{secret: secret, now: time.Now}
+}
+
+// Sign returns a token for subject which expires after ttl
+func (s *Signer) Sign(subject string, ttl time.Duration) string {
+	var expires = s.now().Add(ttl).Unix()
+	var payload = b64.EncodeToString([]byte(subject + "|" + strconv.FormatInt(expires, 10)))
+	return payload + "." + b64.EncodeToString(s.mac(payload))
+}
+
+// Verify returns the subject of token if its signature is valid and it
+// hasn't expired
+func (s *Signer) Verify(token string) (string, error) {
+	var parts = strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", ErrInvalidToken
+	}
+
+	var sig, err = b64.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(sig, s.mac(parts[0])) {
+		return "", ErrInvalidToken
+	}
+
+	var payload []byte
+	payload, err = b64.DecodeString(parts[0])
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	var i = strings.LastIndex(string(payload), "|")
+	if i < 0 {
+		return "", ErrInvalidToken
+	}
+
+	var expires int64
+	expires, err = strconv.ParseInt(string(payload[i+1:]), 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+	if s.now().Unix() >= expires {
+		return "", ErrTokenExpired
+	}
+
+	return string(payload[:i]), nil
+}
+
+func (s *Signer) mac(payload string) []byte {
+	var h = hmac.New(sha256.New, s.secret)
+	h.Write([]byte(payload))
+	return h.Sum(nil)
+}
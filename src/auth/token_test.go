@@ -0,0 +1,47 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSigner(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var s = NewSigner([]byte("secret"))
+	s.now = func() time.Time { return now }
+
+	var token = s.Sign("jechols|admin", time.Minute)
+	var subject, err = s.Verify(token)
+	if err != nil {
+		t.Fatalf("Unexpected error verifying token: %s", err)
+	}
+	if subject != "jechols|admin" {
+		t.Errorf("Expected subject %q, got %q", "jechols|admin", subject)
+	}
+
+	var other = NewSigner([]byte("other secret"))
+	other.now = s.now
+	_, err = other.Verify(token)
+	if err != ErrInvalidToken {
+		t.Errorf("Expected a different secret to give ErrInvalidToken, got %v", err)
+	}
+
+	var parts = strings.Split(token, ".")
+	var forged = s.Sign("someone else", time.Hour)
+	_, err = s.Verify(strings.Split(forged, ".")[0] + "." + parts[1])
+	if err != ErrInvalidToken {
+		t.Errorf("Expected a swapped signature to give ErrInvalidToken, got %v", err)
+	}
+
+	_, err = s.Verify("garbage")
+	if err != ErrInvalidToken {
+		t.Errorf("Expected garbage to give ErrInvalidToken, got %v", err)
+	}
+
+	now = now.Add(time.Minute)
+	_, err = s.Verify(token)
+	if err != ErrTokenExpired {
+		t.Errorf("Expected ErrTokenExpired, got %v", err)
+	}
+}
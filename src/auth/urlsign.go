@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Signed URL errors
+var (
+	ErrInvalidSignature = errors.New("missing or invalid signature")
+	ErrSignatureExpired = errors.New("signature has expired")
+)
+
+// Query parameters holding a signed URL's expiry time and signature
+const (
+	ExpiresParam   = "expires"
+	SignatureParam = "signature"
+)
+
+// URLSigner signs and verifies expiring links to an image.  The signature
+// covers the image's id rather than a single URL, so one set of signed query
+// parameters works for the info.json request and every tile a viewer asks
+// for.
+type URLSigner struct {
+	secret []byte
+	now    func() time.Time
+}
+
+// NewURLSigner returns a URLSigner which uses the given secret
+func NewURLSigner(secret []byte) *URLSigner {
+	return &URLSigner{secret: secret, now: time.Now}
+}
+
+// Sign returns the query parameters granting access to id until ttl has
+// passed
+func (s *URLSigner) Sign(id string, ttl time.Duration) url.Values {
+	var expires = strconv.FormatInt(s.now().Add(ttl).Unix(), 10)
+	return url.Values{
+		ExpiresParam:   {expires},
+		SignatureParam: {b64.EncodeToString(s.mac(id, expires))},
+	}
+}
+
+// Verify returns nil if q holds a valid, unexpired signature for id
+func (s *URLSigner) Verify(id string, q url.Values) error {
+	var expires = q.Get(ExpiresParam)
+	var sig, err = b64.DecodeString(q.Get(SignatureParam))
+	if err != nil || len(sig) == 0 || !hmac.Equal(sig, s.mac(id, expires)) {
+		return ErrInvalidSignature
+	}
+
+	var exp int64
+	exp, err = strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if s.now().Unix() >= exp {
+		return ErrSignatureExpired
+	}
+
+	return nil
+}
+
+func (s *URLSigner) mac(id, expires string) []byte {
+	var h = hmac.New(sha256.New, s.secret)
+	h.Write([]byte(id + "\n" + expires))
+	return h.Sum(nil)
+}
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+func TestURLSigner(t *testing.T) {
+	var now = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	var s = NewURLSigner([]byte("secret"))
+	s.now = func() time.Time { return now }
+
+	var q = s.Sign("restricted://map.jp2", time.Hour)
+	if q.Get(ExpiresParam) != "1577840400" {
+		t.Errorf("Expected expiry 1577840400, got %q", q.Get(ExpiresParam))
+	}
+	var err = s.Verify("restricted://map.jp2", q)
+	if err != nil {
+		t.Errorf("Unexpected error verifying signature: %s", err)
+	}
+
+	err = s.Verify("restricted://other.jp2", q)
+	if err != ErrInvalidSignature {
+		t.Errorf("Expected a different id to give ErrInvalidSignature, got %v", err)
+	}
+
+	var tampered = s.Sign("restricted://map.jp2", time.Hour)
+	tampered.Set(ExpiresParam, "1893456000")
+	err = s.Verify("restricted://map.jp2", tampered)
+	if err != ErrInvalidSignature {
+		t.Errorf("Expected an altered expiry to give ErrInvalidSignature, got %v", err)
+	}
+
+	err = s.Verify("restricted://map.jp2", nil)
+	if err != ErrInvalidSignature {
+		t.Errorf("Expected no parameters to give ErrInvalidSignature, got %v", err)
+	}
+
+	var other = NewURLSigner([]byte("other secret"))
+	other.now = s.now
+	err = other.Verify("restricted://map.jp2", q)
+	if err != ErrInvalidSignature {
+		t.Errorf("Expected a different secret to give ErrInvalidSignature, got %v", err)
+	}
+
+	now = now.Add(time.Hour)
+	err = s.Verify("restricted://map.jp2", q)
+	if err != ErrSignatureExpired {
+		t.Errorf("Expected ErrSignatureExpired, got %v", err)
+	}
+}
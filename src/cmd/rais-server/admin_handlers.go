@@ -2,7 +2,10 @@
 
 import (
 	"net/http"
+	"rais/src/auth"
 	"rais/src/iiif"
+	"strconv"
+	"time"
 )
 
 func (s *serverStats) ServeHTTP(w http.ResponseWriter, req *http.Request) {
@@ -32,3 +35,39 @@ func adminPurgeCache(w http.ResponseWriter, req *http.Request) {
 
 	w.Write([]byte("OK"))
 }
+
+// signedURL is the response from the admin URL-signing endpoint
+type signedURL struct {
+	URL     string `json:"url"`
+	Query   string `json:"query"`
+	Expires int64  `json:"expires"`
+}
+
+// adminSignURL mints a signed, expiring info.json URL for the image with the
+// given id.  The query string also works for any other request for the same
+// image, such as tiles.
+func (ih *ImageHandler) adminSignURL(w http.ResponseWriter, req *http.Request) {
+	var id = iiif.ID(req.FormValue("id"))
+	if id == "" {
+		http.Error(w, "id is required", http.StatusBadRequest)
+		return
+	}
+
+	var ttl = time.Hour
+	if val := req.FormValue("ttl"); val != "" {
+		var err error
+		ttl, err = time.ParseDuration(val)
+		if err != nil || ttl <= 0 {
+			http.Error(w, "ttl must be a positive duration, e.g., \"15m\"", http.StatusBadRequest)
+			return
+		}
+	}
+
+	var q = ih.URLSigner.Sign(string(id), ttl)
+	var u = ih.WebPathPrefix + "/" + id.Escaped() + "/info.json?" + q.Encode()
+	if ih.BaseURL != nil {
+		u = ih.BaseURL.Scheme + "://" + ih.BaseURL.Host + u
+	}
+	var expires, _ = strconv.ParseInt(q.Get(auth.ExpiresParam), 10, 64)
+	writeJSON(w, 200, signedURL{URL: u, Query: q.Encode(), Expires: expires})
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"math"
+	"net/http"
+	"net/url"
+	"path"
+	"rais/src/auth"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+// authCookieName is the cookie the access service sets once a user logs in
+const authCookieName = "rais-auth"
+
+var loginPage = template.Must(template.New("login").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Log in</title></head>
+<body>
+{{if .}}<p>{{.}}</p>{{end}}
+<form method="post">
+<p><label>Username <input name="username" autofocus></label></p>
+<p><label>Password <input name="password" type="password"></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+</body>
+</html>
+`))
+
+// loggedInPage closes the window the client opened for the access service,
+// which tells the client to request a token
+var loggedInPage = []byte(`<!DOCTYPE html>
+<html><body><p>You are now logged in.</p><script>window.close();</script></body></html>
+`)
+
+// tokenPage sends the token service's response to the client, which loads it
+// in an iframe and listens for the message
+var tokenPage = template.Must(template.New("token").Parse(`<!DOCTYPE html>
+<html><body><script>window.parent.postMessage({{.Message}}, {{.Origin}});</script></body></html>
+`))
+
+// AuthHandler serves the IIIF Authorization Flow 2.0 services, and decides
+// which images are restricted and how much of them a request may see.
+// AllowedOrigins lists the origins (e.g., "https://viewer.example.org") of
+// the clients the token service will send access tokens to.
+type AuthHandler struct {
+	WebPathPrefix  string
+	Checker        auth.CredentialChecker
+	Signer         *auth.Signer
+	TokenTTL       time.Duration
+	AllowedOrigins []string
+	rules          []AccessRule
+}
+
+// AccessRule describes who may see the images whose ids match Pattern, using
+// the syntax of path.Match, e.g., "restricted/*".  Users who aren't logged in
+// are limited to the Public constraint, or denied entirely if it's nil, while
+// logged-in users are limited to the Authenticated constraint.
+type AccessRule struct {
+	Pattern       string
+	Public        *img.Constraint
+	Authenticated img.Constraint
+}
+
+// noConstraint is the constraint for users who can see an entire image
+var noConstraint = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+// NewAuthHandler returns an AuthHandler serving its services under prefix.
+// No images are restricted until rules are added.
+func NewAuthHandler(prefix string, checker auth.CredentialChecker, signer *auth.Signer, ttl time.Duration) *AuthHandler {
+	return &AuthHandler{WebPathPrefix: prefix, Checker: checker, Signer: signer, TokenTTL: ttl}
+}
+
+// AddRule adds an access rule.  When more than one rule matches an id, the
+// first one added wins.
+func (ah *AuthHandler) AddRule(r AccessRule) error {
+	var _, err = path.Match(r.Pattern, "")
+	if err != nil {
+		return fmt.Errorf("invalid pattern %q: %s", r.Pattern, err)
+	}
+	ah.rules = append(ah.rules, r)
+	return nil
+}
+
+// Restrict denies access to all ids matching pattern unless the user has
+// logged in
+func (ah *AuthHandler) Restrict(pattern string) error {
+	return ah.AddRule(AccessRule{Pattern: pattern, Authenticated: noConstraint})
+}
+
+// rule returns the access rule for the given id, or nil if it isn't
+// restricted
+func (ah *AuthHandler) rule(id iiif.ID) *AccessRule {
+	for i := range ah.rules {
+		if ok, _ := path.Match(ah.rules[i].Pattern, string(id)); ok {
+			return &ah.rules[i]
+		}
+	}
+	return nil
+}
+
+// IsRestricted returns true if the id has an access rule
+func (ah *AuthHandler) IsRestricted(id iiif.ID) bool {
+	return ah.rule(id) != nil
+}
+
+// Access returns the constraint on what the request may see of the given
+// image, or false if the request may not see the image at all
+func (ah *AuthHandler) Access(id iiif.ID, req *http.Request) (img.Constraint, bool) {
+	var r = ah.rule(id)
+	switch {
+	case r == nil:
+		return noConstraint, true
+	case ah.Authorized(req):
+		return r.Authenticated, true
+	case r.Public == nil:
+		return img.Constraint{}, false
+	}
+	return *r.Public, true
+}
+
+// Authorized returns true if the request has a valid access token or login
+// cookie.  Clients send access tokens to the probe service, while browsers
+// send the cookie when requesting images.
+func (ah *AuthHandler) Authorized(req *http.Request) bool {
+	return ah.user(req) != ""
+}
+
+// user returns the logged-in user for the request, if any
+func (ah *AuthHandler) user(req *http.Request) string {
+	var token = strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || token == req.Header.Get("Authorization") {
+		var c, err = req.Cookie(authCookieName)
+		if err != nil {
+			return ""
+		}
+		token = c.Value
+	}
+
+	var user, err = ah.Signer.Verify(token)
+	if err != nil {
+		return ""
+	}
+	return user
+}
+
+// Services returns the info.json service block for a restricted image.
+// base is the scheme and host clients use to reach RAIS.
+func (ah *AuthHandler) Services(base string, id iiif.ID) []interface{} {
+	var prefix = base + ah.WebPathPrefix
+	return []interface{}{auth.NewProbeService(prefix+"/probe/"+id.Escaped(), auth.Endpoints{
+		Access: prefix + "/login",
+		Token:  prefix + "/token",
+		Logout: prefix + "/logout",
+	})}
+}
+
+// Route dispatches requests to the auth services
+func (ah *AuthHandler) Route(w http.ResponseWriter, req *http.Request) {
+	var p = strings.Replace(req.URL.Path, ah.WebPathPrefix, "", 1)
+	switch {
+	case p == "/login":
+		ah.login(w, req)
+	case p == "/token":
+		ah.token(w, req)
+	case p == "/logout":
+		ah.logout(w, req)
+	case strings.HasPrefix(p, "/probe/"):
+		ah.probe(w, req, iiif.URLToID(strings.TrimPrefix(p, "/probe/")))
+	default:
+		http.NotFound(w, req)
+	}
+}
+
+// login is the access service: it shows a login form, and sets the auth
+// cookie when valid credentials are submitted.  Submissions from other sites
+// are rejected so they can't log a user in to an account of their choosing.
+func (ah *AuthHandler) login(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if req.Method != http.MethodPost {
+		loginPage.Execute(w, "")
+		return
+	}
+
+	if !sameOrigin(req) {
+		w.WriteHeader(403)
+		loginPage.Execute(w, "Invalid login request; please try again")
+		return
+	}
+
+	var user, pass = req.PostFormValue("username"), req.PostFormValue("password")
+	var ok, err = ah.Checker.CheckCredentials(user, pass)
+	if err != nil {
+		Logger.Errorf("Unable to check credentials for %q: %s", user, err)
+		w.WriteHeader(500)
+		loginPage.Execute(w, "Unable to log in; please try again later")
+		return
+	}
+	if !ok {
+		w.WriteHeader(401)
+		loginPage.Execute(w, "Invalid username or password")
+		return
+	}
+
+	http.SetCookie(w, ah.cookie(req, ah.Signer.Sign(user, ah.TokenTTL), int(ah.TokenTTL.Seconds())))
+	w.Write(loggedInPage)
+}
+
+// token is the access token service: it gives a client a token for the user
+// identified by the auth cookie.  Browser clients request this in an iframe,
+// so the response is a page which posts the token back to the client.  Only
+// clients served from one of the allowed origins get a token, since any site
+// can embed the page.
+func (ah *AuthHandler) token(w http.ResponseWriter, req *http.Request) {
+	var messageID, origin = req.FormValue("messageId"), req.FormValue("origin")
+	if messageID == "" || origin == "" {
+		writeJSON(w, 400, auth.NewAccessTokenError("invalidRequest", messageID))
+		return
+	}
+
+	var message interface{} = auth.NewAccessTokenError("missingAspect", messageID)
+	var user = ah.user(req)
+	if !ah.allowedOrigin(origin) {
+		message = auth.NewAccessTokenError("invalidOrigin", messageID)
+	} else if user != "" {
+		message = auth.AccessToken{
+			Context:     auth.Context,
+			Type:        "AuthAccessToken2",
+			AccessToken: ah.Signer.Sign(user, ah.TokenTTL),
+			ExpiresIn:   int(ah.TokenTTL.Seconds()),
+			MessageID:   messageID,
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	tokenPage.Execute(w, map[string]interface{}{"Message": message, "Origin": origin})
+}
+
+// allowedOrigin returns true if access tokens may be sent to origin
+func (ah *AuthHandler) allowedOrigin(origin string) bool {
+	origin = normalizeOrigin(origin)
+	for _, o := range ah.AllowedOrigins {
+		if normalizeOrigin(o) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeOrigin returns origin in a form suitable for comparison
+func normalizeOrigin(origin string) string {
+	return strings.TrimSuffix(strings.ToLower(origin), "/")
+}
+
+// sameOrigin returns true if a form submission came from a page on the host
+// it was sent to.  Browsers send an Origin header with form posts, or at least
+// a Referer in older browsers; requests with neither are rejected.
+func sameOrigin(req *http.Request) bool {
+	var source = req.Header.Get("Origin")
+	if source == "" {
+		source = req.Header.Get("Referer")
+	}
+	if source == "" {
+		return false
+	}
+
+	var u, err = url.Parse(source)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, getRequestURL(req).Host)
+}
+
+// logout clears the auth cookie
+func (ah *AuthHandler) logout(w http.ResponseWriter, req *http.Request) {
+	http.SetCookie(w, ah.cookie(req, "", -1))
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte("<!DOCTYPE html>\n<html><body><p>You are now logged out.</p></body></html>\n"))
+}
+
+// probe tells clients whether they can see the given image.  Users limited
+// to a degraded version of an image get a 401 as well, since logging in would
+// let them see more of it.
+func (ah *AuthHandler) probe(w http.ResponseWriter, req *http.Request, id iiif.ID) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if req.Method == http.MethodOptions {
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization")
+		w.WriteHeader(204)
+		return
+	}
+
+	var status = 200
+	if ah.IsRestricted(id) && !ah.Authorized(req) {
+		status = 401
+	}
+	writeJSON(w, 200, auth.NewProbeResult(status))
+}
+
+// cookie returns the auth cookie with the given value.  Clients are usually
+// on another site, so the cookie must be allowed in cross-site requests,
+// which browsers only permit over https.  This is safe because the token
+// service only sends tokens to allowed origins, and logins can't be
+// submitted from other sites.
+func (ah *AuthHandler) cookie(req *http.Request, value string, maxAge int) *http.Cookie {
+	var c = &http.Cookie{
+		Name:     authCookieName,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	}
+	if getRequestURL(req).Scheme == "https" {
+		c.Secure = true
+		c.SameSite = http.SameSiteNoneMode
+	}
+	return c
+}
+
+// writeJSON sends data as a JSON response with the given status
+func writeJSON(w http.ResponseWriter, status int, data interface{}) {
+	var body, err = json.Marshal(data)
+	if err != nil {
+		Logger.Errorf("Unable to marshal JSON response: %s", err)
+		http.Error(w, "server error", 500)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// randomSecret returns a new secret for signing tokens when none is
+// configured
+func randomSecret() []byte {
+	var secret = make([]byte, 32)
+	var _, err = rand.Read(secret)
+	if err != nil {
+		Logger.Fatalf("Unable to generate auth secret: %s", err)
+	}
+	return secret
+}
+
+// accessRulesFile is the structure of the TOML file holding access rules
+type accessRulesFile struct {
+	Rules []struct {
+		IDs           []string
+		Public        *ruleConstraint
+		Authenticated *ruleConstraint
+	} `toml:"Rule"`
+}
+
+// ruleConstraint is a constraint as written in the access rules file, where
+// zero values mean there's no limit
+type ruleConstraint struct {
+	MaxWidth  int
+	MaxHeight int
+	MaxArea   int64
+}
+
+func (rc *ruleConstraint) constraint() img.Constraint {
+	var c = noConstraint
+	if rc == nil {
+		return c
+	}
+	if rc.MaxWidth > 0 {
+		c.Width = rc.MaxWidth
+	}
+	if rc.MaxHeight > 0 {
+		c.Height = rc.MaxHeight
+	}
+	if rc.MaxArea > 0 {
+		c.Area = rc.MaxArea
+	}
+	return c
+}
+
+// loadAccessRules reads the given TOML file and returns the access rules it
+// describes
+func loadAccessRules(filename string) ([]AccessRule, error) {
+	var f accessRulesFile
+	var _, err = toml.DecodeFile(filename, &f)
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []AccessRule
+	for i, r := range f.Rules {
+		if len(r.IDs) == 0 {
+			return nil, fmt.Errorf("rule %d has no ids", i+1)
+		}
+
+		var public *img.Constraint
+		if r.Public != nil {
+			var c = r.Public.constraint()
+			public = &c
+		}
+		for _, pattern := range r.IDs {
+			rules = append(rules, AccessRule{Pattern: pattern, Public: public, Authenticated: r.Authenticated.constraint()})
+		}
+	}
+	return rules, nil
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"rais/src/auth"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+type fakeChecker map[string]string
+
+func (c fakeChecker) CheckCredentials(username, password string) (bool, error) {
+	var p, ok = c[username]
+	return ok && p == password, nil
+}
+
+func testAuthHandler() *AuthHandler {
+	var ah = NewAuthHandler("/auth", fakeChecker{"jane": "secret"}, auth.NewSigner([]byte("key")), time.Hour)
+	ah.Restrict("docker/images/testfile/*")
+	ah.AllowedOrigins = []string{"https://viewer.example.org"}
+	return ah
+}
+
+func TestAuthIsRestricted(t *testing.T) {
+	var ah = testAuthHandler()
+	assert.True(ah.IsRestricted("docker/images/testfile/test-world.jp2"), "pattern match", t)
+	assert.False(ah.IsRestricted("docker/images/other.jp2"), "no match", t)
+	assert.False(ah.IsRestricted("docker/images/testfile/sub/test.jp2"), "* doesn't match slashes", t)
+	assert.True(ah.Restrict("[") != nil, "invalid pattern is an error", t)
+}
+
+func TestAuthLogin(t *testing.T) {
+	var ah = testAuthHandler()
+	var loginFrom = func(origin, user, pass string) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var form = url.Values{"username": {user}, "password": {pass}}
+		var req, _ = http.NewRequest("POST", "https://example.org/auth/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		ah.Route(w, req)
+		return w
+	}
+	var login = func(user, pass string) *fakehttp.ResponseWriter {
+		return loginFrom("https://example.org", user, pass)
+	}
+
+	var w = loginFrom("https://evil.example.com", "jane", "secret")
+	assert.Equal(403, w.StatusCode, "cross-site login is rejected", t)
+	assert.Equal("", w.Headers.Get("Set-Cookie"), "no cookie for a cross-site login", t)
+	w = loginFrom("", "jane", "secret")
+	assert.Equal(403, w.StatusCode, "login without an origin is rejected", t)
+
+	w = login("jane", "bad")
+	assert.Equal(401, w.StatusCode, "bad password", t)
+	assert.Equal("", w.Headers.Get("Set-Cookie"), "no cookie for a bad password", t)
+
+	w = login("jane", "secret")
+	assert.Equal(-1, w.StatusCode, "good password", t)
+	var cookies = (&http.Response{Header: w.Headers}).Cookies()
+	assert.Equal(1, len(cookies), "login sets a cookie", t)
+	var cookie = cookies[0]
+	assert.Equal(authCookieName, cookie.Name, "cookie name", t)
+
+	var req, _ = http.NewRequest("GET", "/iiif/foo", nil)
+	assert.False(ah.Authorized(req), "no cookie means no access", t)
+	req.AddCookie(cookie)
+	assert.True(ah.Authorized(req), "login cookie grants access", t)
+}
+
+func TestAuthToken(t *testing.T) {
+	var ah = testAuthHandler()
+	var token = func(query string, loggedIn bool) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var req, _ = http.NewRequest("GET", "/auth/token?"+query, nil)
+		if loggedIn {
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: ah.Signer.Sign("jane", time.Hour)})
+		}
+		ah.Route(w, req)
+		return w
+	}
+
+	var w = token("origin=https://viewer.example.org", true)
+	assert.Equal(400, w.StatusCode, "missing messageId", t)
+
+	w = token("messageId=1&origin=https://viewer.example.org", false)
+	assert.Equal(-1, w.StatusCode, "token error is still a successful page", t)
+	assert.True(strings.Contains(string(w.Output), "missingAspect"), "not logged in", t)
+
+	w = token("messageId=1&origin=https://viewer.example.org", true)
+	var out = string(w.Output)
+	assert.True(strings.Contains(out, "AuthAccessToken2"), "token issued", t)
+	assert.True(strings.Contains(out, `"https://viewer.example.org"`), "message posted to origin", t)
+
+	w = token("messageId=1&origin=https://evil.example.com", true)
+	out = string(w.Output)
+	assert.False(strings.Contains(out, "AuthAccessToken2"), "no token for other origins", t)
+	assert.True(strings.Contains(out, "invalidOrigin"), "other origins are invalid", t)
+}
+
+func TestAuthProbe(t *testing.T) {
+	var ah = testAuthHandler()
+	var probe = func(id string, token string) auth.ProbeResult {
+		var w = fakehttp.NewResponseWriter()
+		var req, _ = http.NewRequest("GET", "/auth/probe/"+iiif.ID(id).Escaped(), nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		ah.Route(w, req)
+		assert.Equal(200, w.StatusCode, "probe status", t)
+		assert.Equal("*", w.Headers.Get("Access-Control-Allow-Origin"), "CORS header", t)
+
+		var result auth.ProbeResult
+		var err = json.Unmarshal(w.Output, &result)
+		assert.NilError(err, "decoding probe result", t)
+		return result
+	}
+
+	assert.Equal(401, probe("docker/images/testfile/test-world.jp2", "").Status, "restricted", t)
+	assert.Equal(401, probe("docker/images/testfile/test-world.jp2", "junk").Status, "bad token", t)
+	var token = ah.Signer.Sign("jane", time.Hour)
+	assert.Equal(200, probe("docker/images/testfile/test-world.jp2", token).Status, "valid token", t)
+	assert.Equal(200, probe("docker/images/other.jp2", "").Status, "unrestricted", t)
+}
+
+func TestAuthEnforcement(t *testing.T) {
+	var ah = testAuthHandler()
+	var request = func(path string, loggedIn bool) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var reqPath = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/" + path
+		var req, _ = http.NewRequest("GET", reqPath, nil)
+		req.RequestURI = reqPath
+		if loggedIn {
+			req.AddCookie(&http.Cookie{Name: authCookieName, Value: ah.Signer.Sign("jane", time.Hour)})
+		}
+
+		var h = NewImageHandler(rootDir(), "/iiif")
+		h.BaseURL, _ = url.Parse("https://example.org")
+		h.Auth = ah
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	var w = request("info.json", false)
+	assert.Equal(-1, w.StatusCode, "info.json is always served", t)
+	var info iiif.Info
+	var err = json.Unmarshal(w.Output, &info)
+	assert.NilError(err, "decoding info.json", t)
+	assert.Equal(1, len(info.Service), "probe service is advertised", t)
+	var probe = info.Service[0].(map[string]interface{})
+	assert.Equal("https://example.org/auth/probe/docker%2Fimages%2Ftestfile%2Ftest-world.jp2", probe["id"], "probe id", t)
+
+	w = request("full/max/0/default.jpg", false)
+	assert.Equal(401, w.StatusCode, "image requires authorization", t)
+}
+
+func TestAuthAccess(t *testing.T) {
+	var ah = testAuthHandler()
+	var public = img.Constraint{Width: 400, Height: math.MaxInt32, Area: math.MaxInt64}
+	var authenticated = img.Constraint{Width: 600, Height: math.MaxInt32, Area: math.MaxInt64}
+	ah.AddRule(AccessRule{Pattern: "maps/*", Public: &public, Authenticated: authenticated})
+
+	var anon, _ = http.NewRequest("GET", "/iiif/foo", nil)
+	var user, _ = http.NewRequest("GET", "/iiif/foo", nil)
+	user.Header.Set("Authorization", "Bearer "+ah.Signer.Sign("jane", time.Hour))
+
+	var tests = map[string]struct {
+		id       iiif.ID
+		req      *http.Request
+		expected img.Constraint
+		allowed  bool
+	}{
+		"unrestricted":              {id: "other.jp2", req: anon, expected: noConstraint, allowed: true},
+		"restricted, anonymous":     {id: "docker/images/testfile/a.jp2", req: anon, allowed: false},
+		"restricted, authenticated": {id: "docker/images/testfile/a.jp2", req: user, expected: noConstraint, allowed: true},
+		"degraded, anonymous":       {id: "maps/a.jp2", req: anon, expected: public, allowed: true},
+		"degraded, authenticated":   {id: "maps/a.jp2", req: user, expected: authenticated, allowed: true},
+		"first matching rule wins":  {id: "maps/b.jp2", req: anon, expected: public, allowed: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var c, allowed = ah.Access(tc.id, tc.req)
+			assert.Equal(tc.allowed, allowed, "allowed", t)
+			if diff := cmp.Diff(tc.expected, c); diff != "" {
+				t.Errorf("constraint: %s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadAccessRules(t *testing.T) {
+	var f, err = ioutil.TempFile("", "rais-rules-*.toml")
+	if err != nil {
+		t.Fatalf("Unable to create temp file: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString(`
+[[Rule]]
+IDs = ["maps/*", "atlases/*"]
+Public = {MaxWidth = 800, MaxHeight = 800}
+
+[[Rule]]
+IDs = ["private/*"]
+Authenticated = {MaxArea = 4000000}
+`)
+	f.Close()
+
+	var rules []AccessRule
+	rules, err = loadAccessRules(f.Name())
+	assert.NilError(err, "loading rules", t)
+
+	var public = img.Constraint{Width: 800, Height: 800, Area: math.MaxInt64}
+	var expected = []AccessRule{
+		{Pattern: "maps/*", Public: &public, Authenticated: noConstraint},
+		{Pattern: "atlases/*", Public: &public, Authenticated: noConstraint},
+		{Pattern: "private/*", Authenticated: img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: 4000000}},
+	}
+	if diff := cmp.Diff(expected, rules); diff != "" {
+		t.Errorf("rules: %s", diff)
+	}
+}
+
+func TestAuthDegradedInfo(t *testing.T) {
+	var ah = testAuthHandler()
+	var public = img.Constraint{Width: 400, Height: math.MaxInt32, Area: math.MaxInt64}
+	ah.rules = []AccessRule{{Pattern: "docker/images/testfile/*", Public: &public, Authenticated: noConstraint}}
+
+	var getInfo = func(loggedIn bool) *iiif.Info {
+		var w = fakehttp.NewResponseWriter()
+		var reqPath = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json"
+		var req, _ = http.NewRequest("GET", reqPath, nil)
+		req.RequestURI = reqPath
+		if loggedIn {
+			req.Header.Set("Authorization", "Bearer "+ah.Signer.Sign("jane", time.Hour))
+		}
+
+		var h = NewImageHandler(rootDir(), "/iiif")
+		h.Auth = ah
+		h.IIIFRoute(w, req)
+
+		var info = new(iiif.Info)
+		var err = json.Unmarshal(w.Output, info)
+		assert.NilError(err, "decoding info.json", t)
+		return info
+	}
+
+	var info = getInfo(false)
+	assert.Equal(400, info.Profile.MaxWidth, "public max width", t)
+	assert.Equal(int64(262144), info.Profile.MaxArea, "the override's max area is kept", t)
+	var expected = []iiif.TileSize{{Width: 512, ScaleFactors: []int{2}}, {Width: 1024, ScaleFactors: []int{2}}}
+	if diff := cmp.Diff(expected, info.Tiles); diff != "" {
+		t.Errorf("public tiles: %s", diff)
+	}
+
+	info = getInfo(true)
+	assert.Equal(512, info.Profile.MaxWidth, "authenticated max width", t)
+	expected = []iiif.TileSize{{Width: 512, ScaleFactors: []int{1, 2}}, {Width: 1024, ScaleFactors: []int{1, 2}}}
+	if diff := cmp.Diff(expected, info.Tiles); diff != "" {
+		t.Errorf("authenticated tiles: %s", diff)
+	}
+}
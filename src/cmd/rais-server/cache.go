@@ -4,7 +4,10 @@
 package main
 
 import (
+	"net/url"
+	"rais/src/diskcache"
 	"rais/src/iiif"
+	"time"
 
 	lru "github.com/hashicorp/golang-lru"
 	"github.com/spf13/viper"
@@ -12,6 +15,23 @@
 
 var infoCache *lru.Cache
 var tileCache *lru.TwoQueueCache
+var diskTileCache *diskcache.Cache
+var urlCache *lru.Cache
+var urlCacheTTL time.Duration
+
+// cachedTile is an encoded image along with the time it must be discarded,
+// which is zero if it can be kept indefinitely
+type cachedTile struct {
+	data    []byte
+	expires time.Time
+}
+
+// cachedURL is a resolved image URL along with the time it must be looked up
+// again
+type cachedURL struct {
+	u       *url.URL
+	expires time.Time
+}
 
 // setupCaches looks for config for caching and sets up the tile/info caches
 // appropriately.  If they exist, we put their cache expiration functions into
@@ -44,6 +64,156 @@ func setupCaches() {
 		// image, we have to purge the whole cache.
 		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { tileCache.Purge() })
 	}
+
+	var dir = viper.GetString("DiskTileCacheDir")
+	if dir != "" {
+		var mb = viper.GetInt64("DiskTileCacheMB")
+		var policy diskcache.Policy
+		policy, err = diskcache.ParsePolicy(viper.GetString("DiskTileCachePolicy"))
+		if err != nil {
+			Logger.Fatalf("Unable to start disk tile cache: %s", err)
+		}
+		Logger.Debugf("Opening a disk tile cache in %q to hold up to %d MB", dir, mb)
+		diskTileCache, err = diskcache.New(dir, mb*1024*1024, policy)
+		if err != nil {
+			Logger.Fatalf("Unable to start disk tile cache: %s", err)
+		}
+		stats.DiskTileCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, diskTileCache.Purge)
+		// Unlike the in-memory cache, the disk cache groups tiles by image, so we
+		// don't have to throw away everything when a single image changes
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { diskTileCache.RemoveGroup(string(id)) })
+		teardownPlugins = append(teardownPlugins, syncDiskTileCache)
+	}
+
+	ucl := viper.GetInt("URLCacheLen")
+	urlCacheTTL = viper.GetDuration("URLCacheTTL")
+	if ucl > 0 && urlCacheTTL > 0 {
+		urlCache, err = lru.New(ucl)
+		if err != nil {
+			Logger.Fatalf("Unable to start URL cache: %s", err)
+		}
+		stats.URLCache.Enabled = true
+		purgeCachePlugins = append(purgeCachePlugins, urlCache.Purge)
+		expireCachedImagePlugins = append(expireCachedImagePlugins, func(id iiif.ID) { urlCache.Remove(id) })
+	}
+}
+
+// loadURLFromCache returns the cached URL for id, or nil if there isn't one
+// or it has expired
+func loadURLFromCache(id iiif.ID) *url.URL {
+	if urlCache == nil {
+		return nil
+	}
+
+	stats.URLCache.Get()
+	data, ok := urlCache.Get(id)
+	if !ok {
+		return nil
+	}
+	var entry = data.(cachedURL)
+	if time.Now().After(entry.expires) {
+		urlCache.Remove(id)
+		return nil
+	}
+
+	stats.URLCache.Hit()
+	var u = *entry.u
+	return &u
+}
+
+// saveURLToCache caches the URL id resolved to for urlCacheTTL
+func saveURLToCache(id iiif.ID, u *url.URL) {
+	if urlCache == nil {
+		return
+	}
+
+	stats.URLCache.Set()
+	var copied = *u
+	urlCache.Add(id, cachedURL{u: &copied, expires: time.Now().Add(urlCacheTTL)})
+}
+
+// syncDiskTileCache saves the disk cache's usage data so the most valuable
+// tiles are kept across restarts
+func syncDiskTileCache() {
+	var err = diskTileCache.Sync()
+	if err != nil {
+		Logger.Errorf("Unable to save disk tile cache index: %s", err)
+	}
+}
+
+// tileCacheEnabled returns true if any tile cache is set up
+func tileCacheEnabled() bool {
+	return tileCache != nil || diskTileCache != nil
+}
+
+// setupCacheRules loads the rules deciding which requests the tile caches
+// store.  This has to happen after plugins are loaded, since they can add
+// output formats.
+func setupCacheRules() {
+	if !tileCacheEnabled() {
+		return
+	}
+
+	var err error
+	tileCacheRules, err = loadCacheRules()
+	if err != nil {
+		Logger.Fatalf("Unable to set up tile caching: %s", err)
+	}
+}
+
+// loadTileFromCache returns the cached image for the given IIIF URL and cache
+// key.  The in-memory cache is checked first; tiles found on disk are added to
+// it so they're faster to get next time.
+func loadTileFromCache(u *iiif.URL, key string) ([]byte, bool) {
+	if tileCache != nil {
+		stats.TileCache.Get()
+		var data, ok = tileCache.Get(key)
+		if ok {
+			var entry = data.(cachedTile)
+			if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+				stats.TileCache.Hit()
+				return entry.data, true
+			}
+			tileCache.Remove(key)
+		}
+	}
+
+	if diskTileCache != nil {
+		stats.DiskTileCache.Get()
+		var data, expires, ok = diskTileCache.Get(string(u.ID), key)
+		if ok {
+			stats.DiskTileCache.Hit()
+			if tileCache != nil {
+				tileCache.Add(key, cachedTile{data: data, expires: expires})
+			}
+			return data, true
+		}
+	}
+
+	return nil, false
+}
+
+// saveTileToCache stores the encoded image for the given IIIF URL in all tile
+// caches, unless it's too large for the cache rule the URL matched
+func saveTileToCache(u *iiif.URL, key string, rule *cacheRule, data []byte) {
+	if !rule.admits(data) {
+		return
+	}
+
+	var expires = rule.expires()
+	if tileCache != nil {
+		stats.TileCache.Set()
+		tileCache.Add(key, cachedTile{data: data, expires: expires})
+	}
+
+	if diskTileCache != nil {
+		stats.DiskTileCache.Set()
+		var err = diskTileCache.Add(string(u.ID), key, data, expires)
+		if err != nil {
+			Logger.Warnf("Unable to write %q to the disk tile cache: %s", u.Path, err)
+		}
+	}
 }
 
 // purgeCaches removes all cached data
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"rais/src/iiif"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// sizeTypeNames maps the names used in tile cache rules to IIIF size types
+var sizeTypeNames = map[string]iiif.SizeType{
+	"max":     iiif.STMax,
+	"full":    iiif.STFull,
+	"width":   iiif.STScaleToWidth,
+	"height":  iiif.STScaleToHeight,
+	"percent": iiif.STScalePercent,
+	"exact":   iiif.STExact,
+	"bestfit": iiif.STBestFit,
+}
+
+// regionTypeNames maps the names used in tile cache rules to IIIF region
+// types
+var regionTypeNames = map[string]iiif.RegionType{
+	"full":    iiif.RTFull,
+	"square":  iiif.RTSquare,
+	"pixel":   iiif.RTPixel,
+	"percent": iiif.RTPercent,
+}
+
+// cacheRuleConfig is a single entry in the TileCacheRules setting
+type cacheRuleConfig struct {
+	Formats   []string
+	Sizes     []string
+	Regions   []string
+	IDPrefix  string
+	Scheme    string
+	MaxPixels int64
+	MaxWidth  int
+	MaxHeight int
+	MaxBytes  int
+	TTL       time.Duration
+}
+
+// cacheRule decides which requests the tile caches may store.  Empty lists
+// and zero limits match anything.
+type cacheRule struct {
+	formats   map[iiif.Format]bool
+	sizes     map[iiif.SizeType]bool
+	regions   map[iiif.RegionType]bool
+	idPrefix  string
+	scheme    string
+	maxPixels int64
+	maxWidth  int
+	maxHeight int
+	maxBytes  int
+	ttl       time.Duration
+}
+
+// tileCacheRules holds the rules checked, in order, to decide if a request
+// can be cached
+var tileCacheRules []*cacheRule
+
+// defaultCacheRules replicates what RAIS cached before rules were
+// configurable: JPEG tiles no larger than 1024x1024
+var defaultCacheRules = []cacheRuleConfig{
+	{
+		Formats:   []string{"jpg"},
+		Sizes:     []string{"width", "exact", "bestfit"},
+		MaxWidth:  1024,
+		MaxHeight: 1024,
+	},
+}
+
+// loadCacheRules reads the TileCacheRules setting, falling back to the
+// default rules if there isn't one
+func loadCacheRules() ([]*cacheRule, error) {
+	var confs = defaultCacheRules
+	if viper.IsSet("TileCacheRules") {
+		confs = nil
+		var err = viper.UnmarshalKey("TileCacheRules", &confs)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TileCacheRules: %s", err)
+		}
+	}
+
+	var rules = make([]*cacheRule, len(confs))
+	for i, conf := range confs {
+		var r, err = newCacheRule(conf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid TileCacheRules entry %d: %s", i+1, err)
+		}
+		rules[i] = r
+	}
+	return rules, nil
+}
+
+// newCacheRule validates conf and converts it to a cacheRule
+func newCacheRule(conf cacheRuleConfig) (*cacheRule, error) {
+	var r = &cacheRule{
+		idPrefix:  conf.IDPrefix,
+		scheme:    strings.ToLower(conf.Scheme),
+		maxPixels: conf.MaxPixels,
+		maxWidth:  conf.MaxWidth,
+		maxHeight: conf.MaxHeight,
+		maxBytes:  conf.MaxBytes,
+		ttl:       conf.TTL,
+	}
+	if r.maxPixels < 0 || r.maxWidth < 0 || r.maxHeight < 0 || r.maxBytes < 0 || r.ttl < 0 {
+		return nil, fmt.Errorf("limits and TTL may not be negative")
+	}
+
+	if len(conf.Formats) > 0 {
+		r.formats = make(map[iiif.Format]bool)
+		for _, name := range conf.Formats {
+			var f = iiif.StringToFormat(strings.ToLower(name))
+			if !f.Valid() {
+				return nil, fmt.Errorf("unknown format %q", name)
+			}
+			r.formats[f] = true
+		}
+	}
+
+	if len(conf.Sizes) > 0 {
+		r.sizes = make(map[iiif.SizeType]bool)
+		for _, name := range conf.Sizes {
+			var st, ok = sizeTypeNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown size type %q", name)
+			}
+			r.sizes[st] = true
+		}
+	}
+
+	if len(conf.Regions) > 0 {
+		r.regions = make(map[iiif.RegionType]bool)
+		for _, name := range conf.Regions {
+			var rt, ok = regionTypeNames[strings.ToLower(name)]
+			if !ok {
+				return nil, fmt.Errorf("unknown region type %q", name)
+			}
+			r.regions[rt] = true
+		}
+	}
+
+	return r, nil
+}
+
+// matches returns true if the rule allows caching the request.  info is used
+// to figure out the dimensions of the resulting image.
+func (r *cacheRule) matches(u *iiif.URL, info *iiif.Info) bool {
+	if r.formats != nil && !r.formats[u.Format] {
+		return false
+	}
+	if r.sizes != nil && !r.sizes[u.Size.Type] {
+		return false
+	}
+	if r.regions != nil && !r.regions[u.Region.Type] {
+		return false
+	}
+	if !strings.HasPrefix(string(u.ID), r.idPrefix) {
+		return false
+	}
+	if r.scheme != "" {
+		var idURL, err = url.Parse(string(u.ID))
+		if err != nil || strings.ToLower(idURL.Scheme) != r.scheme {
+			return false
+		}
+	}
+
+	if r.maxPixels == 0 && r.maxWidth == 0 && r.maxHeight == 0 {
+		return true
+	}
+
+	// Max sizes can't be computed from the URL alone, but they're never bigger
+	// than the requested region
+	var crop = u.Region.GetCrop(info.Width, info.Height)
+	var size = u.Size.GetResize(crop)
+	var w, h = size.Dx(), size.Dy()
+	if r.maxWidth > 0 && w > r.maxWidth {
+		return false
+	}
+	if r.maxHeight > 0 && h > r.maxHeight {
+		return false
+	}
+	if r.maxPixels > 0 && int64(w)*int64(h) > r.maxPixels {
+		return false
+	}
+	return true
+}
+
+// admits returns true if the rule allows caching an encoded image of the
+// given size
+func (r *cacheRule) admits(data []byte) bool {
+	return r.maxBytes == 0 || len(data) <= r.maxBytes
+}
+
+// expires returns when an image cached under this rule now must be
+// discarded, or the zero time if it never expires
+func (r *cacheRule) expires() time.Time {
+	if r.ttl == 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(r.ttl)
+}
+
+// matchCacheRule returns the first rule which allows caching the request, or
+// nil if none do
+func matchCacheRule(u *iiif.URL, info *iiif.Info) *cacheRule {
+	for _, r := range tileCacheRules {
+		if r.matches(u, info) {
+			return r
+		}
+	}
+	return nil
+}
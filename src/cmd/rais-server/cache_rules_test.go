@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func mustURL(path string) *iiif.URL {
+	var u, err = iiif.NewURL(path)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+func TestDefaultCacheRules(t *testing.T) {
+	var rules, err = loadCacheRules()
+	assert.NilError(err, "loading the default rules", t)
+	tileCacheRules = rules
+	defer func() { tileCacheRules = nil }()
+
+	var info = &iiif.Info{Width: 4000, Height: 3000}
+	var tests = map[string]bool{
+		"foo.jp2/0,0,1024,1024/512,/0/default.jpg":   true,
+		"foo.jp2/0,0,1024,1024/1024,1024/0/gray.jpg": true,
+		"foo.jp2/full/2048,/0/default.jpg":           false,
+		"foo.jp2/full/max/0/default.jpg":             false,
+		"foo.jp2/full/pct:5/0/default.jpg":           false,
+		"foo.jp2/0,0,1024,1024/512,/0/default.png":   false,
+	}
+	for path, expected := range tests {
+		assert.Equal(expected, matchCacheRule(mustURL(path), info) != nil, path, t)
+	}
+}
+
+func TestCacheRules(t *testing.T) {
+	viper.Set("TileCacheRules", []map[string]interface{}{
+		{"IDPrefix": "private/", "MaxPixels": 0, "Formats": []string{"gif"}},
+		{"Sizes": "width,height", "MaxPixels": 40000, "TTL": "1h"},
+		{"Scheme": "s3", "Regions": []string{"full"}, "Formats": "png", "MaxBytes": 10},
+	})
+	defer viper.Reset()
+
+	var rules, err = loadCacheRules()
+	assert.NilError(err, "loading rules", t)
+	tileCacheRules = rules
+	defer func() { tileCacheRules = nil }()
+
+	var info = &iiif.Info{Width: 4000, Height: 2000}
+	var thumb = matchCacheRule(mustURL("foo.jp2/full/200,/0/default.jpg"), info)
+	assert.True(thumb != nil, "200px thumbnails are cached", t)
+	assert.Equal(time.Hour, thumb.ttl, "rule TTL", t)
+	assert.True(time.Until(thumb.expires()) > 59*time.Minute, "expiration is based on the TTL", t)
+	assert.True(matchCacheRule(mustURL("foo.jp2/full/,100/0/default.png"), info) != nil, "any format matches", t)
+	assert.True(matchCacheRule(mustURL("foo.jp2/full/400,/0/default.jpg"), info) == nil, "too many pixels", t)
+	assert.True(matchCacheRule(mustURL("foo.jp2/full/200,100/0/default.jpg"), info) == nil, "size type doesn't match", t)
+
+	var gif = matchCacheRule(mustURL("private%2Ffoo.jp2/full/max/0/default.gif"), info)
+	assert.True(gif != nil, "ID prefix matches", t)
+	assert.True(gif.expires().IsZero(), "no TTL means no expiration", t)
+	assert.True(matchCacheRule(mustURL("public%2Ffoo.jp2/full/max/0/default.gif"), info) == nil, "ID prefix doesn't match", t)
+
+	var png = matchCacheRule(mustURL("s3:%2F%2Fbucket%2Ffoo.tif/full/max/0/default.png"), info)
+	assert.True(png != nil, "ID scheme matches", t)
+	assert.True(png.admits([]byte("0123456789")), "images within the byte limit are admitted", t)
+	assert.False(png.admits([]byte("0123456789a")), "images over the byte limit aren't admitted", t)
+	assert.True(matchCacheRule(mustURL("s3:%2F%2Fbucket%2Ffoo.tif/square/max/0/default.png"), info) == nil, "region doesn't match", t)
+}
+
+func TestInvalidCacheRules(t *testing.T) {
+	defer viper.Reset()
+	var tests = map[string]map[string]interface{}{
+		"unknown format":   {"Formats": "bmp"},
+		"unknown size":     {"Sizes": "huge"},
+		"unknown region":   {"Regions": "center"},
+		"negative limit":   {"MaxWidth": -1},
+		"invalid duration": {"TTL": "forever"},
+	}
+	for name, conf := range tests {
+		viper.Set("TileCacheRules", []map[string]interface{}{conf})
+		var _, err = loadCacheRules()
+		assert.True(err != nil, name, t)
+	}
+}
+
+func TestCacheRulesFromFile(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-config-")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var path = filepath.Join(dir, "rais.toml")
+	var conf = "TileCacheLen = 10\n\n[[TileCacheRules]]\nSizes = [\"width\"]\nMaxWidth = 200\nTTL = \"24h\"\n\n" +
+		"[[TileCacheRules]]\nIDPrefix = \"featured/\"\n\n[plugins.test-a]\nFoo = \"bar\"\n"
+	err = ioutil.WriteFile(path, []byte(conf), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write config: %s", err)
+	}
+	viper.SetConfigFile(path)
+	defer func() {
+		viper.Reset()
+		delete(pluginConfigs, "test-a")
+	}()
+
+	err = readPluginConfigs(path)
+	assert.NilError(err, "reading the config", t)
+	var rules []*cacheRule
+	rules, err = loadCacheRules()
+	assert.NilError(err, "loading rules", t)
+	assert.Equal(2, len(rules), "rules from the config file", t)
+	assert.Equal(24*time.Hour, rules[0].ttl, "first rule's TTL", t)
+	assert.Equal(200, rules[0].maxWidth, "first rule's max width", t)
+	assert.Equal("featured/", rules[1].idPrefix, "second rule's ID prefix", t)
+}
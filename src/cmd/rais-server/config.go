@@ -1,11 +1,16 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"image/color"
 	"math"
 	"net/url"
 	"os"
+	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
@@ -17,17 +22,37 @@ func parseConf() {
 	var defaultAddress = ":12415"
 	var defaultAdminAddress = ":12416"
 	var defaultInfoCacheLen = 10000
+	var defaultDiskTileCacheMB = 1024
+	var defaultDiskTileCachePolicy = "lru"
+	var defaultURLCacheLen = 10000
+	var defaultURLCacheTTL = 5 * time.Minute
 	var defaultLogLevel = logger.Debug.String()
 	var defaultPlugins = "-"
+	var defaultExternalPluginTimeout = 30 * time.Second
 	var defaultJPGQuality = 75
+	var defaultWebPQuality = 75
+	var defaultJP2Rate = 20.0
+	var defaultPDFDPI = 300.0
+	var defaultRotationBackground = "#ffffff"
 
 	// Defaults
 	viper.SetDefault("Address", defaultAddress)
 	viper.SetDefault("AdminAddress", defaultAdminAddress)
 	viper.SetDefault("InfoCacheLen", defaultInfoCacheLen)
+	viper.SetDefault("DiskTileCacheMB", defaultDiskTileCacheMB)
+	viper.SetDefault("DiskTileCachePolicy", defaultDiskTileCachePolicy)
+	viper.SetDefault("URLCacheLen", defaultURLCacheLen)
+	viper.SetDefault("URLCacheTTL", defaultURLCacheTTL)
 	viper.SetDefault("LogLevel", defaultLogLevel)
 	viper.SetDefault("Plugins", defaultPlugins)
+	viper.SetDefault("ExternalPluginTimeout", defaultExternalPluginTimeout)
 	viper.SetDefault("JPGQuality", defaultJPGQuality)
+	viper.SetDefault("WebPQuality", defaultWebPQuality)
+	viper.SetDefault("JP2Lossless", true)
+	viper.SetDefault("JP2Rate", defaultJP2Rate)
+	viper.SetDefault("PDFDPI", defaultPDFDPI)
+	viper.SetDefault("GIFDither", true)
+	viper.SetDefault("RotationBackground", defaultRotationBackground)
 
 	// Allow all configuration to be in environment variables
 	viper.SetEnvPrefix("RAIS")
@@ -43,6 +68,13 @@ func parseConf() {
 			os.Exit(1)
 		}
 	}
+	if viper.ConfigFileUsed() != "" {
+		var err = readPluginConfigs(viper.ConfigFileUsed())
+		if err != nil {
+			fmt.Printf("ERROR: Invalid plugin settings in %s: %s\n", viper.ConfigFileUsed(), err)
+			os.Exit(1)
+		}
+	}
 
 	// CLI flags
 	pflag.String("iiif-base-url", "", "Base URL for RAIS to report in info.json requests "+
@@ -50,6 +82,14 @@ func parseConf() {
 	viper.BindPFlag("IIIFBaseURL", pflag.CommandLine.Lookup("iiif-base-url"))
 	pflag.String("iiif-web-path", "/iiif", `Base path for serving IIIF requests, e.g., "/iiif"`)
 	viper.BindPFlag("IIIFWebPath", pflag.CommandLine.Lookup("iiif-web-path"))
+	pflag.String("iiif-v3-web-path", "", `Base path for serving IIIF 3.0 requests, e.g., "/iiif/3" `+
+		"(defaults to no 3.0-specific path; clients can still request 3.0 info via the Accept header)")
+	viper.BindPFlag("IIIFv3WebPath", pflag.CommandLine.Lookup("iiif-v3-web-path"))
+	pflag.String("presentation-web-path", "", `Base path for serving IIIF Presentation 3.0 manifests, `+
+		`e.g., "/iiif/presentation" (defaults to not serving manifests)`)
+	viper.BindPFlag("PresentationWebPath", pflag.CommandLine.Lookup("presentation-web-path"))
+	pflag.Bool("canonical-redirect", false, "Redirect image requests to their canonical IIIF URL")
+	viper.BindPFlag("CanonicalRedirect", pflag.CommandLine.Lookup("canonical-redirect"))
 	pflag.String("address", defaultAddress, "http service address")
 	viper.BindPFlag("Address", pflag.CommandLine.Lookup("address"))
 	pflag.String("admin-address", defaultAdminAddress, "http service for administrative endpoints")
@@ -58,8 +98,15 @@ func parseConf() {
 	viper.BindPFlag("TilePath", pflag.CommandLine.Lookup("tile-path"))
 	pflag.Int("iiif-info-cache-size", defaultInfoCacheLen, "Maximum cached image info entries (IIIF only)")
 	viper.BindPFlag("InfoCacheLen", pflag.CommandLine.Lookup("iiif-info-cache-size"))
+	pflag.Int("url-cache-size", defaultURLCacheLen, "Maximum cached id-to-URL lookups from IDToURL plugins")
+	viper.BindPFlag("URLCacheLen", pflag.CommandLine.Lookup("url-cache-size"))
+	pflag.Duration("url-cache-ttl", defaultURLCacheTTL, "How long id-to-URL lookups from IDToURL plugins are cached")
+	viper.BindPFlag("URLCacheTTL", pflag.CommandLine.Lookup("url-cache-ttl"))
 	pflag.String("capabilities-file", "", "TOML file describing capabilities, rather than everything RAIS supports")
 	viper.BindPFlag("CapabilitiesFile", pflag.CommandLine.Lookup("capabilities-file"))
+	pflag.Bool("strict-level0", false, "Only serve the tiles and sizes advertised in info.json "+
+		"(always enabled when the capabilities file describes a level 0 server)")
+	viper.BindPFlag("StrictLevel0", pflag.CommandLine.Lookup("strict-level0"))
 	pflag.String("log-level", defaultLogLevel, "Log level: the server will only log notifications at "+
 		"this level and above (must be DEBUG, INFO, WARN, ERROR, or CRIT)")
 	viper.BindPFlag("LogLevel", pflag.CommandLine.Lookup("log-level"))
@@ -72,11 +119,61 @@ func parseConf() {
 	pflag.String("plugins", defaultPlugins, "comma-separated plugin pattern list, e.g., "+
 		`"s3-images.so,datadog.so,json-tracer.so,/opt/rais/plugins/*.so"`)
 	viper.BindPFlag("Plugins", pflag.CommandLine.Lookup("plugins"))
+	pflag.String("external-plugins", "", "comma-separated list of external plugin executables, "+
+		`prefixed with "unix:" to use a Unix socket instead of stdio`)
+	viper.BindPFlag("ExternalPlugins", pflag.CommandLine.Lookup("external-plugins"))
+	pflag.Duration("external-plugin-timeout", defaultExternalPluginTimeout, "How long to wait for an "+
+		"external plugin to respond before restarting it")
+	viper.BindPFlag("ExternalPluginTimeout", pflag.CommandLine.Lookup("external-plugin-timeout"))
 	pflag.Int("jpg-quality", 75, "Quality of JPEG output")
 	viper.BindPFlag("JPGQuality", pflag.CommandLine.Lookup("jpg-quality"))
+	pflag.Int("webp-quality", defaultWebPQuality, "Quality of lossy WebP output")
+	viper.BindPFlag("WebPQuality", pflag.CommandLine.Lookup("webp-quality"))
+	pflag.Bool("webp-lossless", false, "Use lossless compression for WebP output")
+	viper.BindPFlag("WebPLossless", pflag.CommandLine.Lookup("webp-lossless"))
+	pflag.Bool("jp2-lossless", true, "Use lossless compression for JP2 output")
+	viper.BindPFlag("JP2Lossless", pflag.CommandLine.Lookup("jp2-lossless"))
+	pflag.Float64("jp2-rate", defaultJP2Rate, "Compression ratio of lossy JP2 output, e.g., 20 for 20:1")
+	viper.BindPFlag("JP2Rate", pflag.CommandLine.Lookup("jp2-rate"))
+	pflag.Float64("jp2-resolution", 0, "Resolution, in pixels per inch, assumed for source images which "+
+		"don't specify one when writing JP2 output (defaults to omitting the resolution box)")
+	viper.BindPFlag("JP2Resolution", pflag.CommandLine.Lookup("jp2-resolution"))
+	pflag.Float64("pdf-dpi", defaultPDFDPI, "Resolution, in pixels per inch, assumed for source images which "+
+		"don't specify one when computing PDF page sizes")
+	viper.BindPFlag("PDFDPI", pflag.CommandLine.Lookup("pdf-dpi"))
+	pflag.Bool("gif-dither", true, "Use Floyd-Steinberg dithering for GIF output")
+	viper.BindPFlag("GIFDither", pflag.CommandLine.Lookup("gif-dither"))
+	pflag.String("rotation-background", defaultRotationBackground, "Hex color (e.g., \"#ffffff\") used to fill "+
+		"around arbitrarily rotated images when the output format has no transparency")
+	viper.BindPFlag("RotationBackground", pflag.CommandLine.Lookup("rotation-background"))
 	pflag.String("scheme-map", "", "Whitespace-delimited map of scheme to prefix, e.g., "+
 		`"acme=s3://bucket1 marc=s3://bucket2/some/path"`)
 	viper.BindPFlag("SchemeMap", pflag.CommandLine.Lookup("scheme-map"))
+	pflag.String("auth-web-path", "", `Base path for the IIIF Auth 2 services, e.g., "/auth" `+
+		"(defaults to disabling authorization)")
+	viper.BindPFlag("AuthWebPath", pflag.CommandLine.Lookup("auth-web-path"))
+	pflag.String("auth-htpasswd-file", "", "htpasswd file holding credentials for the auth login service")
+	viper.BindPFlag("AuthHtpasswdFile", pflag.CommandLine.Lookup("auth-htpasswd-file"))
+	pflag.String("auth-secret", "", "Secret used to sign access tokens (defaults to a random value "+
+		"generated at startup, which invalidates tokens on restart)")
+	viper.BindPFlag("AuthSecret", pflag.CommandLine.Lookup("auth-secret"))
+	pflag.Duration("auth-token-ttl", time.Hour, "How long access tokens and login cookies are valid")
+	viper.BindPFlag("AuthTokenTTL", pflag.CommandLine.Lookup("auth-token-ttl"))
+	pflag.String("auth-allowed-origins", "", "Whitespace-delimited list of origins, e.g., "+
+		`"https://viewer.example.org", of the clients allowed to receive access tokens`)
+	viper.BindPFlag("AuthAllowedOrigins", pflag.CommandLine.Lookup("auth-allowed-origins"))
+	pflag.String("auth-restricted-ids", "", "Whitespace-delimited list of id patterns requiring "+
+		`authorization, e.g., "restricted/* secret://*"`)
+	viper.BindPFlag("AuthRestrictedIDs", pflag.CommandLine.Lookup("auth-restricted-ids"))
+	pflag.String("auth-rules-file", "", "TOML file describing per-image access rules, including how much "+
+		"of an image users may see with and without logging in")
+	viper.BindPFlag("AuthRulesFile", pflag.CommandLine.Lookup("auth-rules-file"))
+	pflag.String("signed-url-schemes", "", "Whitespace-delimited list of id schemes, e.g., "+
+		`"restricted", whose images may only be requested with a signed, expiring URL`)
+	viper.BindPFlag("SignedURLSchemes", pflag.CommandLine.Lookup("signed-url-schemes"))
+	pflag.String("signed-url-secret", "", "Secret used to sign URLs (defaults to a random value "+
+		"generated at startup, which invalidates signed URLs on restart)")
+	viper.BindPFlag("SignedURLSecret", pflag.CommandLine.Lookup("signed-url-secret"))
 
 	pflag.Parse()
 
@@ -94,6 +191,13 @@ func parseConf() {
 		os.Exit(1)
 	}
 
+	var _, err = parseHexColor(viper.GetString("RotationBackground"))
+	if err != nil {
+		fmt.Printf("ERROR: invalid rotation background: %s\n", err)
+		pflag.Usage()
+		os.Exit(1)
+	}
+
 	var baseIIIFURL = viper.GetString("IIIFBaseURL")
 	if baseIIIFURL != "" {
 		var u, err = url.Parse(baseIIIFURL)
@@ -113,3 +217,59 @@ func parseConf() {
 		}
 	}
 }
+
+// parseHexColor converts a string in the form "#rrggbb" (the hash is
+// optional) to an opaque color
+func parseHexColor(s string) (color.RGBA, error) {
+	var c = color.RGBA{A: 255}
+	var hex = strings.TrimPrefix(s, "#")
+	if len(hex) != 6 {
+		return c, fmt.Errorf("%q must be six hex digits, e.g., \"#ffffff\"", s)
+	}
+
+	var _, err = fmt.Sscanf(hex, "%02x%02x%02x", &c.R, &c.G, &c.B)
+	if err != nil {
+		return c, fmt.Errorf("%q must be six hex digits, e.g., \"#ffffff\"", s)
+	}
+	return c, nil
+}
+
+// pluginConfigs holds each plugin's [plugins.<name>] table from rais.toml
+var pluginConfigs = make(map[string]map[string]interface{})
+
+// readPluginConfigs pulls the "plugins" table out of the config file and
+// hands viper everything else.  Viper doesn't care about a key's case, so it
+// would otherwise confuse the plugin table with the "Plugins" setting.
+func readPluginConfigs(path string) error {
+	var raw map[string]interface{}
+	var _, err = toml.DecodeFile(path, &raw)
+	if err != nil {
+		return err
+	}
+
+	var section, ok = raw["plugins"]
+	if !ok {
+		return nil
+	}
+	var tables map[string]interface{}
+	tables, ok = section.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf(`"plugins" must be a table`)
+	}
+	for name, table := range tables {
+		var cfg map[string]interface{}
+		cfg, ok = table.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf(`"plugins.%s" must be a table`, name)
+		}
+		pluginConfigs[name] = cfg
+	}
+
+	delete(raw, "plugins")
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(raw)
+	if err != nil {
+		return err
+	}
+	return viper.ReadConfig(&buf)
+}
@@ -1,34 +1,38 @@
 package main
 
 import (
-	"errors"
 	"image"
-	"image/gif"
-	"image/jpeg"
-	"image/png"
 	"io"
+	"mime"
+	"rais/src/encode"
 	"rais/src/iiif"
 
 	"github.com/spf13/viper"
-	"golang.org/x/image/tiff"
 )
 
-// ErrInvalidEncodeFormat is the error returned when encoding fails due to a
-// file format RAIS doesn't support
-var ErrInvalidEncodeFormat = errors.New("Unable to encode: unsupported format")
+// Not every system's mime database knows the newer image formats, and we rely
+// on it for setting the Content-Type header
+func init() {
+	mime.AddExtensionType(".jp2", "image/jp2")
+	mime.AddExtensionType(".webp", "image/webp")
+}
 
-// EncodeImage uses the built-in image libs to write an image to the browser
-func EncodeImage(w io.Writer, img image.Image, format iiif.Format) error {
-	switch format {
-	case iiif.FmtJPG:
-		return jpeg.Encode(w, img, &jpeg.Options{Quality: viper.GetInt("JPGQuality")})
-	case iiif.FmtPNG:
-		return png.Encode(w, img)
-	case iiif.FmtGIF:
-		return gif.Encode(w, img, &gif.Options{NumColors: 256})
-	case iiif.FmtTIF:
-		return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+// encodeOptions returns the encoder settings from RAIS's configuration
+func encodeOptions() *encode.Options {
+	return &encode.Options{
+		JPGQuality:    viper.GetInt("JPGQuality"),
+		GIFDither:     viper.GetBool("GIFDither"),
+		JP2Lossless:   viper.GetBool("JP2Lossless"),
+		JP2Rate:       viper.GetFloat64("JP2Rate"),
+		JP2Resolution: viper.GetFloat64("JP2Resolution"),
+		PDFDPI:        viper.GetFloat64("PDFDPI"),
+		WebPQuality:   viper.GetInt("WebPQuality"),
+		WebPLossless:  viper.GetBool("WebPLossless"),
 	}
+}
 
-	return ErrInvalidEncodeFormat
+// EncodeImage uses the built-in image libs to write an image to the browser.
+// The resolution is only used by formats which can store physical dimensions.
+func EncodeImage(w io.Writer, img image.Image, format iiif.Format, r encode.OutputResolution) error {
+	return encode.Image(w, img, format, r, encodeOptions())
 }
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"rais/src/img"
+	"rais/src/plugins/external"
+	"strings"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// LoadExternalPlugins starts each external plugin in the list, in order, and
+// indexes the hooks it implements.  Items prefixed with "unix:" talk to RAIS
+// over a Unix socket; all others use stdio.
+func LoadExternalPlugins(l *logger.Logger, commands []string, timeout time.Duration) {
+	for _, command := range commands {
+		var transport = external.TransportStdio
+		if strings.HasPrefix(command, "unix:") {
+			transport = external.TransportUnix
+			command = strings.TrimPrefix(command, "unix:")
+		}
+
+		l.Infof("Starting external plugin %q", command)
+		var p, err = external.Start(command, transport, timeout, l)
+		if err != nil {
+			l.Errorf("Unable to start %q: %s", command, err)
+			continue
+		}
+		enableExternalPlugin(p, l)
+	}
+}
+
+// enableExternalPlugin sends the plugin its settings, then indexes its hooks
+// globally, the same as with in-process plugins.  The plugin process is shut
+// down with the server.
+func enableExternalPlugin(p *external.Plugin, l *logger.Logger) {
+	var ps = plugStats{
+		Name:       p.Name(),
+		Path:       p.Command(),
+		APIVersion: external.ProtocolVersion,
+		Config:     pluginConfigs[p.Name()],
+	}
+	defer func() { stats.Plugins = append(stats.Plugins, ps) }()
+
+	var err = p.Configure(pluginConfigs[p.Name()])
+	if err != nil {
+		l.Errorf("External plugin %q failed to initialize: %s", p.Name(), err)
+		p.Close()
+		return
+	}
+	ps.Enabled = true
+
+	for _, hook := range p.Hooks() {
+		switch hook {
+		case external.HookConfigure:
+			// Configure has already been called
+		case external.HookIDToURL:
+			idToURLPlugins = append(idToURLPlugins, p.IDToURL)
+		case external.HookExpireCachedImage:
+			expireCachedImagePlugins = append(expireCachedImagePlugins, p.ExpireCachedImage)
+		case external.HookPurgeCaches:
+			purgeCachePlugins = append(purgeCachePlugins, p.PurgeCaches)
+		case external.HookStreamReader:
+			img.RegisterStreamReader(p.StreamReader)
+		case external.HookDecodeHandler:
+			img.RegisterDecodeHandler(p.DecodeHandler)
+		default:
+			l.Warnf("Ignoring unknown hook %q in external plugin %q", hook, p.Name())
+			continue
+		}
+		ps.Functions = append(ps.Functions, hook)
+	}
+	teardownPlugins = append(teardownPlugins, p.Close)
+}
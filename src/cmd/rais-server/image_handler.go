@@ -5,6 +5,7 @@
 	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
 	"math"
@@ -12,33 +13,78 @@
 	"net/http"
 	"net/url"
 	"path"
+	"rais/src/auth"
+	"rais/src/encode"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/plugins"
+	"rais/src/transform"
 	"strconv"
 	"strings"
 )
 
-func acceptsLD(req *http.Request) bool {
+// acceptedTypes returns the media types and their parameters listed in the
+// request's Accept header(s).  Unparseable entries are skipped.
+func acceptedTypes(req *http.Request) (types []string, params []map[string]string) {
 	for _, h := range req.Header["Accept"] {
 		for _, accept := range strings.Split(h, ",") {
-			if accept == "application/ld+json" {
-				return true
+			var mt, p, err = mime.ParseMediaType(accept)
+			if err != nil {
+				continue
 			}
+			types = append(types, mt)
+			params = append(params, p)
+		}
+	}
+
+	return types, params
+}
+
+func acceptsLD(req *http.Request) bool {
+	var types, _ = acceptedTypes(req)
+	for _, mt := range types {
+		if mt == "application/ld+json" {
+			return true
 		}
 	}
 
 	return false
 }
 
+// acceptsProfile returns the IIIF API version requested via the "profile"
+// parameter of a JSON or JSON-LD Accept header, or 0 if no known profile was
+// requested
+func acceptsProfile(req *http.Request) iiif.Version {
+	var types, params = acceptedTypes(req)
+	for i, mt := range types {
+		if mt != "application/ld+json" && mt != "application/json" {
+			continue
+		}
+		var v = iiif.ContextToVersion(params[i]["profile"])
+		if v != 0 {
+			return v
+		}
+	}
+
+	return 0
+}
+
 // ImageHandler responds to a IIIF URL request and parses the requested
 // transformation within the limits of the handler's capabilities
 type ImageHandler struct {
-	BaseURL       *url.URL
-	WebPathPrefix string
-	FeatureSet    *iiif.FeatureSet
-	TilePath      string
-	Maximums      img.Constraint
-	schemeMap     map[string]string
+	BaseURL                   *url.URL
+	WebPathPrefix             string
+	V3WebPathPrefix           string
+	PresentationWebPathPrefix string
+	CanonicalRedirect         bool
+	StrictLevel0              bool
+	Auth                      *AuthHandler
+	URLSigner                 *auth.URLSigner
+	SignedSchemes             []string
+	FeatureSet                *iiif.FeatureSet
+	TilePath                  string
+	Maximums                  img.Constraint
+	schemeMap                 map[string]string
 }
 
 // NewImageHandler sets up a base ImageHandler with no features
@@ -88,13 +134,70 @@ func (ih *ImageHandler) AddSchemeMap(scheme, prefix string) error {
 	return nil
 }
 
-// cacheKey returns a key for caching if a given IIIF URL is cacheable by our
-// current, somewhat restrictive, rules
-func cacheKey(u *iiif.URL) string {
-	if tileCache != nil && u.Format == iiif.FmtJPG && u.Size.W > 0 && u.Size.W <= 1024 && u.Size.H <= 1024 {
-		return u.Path
+// cacheKey returns a key for caching if a given IIIF URL is cacheable by the
+// tile cache rules, along with the rule which allowed it
+func cacheKey(u *iiif.URL, info *iiif.Info) (string, *cacheRule) {
+	if !tileCacheEnabled() {
+		return "", nil
+	}
+	var rule = matchCacheRule(u, info)
+	if rule == nil {
+		return "", nil
+	}
+
+	// The same path can mean different things to different IIIF versions, and
+	// the encoders' and TransformImage plugins' output is what gets cached, so
+	// the key has to change whenever any of them do.  This matters most for
+	// the disk cache, which outlives changes to the configuration.
+	var key = fmt.Sprintf("%s|v%d|%s", u.Path, u.Version, encodeOptions().Fingerprint())
+	if len(transformKeys) > 0 {
+		key += "|" + strings.Join(transformKeys, ",")
+	}
+	return key, rule
+}
+
+// isRestricted returns true if auth is enabled and the given image requires
+// authorization
+func (ih *ImageHandler) isRestricted(id iiif.ID) bool {
+	return ih.Auth != nil && ih.Auth.IsRestricted(id)
+}
+
+// requiresSignature returns true if the id's scheme is one which may only be
+// requested with a signed URL
+func (ih *ImageHandler) requiresSignature(id iiif.ID) bool {
+	if ih.URLSigner == nil {
+		return false
+	}
+
+	var scheme string
+	var u, err = url.Parse(string(id))
+	if err == nil {
+		scheme = u.Scheme
+	}
+	for _, s := range ih.SignedSchemes {
+		if s == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// access returns the constraint on what the request may see of the given
+// image, or false if it may not see the image at all
+func (ih *ImageHandler) access(id iiif.ID, req *http.Request) (img.Constraint, bool) {
+	if ih.Auth == nil {
+		return noConstraint, true
 	}
-	return ""
+	return ih.Auth.Access(id, req)
+}
+
+// cacheKey returns the tile cache key and rule for the URL, or an empty
+// string if the request mustn't be cached
+func (ih *ImageHandler) cacheKey(u *iiif.URL, info *iiif.Info) (string, *cacheRule) {
+	if ih.isRestricted(u.ID) {
+		return "", nil
+	}
+	return cacheKey(u, info)
 }
 
 // getRequestURL determines the "real" request URL.  Proxies are supported by
@@ -119,6 +222,33 @@ func getRequestURL(req *http.Request) *url.URL {
 	return u
 }
 
+// requestBaseURL returns a URL with just the scheme and host clients should
+// use to reach RAIS: the IIIF base URL if one was configured, otherwise
+// whatever the request says
+func (ih *ImageHandler) requestBaseURL(req *http.Request) *url.URL {
+	if ih.BaseURL != nil {
+		return &url.URL{Scheme: ih.BaseURL.Scheme, Host: ih.BaseURL.Host}
+	}
+	return getRequestURL(req)
+}
+
+// requestVersion returns the web path prefix the given path falls under, and
+// the IIIF API version that prefix serves.  Requests which explicitly ask for
+// a given version's JSON-LD profile get that version regardless of prefix.
+func (ih *ImageHandler) requestVersion(req *http.Request, pth string) (string, iiif.Version) {
+	var prefix, version = ih.WebPathPrefix, iiif.V2
+	if ih.V3WebPathPrefix != "" && strings.HasPrefix(pth, ih.V3WebPathPrefix+"/") {
+		prefix, version = ih.V3WebPathPrefix, iiif.V3
+	}
+
+	var v = acceptsProfile(req)
+	if v != 0 {
+		version = v
+	}
+
+	return prefix, version
+}
+
 // IIIFRoute takes an HTTP request and parses it to see what (if any) IIIF
 // translation is requested
 func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
@@ -132,75 +262,69 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 
 	// Figure out the hostname, scheme, port, etc. either from the request or the
 	// setting if it was explicitly set
-	if ih.BaseURL != nil {
-		u.Host = ih.BaseURL.Host
-		u.Scheme = ih.BaseURL.Scheme
-	} else {
-		var u2 = getRequestURL(req)
-		if u2 != nil {
-			u.Host = u2.Host
-			u.Scheme = u2.Scheme
-		}
-	}
+	var base = ih.requestBaseURL(req)
+	u.Host = base.Host
+	u.Scheme = base.Scheme
 
 	// Strip the IIIF web path off the beginning of the path to determine the
 	// actual request.  This should always work because a request shouldn't be
-	// able to get here if it didn't have our prefix.
-	var prefix = ih.WebPathPrefix + "/"
-	u.Path = strings.Replace(u.Path, prefix, "", 1)
+	// able to get here if it didn't have one of our prefixes.
+	var prefix, version = ih.requestVersion(req, u.Path)
+	u.Path = strings.Replace(u.Path, prefix+"/", "", 1)
 
-	iiifURL, err := iiif.NewURL(u.Path)
+	iiifURL, err := iiif.NewVersionedURL(u.Path, version)
 	// If the iiifURL is invalid, it's possible this is a base URI request.
 	// Let's see if treating the path as an ID gives us any info.
 	if err != nil {
-		if ih.isValidBasePath(u.Path) {
-			http.Redirect(w, req, req.URL.String()+"/info.json", 303)
-		} else {
+		var e = ih.checkBasePath(req, u.Path, version, base, prefix)
+		switch {
+		case e == nil:
+			var infoURL = req.URL.EscapedPath() + "/info.json"
+			if req.URL.RawQuery != "" {
+				infoURL += "?" + req.URL.RawQuery
+			}
+			http.Redirect(w, req, infoURL, 303)
+		case e.Code == 403:
+			http.Error(w, e.Message, e.Code)
+		default:
 			http.Error(w, fmt.Sprintf("Invalid IIIF request %q: %s", iiifURL.Path, err), 400)
 		}
 		return
 	}
 
-	// Grab the image resource and info data
-	res, info, e := ih.getImageData(iiifURL.ID)
+	var res, info, pluginMax, e = ih.describeImage(req, iiifURL, base, prefix)
 	if e != nil {
-		if e.Code != 404 {
-			Logger.Errorf("Error getting image and/or IIIF Info for %q: %s", iiifURL.ID, e.Message)
-		}
 		http.Error(w, e.Message, e.Code)
 		return
 	}
-
 	defer res.Destroy()
 
-	// Make sure the info JSON has the proper asset id, which, for some reason in
-	// the IIIF spec, requires the full URL to the asset, not just its identifier
-	infourl := &url.URL{
-		Scheme: u.Scheme,
-		Host:   u.Host,
-		Path:   ih.WebPathPrefix,
-	}
-
-	// Because of how Go's URL path magic works, we really do have to just
-	// concatenate these two things with a slash manually
-	info.ID = infourl.String() + "/" + iiifURL.ID.Escaped()
+	var infourl = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: prefix}
 
 	if iiifURL.Info {
 		ih.Info(w, req, info)
 		return
 	}
 
-	// Check the cache before spending the cycles to read in the image.  For now
-	// the cache is very limited to ensure only relatively small requests are
-	// actually cached.
-	if key := cacheKey(iiifURL); key != "" {
-		stats.TileCache.Get()
-		data, ok := tileCache.Get(key)
-		if ok {
-			stats.TileCache.Hit()
-			w.Header().Set("Content-Type", mime.TypeByExtension("."+string(iiifURL.Format)))
-			w.Write(data.([]byte))
-			return
+	// Valid image requests get redirected to their canonical form if we're
+	// set up to do so, and otherwise tell clients what that form is
+	if iiifURL.Valid() {
+		var canonical = ih.canonicalURL(iiifURL, info)
+		if canonical != nil {
+			var canonicalURL = infourl.String() + "/" + canonical.String()
+			if ih.CanonicalRedirect && canonical.ParamString() != iiifURL.RequestedParams() {
+				if req.URL.RawQuery != "" {
+					canonicalURL += "?" + req.URL.RawQuery
+				}
+				http.Redirect(w, req, canonicalURL, 301)
+				return
+			}
+			if ih.FeatureSet.CanonicalLinkHeader {
+				w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="canonical"`, canonicalURL))
+			}
+		}
+		if ih.FeatureSet.ProfileLinkHeader {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="profile"`, info.ProfileURI()))
 		}
 	}
 
@@ -210,24 +334,210 @@ func (ih *ImageHandler) IIIFRoute(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if e := ih.checkFeatures(iiifURL, info); e != nil {
+		http.Error(w, e.Message, e.Code)
+		return
+	}
+
+	// Check the cache before spending the cycles to read in the image.  Only
+	// requests matching the tile cache rules are cached.  Restricted images are
+	// never cached, since a cache hit would skip the authorization check, and
+	// requests a plugin has limited don't read from the cache, since a cached
+	// image may exceed their limits.
+	if key, _ := ih.cacheKey(iiifURL, info); key != "" && pluginMax == nil {
+		data, ok := loadTileFromCache(iiifURL, key)
+		if ok {
+			w.Header().Set("Content-Type", mime.TypeByExtension("."+string(iiifURL.Format)))
+			w.Write(data)
+			return
+		}
+	}
+
 	// Attempt to run the command
-	ih.Command(w, req, iiifURL, res, info)
+	ih.Command(w, req, iiifURL, res, info, pluginMax)
 }
 
-// isValidBasePath returns true if the given path is simply missing /info.json
-// to function properly
-func (ih *ImageHandler) isValidBasePath(path string) bool {
-	var jsonPath = path + "/info.json"
-	var iiifURL, err = iiif.NewURL(jsonPath)
+// describeImage runs the checks every request which reveals anything about
+// an image has to pass (signed URLs and Authorize plugins), then reads the
+// image and describes it as the request is allowed to see it.  The returned
+// constraint is the Authorize plugins' size limit, if any, and the resource
+// must be destroyed by the caller.
+func (ih *ImageHandler) describeImage(req *http.Request, u *iiif.URL, base *url.URL, prefix string) (*img.Resource, *iiif.Info, *img.Constraint, *HandlerError) {
+	// Images under schemes which require signed URLs are rejected before we
+	// even look them up
+	if ih.requiresSignature(u.ID) {
+		var err = ih.URLSigner.Verify(string(u.ID), req.URL.Query())
+		if err != nil {
+			return nil, nil, nil, NewError("Forbidden: "+err.Error(), 403)
+		}
+	}
+
+	// Authorize plugins get their say before we look up the image
+	var allowed, pluginMax, err = authorize(req, u)
 	if err != nil {
-		return false
+		Logger.Errorf("Error running Authorize plugins on %q: %s", u.ID, err)
+		return nil, nil, nil, NewError("server error", 500)
+	}
+	if !allowed {
+		return nil, nil, nil, NewError("Forbidden", 403)
+	}
+
+	// Grab the image resource and info data
+	res, info, e := ih.getImageData(u.ID)
+	if e != nil {
+		if e.Code != 404 {
+			Logger.Errorf("Error getting image and/or IIIF Info for %q: %s", u.ID, e.Message)
+		}
+		return nil, nil, nil, e
+	}
+
+	// Make sure the info JSON has the proper asset id, which, for some reason in
+	// the IIIF spec, requires the full URL to the asset, not just its
+	// identifier.  Because of how Go's URL path magic works, we really do have
+	// to just concatenate these two things with a slash manually.
+	var infourl = &url.URL{Scheme: base.Scheme, Host: base.Host, Path: prefix}
+	info.ID = infourl.String() + "/" + u.ID.Escaped()
+	info.Version = u.Version
+
+	if pluginMax != nil {
+		ih.limitSizes(info, *pluginMax)
+	}
+
+	// Plugins can add to info.json responses, but there's no point in running
+	// them for image requests
+	if u.Info {
+		var err = augmentInfo(u.ID, info)
+		if err != nil {
+			res.Destroy()
+			Logger.Errorf("Error running AugmentInfo plugins on %q: %s", u.ID, err)
+			return nil, nil, nil, NewError("server error", 500)
+		}
+	}
+
+	// Restricted images tell clients how to get access, and describe only what
+	// the user is allowed to see.  Users who can't see the image at all get the
+	// full description so clients know what they'd get by logging in.
+	if ih.isRestricted(u.ID) {
+		info.Service = append(info.Service, ih.Auth.Services(base.String(), u.ID)...)
+		if max, ok := ih.Auth.Access(u.ID, req); ok {
+			ih.limitInfo(info, max)
+		}
+	}
+
+	return res, info, pluginMax, nil
+}
+
+// transformImage runs the TransformImage plugins over m, in the order they
+// were loaded, each one getting the previous one's output.  Plugins which
+// return ErrSkipped leave the image alone.
+//
+// The results are what get cached, under a key built from the request and
+// each plugin's TransformVersion (or settings), so plugins must produce the
+// same output whenever they're given the same id and URL, unless their
+// version changes.
+func transformImage(u *iiif.URL, m image.Image) (image.Image, error) {
+	for _, plug := range transformImagePlugins {
+		var m2, err = plug(u.ID, u, m)
+		if err == plugins.ErrSkipped {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		m = m2
+	}
+	return m, nil
+}
+
+// authorize asks the Authorize plugins, in the order they were loaded, whether
+// the request may proceed.  Any plugin can deny it.  The returned constraint
+// is the smallest of those the plugins set, or nil if none set one.  Plugins
+// which return ErrSkipped have no say.
+func authorize(req *http.Request, u *iiif.URL) (bool, *img.Constraint, error) {
+	var max *img.Constraint
+	for _, plug := range authorizePlugins {
+		var allowed, c, err = plug(req, u.ID, u)
+		if err == plugins.ErrSkipped {
+			continue
+		}
+		if err != nil {
+			return false, nil, err
+		}
+		if !allowed {
+			return false, nil, nil
+		}
+		if c != nil {
+			var m = *c
+			if max != nil {
+				m = max.Min(m)
+			}
+			max = &m
+		}
+	}
+	return true, max, nil
+}
+
+// augmentInfo runs the AugmentInfo plugins, in the order they were loaded,
+// letting them add services, rights, and other properties to info.  Plugins
+// which return ErrSkipped aren't treated as errors.
+func augmentInfo(id iiif.ID, info *iiif.Info) error {
+	for _, plug := range augmentInfoPlugins {
+		var err = plug(id, info)
+		if err != nil && err != plugins.ErrSkipped {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkBasePath returns nil if the given path is simply missing /info.json
+// to function properly.  The path gets the same checks as the info request,
+// so base URIs can't be used to find out which images exist.
+func (ih *ImageHandler) checkBasePath(req *http.Request, pth string, v iiif.Version, base *url.URL, prefix string) *HandlerError {
+	var iiifURL, err = iiif.NewVersionedURL(pth+"/info.json", v)
+	if err != nil {
+		return NewError(err.Error(), 400)
+	}
+
+	var res, _, _, e = ih.describeImage(req, iiifURL, base, prefix)
+	if e != nil {
+		return e
+	}
+	res.Destroy()
+	return nil
+}
+
+// resolveURL returns the URL of the image with the given id.  IDToURL plugins
+// are asked first, in the order they were loaded, and the scheme map handles
+// any id they all skip.  When there are plugins, results are cached, since
+// plugins often have to look ids up somewhere slow, like a database.
+func (ih *ImageHandler) resolveURL(id iiif.ID) (*url.URL, error) {
+	if len(idToURLPlugins) == 0 {
+		return ih.getURL(id), nil
+	}
+
+	var u = loadURLFromCache(id)
+	if u != nil {
+		return u, nil
 	}
 
-	var res, _, e = ih.getImageData(iiifURL.ID)
-	if res != nil {
-		res.Destroy()
+	for _, plug := range idToURLPlugins {
+		var pu, err = plug(id)
+		if err == plugins.ErrSkipped {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		u = pu
+		break
+	}
+
+	if u == nil {
+		u = ih.getURL(id)
 	}
-	return e == nil
+	saveURLToCache(id, u)
+	return u, nil
 }
 
 // getURL converts a IIIF ID into a URL.  If the ID has no scheme, we assume
@@ -292,12 +602,17 @@ func (ih *ImageHandler) Info(w http.ResponseWriter, req *http.Request, info *iii
 		return
 	}
 
-	// Set headers - content type is dependent on client
+	// Set headers - content type is dependent on client.  3.0 requires the
+	// profile to be part of the JSON-LD media type.
 	ct := "application/json"
 	if acceptsLD(req) {
 		ct = "application/ld+json"
+		if info.Version == iiif.V3 {
+			ct += `;profile="` + iiif.ContextV3 + `"`
+		}
 	}
 	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Vary", "Accept")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Write(json)
 }
@@ -314,13 +629,22 @@ func newImageResError(err error) *HandlerError {
 	if errors.Is(err, img.ErrDoesNotExist) {
 		return NewError("image resource does not exist", 404)
 	}
+	if errors.Is(err, img.ErrUpscaleNotRequested) {
+		return NewError(err.Error(), 400)
+	}
 
 	// Unknown / unhandled errors are just general 500s
 	return NewError(err.Error(), 500)
 }
 
 func (ih *ImageHandler) getImageData(id iiif.ID) (*img.Resource, *iiif.Info, *HandlerError) {
-	var res, err = img.NewResource(id, ih.getURL(id))
+	var u, err = ih.resolveURL(id)
+	if err != nil {
+		return nil, nil, newImageResError(err)
+	}
+
+	var res *img.Resource
+	res, err = img.NewResource(id, u)
 	if err != nil {
 		return nil, nil, newImageResError(err)
 	}
@@ -416,6 +740,9 @@ func (ih *ImageHandler) loadInfoFromImageResource(res *img.Resource) (*iiif.Info
 		TileHeight: d.GetTileHeight(),
 		Levels:     d.GetLevels(),
 	}
+	if pd, ok := d.(img.PyramidDecoder); ok {
+		imageInfo.Reductions = pd.GetReductions()
+	}
 
 	// We save the minimal data to the cache so our cache remains incredibly
 	// small for what it gives us
@@ -434,6 +761,8 @@ func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
 		info.Profile.MaxHeight = ih.Maximums.Height
 	}
 
+	info.Sizes = ih.pyramidSizes(i)
+
 	// Compute scaling and tiling
 	if i.TileWidth > 0 && i.TileWidth < i.Width && i.TileHeight < i.Height {
 		var sf []int
@@ -464,6 +793,30 @@ func (ih *ImageHandler) buildInfo(id iiif.ID, i ImageInfo) *iiif.Info {
 	return info
 }
 
+// pyramidSizes returns the full-image sizes which correspond to the image's
+// resolution levels, smallest first, omitting any which exceed the handler's
+// maximums.  Each level is half the size of the one above it, rounded up.
+// Images whose decoders can't read reduced resolutions only list their full
+// size.
+func (ih *ImageHandler) pyramidSizes(i ImageInfo) []iiif.ImageSize {
+	var sizes []iiif.ImageSize
+	for level := 0; level <= i.Reductions; level++ {
+		var scale = 1 << uint(level)
+		var w, h = (i.Width + scale - 1) / scale, (i.Height + scale - 1) / scale
+
+		// As with tiles, there's no point reporting absurdly small sizes
+		if level > 0 && (w < 16 || h < 16) {
+			break
+		}
+		if ih.Maximums.SmallerThanAny(w, h) {
+			continue
+		}
+		sizes = append([]iiif.ImageSize{{Width: w, Height: h}}, sizes...)
+	}
+
+	return sizes
+}
+
 func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
 	json, err := json.Marshal(info)
 	if err != nil {
@@ -474,59 +827,199 @@ func marshalInfo(info *iiif.Info) ([]byte, *HandlerError) {
 	return json, nil
 }
 
-// Command handles image processing operations
-func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, res *img.Resource, info *iiif.Info) {
-	// Send last modified time
-	if err := sendHeaders(w, req, res); err != nil {
-		return
+// constraints returns the size limits for a request.  If we have an info, we
+// can make use of it for the constraints rather than using the global
+// constraints; this is useful for overridden info.json files.
+func (ih *ImageHandler) constraints(info *iiif.Info) img.Constraint {
+	if info == nil {
+		return ih.Maximums
 	}
 
-	// Do we support this request?  If not, return a 501
-	if !ih.FeatureSet.Supported(u) {
-		http.Error(w, "Feature not supported", 501)
+	var max = img.Constraint{
+		Width:  info.Profile.MaxWidth,
+		Height: info.Profile.MaxHeight,
+		Area:   info.Profile.MaxArea,
+	}
+	if max.Width == 0 {
+		max.Width = math.MaxInt32
+	}
+	if max.Height == 0 {
+		max.Height = math.MaxInt32
+	}
+	if max.Area == 0 {
+		max.Area = math.MaxInt64
+	}
+	return max
+}
+
+// limitInfo rewrites info to describe only what a user limited to max can
+// get: the maximum size is lowered, and sizes and tile scale factors which
+// exceed max are removed
+func (ih *ImageHandler) limitInfo(info *iiif.Info, max img.Constraint) {
+	if max.Unlimited() {
 		return
 	}
 
-	var max = ih.Maximums
+	ih.limitSizes(info, max)
 
-	// If we have an info, we can make use of it for the constraints rather than
-	// using the global constraints; this is useful for overridden info.json files
-	if info != nil {
-		max = img.Constraint{
-			Width:  info.Profile.MaxWidth,
-			Height: info.Profile.MaxHeight,
-			Area:   info.Profile.MaxArea,
+	var tiles []iiif.TileSize
+	for _, t := range info.Tiles {
+		var sf []int
+		for _, f := range t.ScaleFactors {
+			var w, h = (info.Width + f - 1) / f, (info.Height + f - 1) / f
+			if !max.SmallerThanAny(w, h) {
+				sf = append(sf, f)
+			}
 		}
-		if max.Width == 0 {
-			max.Width = math.MaxInt32
+		if len(sf) > 0 {
+			t.ScaleFactors = sf
+			tiles = append(tiles, t)
 		}
-		if max.Height == 0 {
-			max.Height = math.MaxInt32
+	}
+	info.Tiles = tiles
+}
+
+// limitSizes lowers info's maximum size to max, and removes any sizes which
+// exceed it.  Since the constraints on image requests come from info, this
+// also limits what the request can get.
+func (ih *ImageHandler) limitSizes(info *iiif.Info, max img.Constraint) {
+	max = max.Min(ih.constraints(info))
+	if max.Width < math.MaxInt32 {
+		info.Profile.MaxWidth = max.Width
+	}
+	if max.Height < math.MaxInt32 {
+		info.Profile.MaxHeight = max.Height
+	}
+	if max.Area < math.MaxInt64 {
+		info.Profile.MaxArea = max.Area
+	}
+
+	var sizes []iiif.ImageSize
+	for _, s := range info.Sizes {
+		if !max.SmallerThanAny(s.Width, s.Height) {
+			sizes = append(sizes, s)
 		}
-		if max.Area == 0 {
-			max.Area = math.MaxInt64
+	}
+	info.Sizes = sizes
+}
+
+// canonicalURL returns the canonical form of the request, or nil if the
+// request can't be satisfied, in which case it will fail with a more useful
+// error later on
+func (ih *ImageHandler) canonicalURL(u *iiif.URL, info *iiif.Info) *iiif.URL {
+	var max = ih.constraints(info)
+	var crop = u.Region.GetCrop(info.Width, info.Height)
+	var scale, err = img.GetScale(u, crop, max)
+	if err != nil || scale.Empty() {
+		return nil
+	}
+	var sw, sh = transform.RotatedBounds(scale.Dx(), scale.Dy(), u.Rotation.Degrees)
+	if max.SmallerThanAny(sw, sh) {
+		return nil
+	}
+
+	return u.Canonical(info.Width, info.Height, scale)
+}
+
+// checkFeatures returns an error if u asks for something the server doesn't
+// support, or, in strict mode, for a tile or size it didn't advertise.  This
+// has to happen before a cached image is served, since caching doesn't
+// depend on these settings.
+func (ih *ImageHandler) checkFeatures(u *iiif.URL, info *iiif.Info) *HandlerError {
+	// Do we support this request?  If not, return a 501
+	var supported = ih.FeatureSet.Supported(u)
+
+	// In strict mode, only the tiles and sizes we advertised may be requested,
+	// but those are allowed even if the feature set doesn't otherwise support
+	// their region and size syntax
+	if ih.StrictLevel0 {
+		if info == nil || !info.Advertises(u) {
+			return NewError("Only advertised tiles and sizes may be requested", 400)
 		}
+		supported = ih.FeatureSet.SupportsRotation(u.Rotation) &&
+			ih.FeatureSet.SupportsQuality(u.Quality) &&
+			ih.FeatureSet.SupportsFormat(u.Format)
+	}
+
+	if !supported {
+		return NewError("Feature not supported", 501)
+	}
+
+	// IIIF 2.x has no syntax to request upscaling, so we have to check the
+	// image dimensions to know if the request needs "sizeAboveFull"
+	if u.Version != iiif.V3 && !ih.FeatureSet.SizeAboveFull && info != nil &&
+		u.Size.Upscales(u.Region.GetCrop(info.Width, info.Height)) {
+		return NewError("Feature not supported", 501)
 	}
-	img, err := res.Apply(u, max)
+
+	// IIIF 3.0 requests have to ask for upscaling.  Best-fit sizes without the
+	// prefix are shrunk to fit the region instead, so they're always allowed.
+	if u.Version == iiif.V3 && !u.Size.Upscale && u.Size.Type != iiif.STBestFit && info != nil &&
+		u.Size.Upscales(u.Region.GetCrop(info.Width, info.Height)) {
+		return newImageResError(img.ErrUpscaleNotRequested)
+	}
+
+	return nil
+}
+
+// Command handles image processing operations for requests which have passed
+// checkFeatures.  pluginMax is the constraint Authorize plugins put on the
+// request, if any; info has already been limited to it.
+func (ih *ImageHandler) Command(w http.ResponseWriter, req *http.Request, u *iiif.URL, res *img.Resource, info *iiif.Info, pluginMax *img.Constraint) {
+	var access, allowed = ih.access(u.ID, req)
+	if !allowed {
+		http.Error(w, "Authorization required", 401)
+		return
+	}
+
+	// Send last modified time
+	if err := sendHeaders(w, req, res); err != nil {
+		return
+	}
+
+	// Users with limited access may only see the amount of detail they'd see
+	// in the largest full image they're allowed, so partial-image requests are
+	// limited proportionally
+	var max = ih.constraints(info)
+	if info != nil && !access.Unlimited() {
+		var region = u.Region.GetCrop(info.Width, info.Height)
+		max = max.Min(access.ForRegion(region, info.Width, info.Height))
+	}
+
+	m, err := res.Apply(u, max)
 	if err != nil {
+		// Restricted images requested at a size the user could get by logging
+		// in tell the user to do that
+		if errors.Is(err, img.ErrDimensionsExceedLimits) && ih.isRestricted(u.ID) && !ih.Auth.Authorized(req) {
+			http.Error(w, "Authorization required for the requested size", 401)
+			return
+		}
 		e := newImageResError(err)
 		Logger.Errorf("Error applying transorm: %s", err)
 		http.Error(w, e.Message, e.Code)
 		return
 	}
 
+	m, err = transformImage(u, m)
+	if err != nil {
+		Logger.Errorf("Error running TransformImage plugins on %q: %s", u.Path, err)
+		http.Error(w, "Unable to process image", 500)
+		return
+	}
+
 	w.Header().Set("Content-Type", mime.TypeByExtension("."+string(u.Format)))
 
 	cacheBuf := bytes.NewBuffer(nil)
-	if err := EncodeImage(cacheBuf, img, u.Format); err != nil {
+	if err := EncodeImage(cacheBuf, m, u.Format, encode.GetOutputResolution(res, u, m)); err != nil {
 		http.Error(w, "Unable to encode", 500)
 		Logger.Errorf("Unable to encode to %s: %s", u.Format, err)
 		return
 	}
 
-	if key := cacheKey(u); key != "" {
-		stats.TileCache.Set()
-		tileCache.Add(key, cacheBuf.Bytes())
+	// Requests a plugin has limited may have been scaled down for this user
+	// alone, so they must never be cached where other users would see them
+	if key, rule := ih.cacheKey(u, info); key != "" && pluginMax == nil {
+		saveTileToCache(u, key, rule, cacheBuf.Bytes())
 	}
 
 	if _, err := io.Copy(w, cacheBuf); err != nil {
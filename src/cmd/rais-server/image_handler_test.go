@@ -4,18 +4,27 @@
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"image"
+	_ "image/jpeg"
+	"io/ioutil"
 	"math"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"rais/src/auth"
+	"rais/src/diskcache"
 	"rais/src/fakehttp"
 	"rais/src/iiif"
 	"rais/src/img"
+	"rais/src/plugins"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/assert"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
@@ -127,6 +136,93 @@ func TestInfoHandlerLD(t *testing.T) {
 	assert.Equal("application/ld+json", w.Headers["Content-Type"][0], "Proper content type", t)
 }
 
+// v3request sets up a level 2 handler with a 3.0 path and sends the given
+// path with the given Accept header
+func v3request(path, accept string) *fakehttp.ResponseWriter {
+	u, _ := url.Parse("http://example.com")
+	w := fakehttp.NewResponseWriter()
+	req, _ := http.NewRequest("get", path, strings.NewReader(""))
+	req.RequestURI = path
+	if accept != "" {
+		req.Header.Add("Accept", accept)
+	}
+
+	h := NewImageHandler(rootDir(), "/foo/bar")
+	h.V3WebPathPrefix = "/foo/bar/3"
+	h.Maximums = unlimited
+	h.BaseURL = u
+	h.FeatureSet = iiif.AllFeatures()
+	h.IIIFRoute(w, req)
+
+	return w
+}
+
+func TestInfoHandlerV3Path(t *testing.T) {
+	w := v3request("/foo/bar/3/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", "")
+	assert.Equal(-1, w.StatusCode, "Valid info request doesn't explicitly set status code", t)
+	var data map[string]interface{}
+	json.Unmarshal(w.Output, &data)
+	assert.Equal(iiif.ContextV3, data["@context"], "3.0 context", t)
+	assert.Equal("ImageService3", data["type"], "3.0 type", t)
+	assert.Equal("level2", data["profile"], "3.0 profile", t)
+	assert.Equal("http://example.com/foo/bar/3/docker%2Fimages%2Ftestfile%2Ftest-world.jp2", data["id"], "3.0 id", t)
+}
+
+// TestCacheVersions verifies the tile cache doesn't serve images made for one
+// IIIF version to requests for another, since the same size can mean
+// different things to each
+func TestCacheVersions(t *testing.T) {
+	tileCache, _ = lru.New2Q(10)
+	tileCacheRules, _ = loadCacheRules()
+	defer func() {
+		tileCache = nil
+		tileCacheRules = nil
+	}()
+
+	var size = func(w *fakehttp.ResponseWriter) image.Point {
+		var c, _, err = image.DecodeConfig(bytes.NewReader(w.Output))
+		if err != nil {
+			t.Fatalf("Unable to decode response: %s", err)
+		}
+		return image.Pt(c.Width, c.Height)
+	}
+
+	var base = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/"
+	var base3 = "/foo/bar/3/docker%2Fimages%2Ftestfile%2Ftest-world-link.jp2/full/"
+	var w = v3request(base+"!1000,1000/0/default.jpg", "")
+	assert.Equal(-1, w.StatusCode, "2.x best fit request", t)
+	assert.Equal(image.Pt(1000, 500), size(w), "2.x best fit upscales", t)
+	w = v3request(base+"1000,/0/default.jpg", "")
+	assert.Equal(-1, w.StatusCode, "2.x width request", t)
+	assert.Equal(2, tileCache.Len(), "2.x images are cached", t)
+
+	w = v3request(base3+"!1000,1000/0/default.jpg", "")
+	assert.Equal(-1, w.StatusCode, "3.0 best fit request", t)
+	assert.Equal(image.Pt(800, 400), size(w), "3.0 best fit doesn't upscale", t)
+	w = v3request(base3+"1000,/0/default.jpg", "")
+	assert.Equal(400, w.StatusCode, "3.0 upscale without the prefix is an error", t)
+}
+
+func TestInfoHandlerV3Accept(t *testing.T) {
+	var accept = `application/ld+json;profile="http://iiif.io/api/image/3/context.json"`
+	w := v3request("/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", accept)
+	assert.Equal(-1, w.StatusCode, "Valid info request doesn't explicitly set status code", t)
+	assert.Equal(accept, w.Headers["Content-Type"][0], "3.0 content type", t)
+	var data map[string]interface{}
+	json.Unmarshal(w.Output, &data)
+	assert.Equal("ImageService3", data["type"], "3.0 type", t)
+	assert.Equal("http://example.com/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2", data["id"], "id uses 2.x path", t)
+
+	w = v3request("/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", "")
+	json.Unmarshal(w.Output, &data)
+	assert.Equal(iiif.ContextV2, data["@context"], "2.x context without a profile", t)
+}
+
+func TestCommandHandlerV3InvalidSize(t *testing.T) {
+	w := v3request("/foo/bar/3/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/full/full/0/default.jpg", "")
+	assert.Equal(400, w.StatusCode, "full size is a bad request in 3.0", t)
+}
+
 func TestInfoRedirect(t *testing.T) {
 	w := request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2", t)
 	assert.Equal(303, w.StatusCode, "Base URL redirects to info request", t)
@@ -167,6 +263,79 @@ func TestInfoNoMaxSize(t *testing.T) {
 	assert.False(bytes.Contains(w.Output, []byte("maxArea")), "no maxArea", t)
 }
 
+// TestInfoSizes verifies the sizes list follows the resolution pyramid and
+// respects the handler's maximums
+func TestInfoSizes(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/foo/bar")
+	h.FeatureSet = iiif.FeatureSet2()
+	var info = h.buildInfo("id", ImageInfo{Width: 1000, Height: 300, Levels: 5, Reductions: 5})
+
+	// The smallest level, 32x10, is too small to report
+	var expected = []iiif.ImageSize{
+		{Width: 63, Height: 19}, {Width: 125, Height: 38}, {Width: 250, Height: 75},
+		{Width: 500, Height: 150}, {Width: 1000, Height: 300},
+	}
+	if diff := cmp.Diff(expected, info.Sizes); diff != "" {
+		t.Errorf("unconstrained sizes: %s", diff)
+	}
+
+	h.Maximums = img.Constraint{Width: 600, Height: 600, Area: 50000}
+	info = h.buildInfo("id", ImageInfo{Width: 1000, Height: 300, Levels: 5, Reductions: 5})
+	expected = []iiif.ImageSize{{Width: 63, Height: 19}, {Width: 125, Height: 38}, {Width: 250, Height: 75}}
+	if diff := cmp.Diff(expected, info.Sizes); diff != "" {
+		t.Errorf("constrained sizes: %s", diff)
+	}
+
+	// A JP2 with one decomposition level has a half-size resolution, while
+	// decoders without reduced resolutions report one level
+	h = NewImageHandler(rootDir(), "/foo/bar")
+	info = h.buildInfo("id", ImageInfo{Width: 1000, Height: 300, Levels: 1, Reductions: 1})
+	expected = []iiif.ImageSize{{Width: 500, Height: 150}, {Width: 1000, Height: 300}}
+	if diff := cmp.Diff(expected, info.Sizes); diff != "" {
+		t.Errorf("one-level JP2 sizes: %s", diff)
+	}
+	info = h.buildInfo("id", ImageInfo{Width: 1000, Height: 300, Levels: 1})
+	expected = []iiif.ImageSize{{Width: 1000, Height: 300}}
+	if diff := cmp.Diff(expected, info.Sizes); diff != "" {
+		t.Errorf("non-pyramidal sizes: %s", diff)
+	}
+}
+
+// TestCanonicalURL verifies the canonical form of requests takes the
+// handler's maximums into account
+func TestCanonicalURL(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/foo/bar")
+	h.FeatureSet = iiif.FeatureSet2()
+	var info = h.buildInfo("id", ImageInfo{Width: 1000, Height: 300, Levels: 1})
+
+	var tests = map[string]struct {
+		path     string
+		max      img.Constraint
+		expected string
+	}{
+		"full":        {path: "id/0,0,1000,300/1000,/0/native.jpg", max: unlimited, expected: "full/full/0/default.jpg"},
+		"scaled":      {path: "id/full/pct:50/0/default.jpg", max: unlimited, expected: "full/500,/0/default.jpg"},
+		"constrained": {path: "id/full/max/0/default.jpg", max: nc(500, 500, 250000), expected: "full/500,/0/default.jpg"},
+		"too big":     {path: "id/full/2000,/0/default.jpg", max: nc(500, 500, 250000), expected: ""},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var u, _ = iiif.NewURL(tc.path)
+			h.Maximums = tc.max
+			info.Profile.MaxWidth, info.Profile.MaxHeight, info.Profile.MaxArea = tc.max.Width, tc.max.Height, tc.max.Area
+			var c = h.canonicalURL(u, info)
+			var actual string
+			if c != nil {
+				actual = c.ParamString()
+			}
+			if actual != tc.expected {
+				t.Errorf("expected %q, got %q", tc.expected, actual)
+			}
+		})
+	}
+}
+
 func TestCommandHandler404(t *testing.T) {
 	w := request("identifier/full/full/0/default.jpg", t)
 	assert.Equal(404, w.StatusCode, "Valid command on nonexistent file returns 404", t)
@@ -211,6 +380,302 @@ func TestCommandHandlerInvalidSize(t *testing.T) {
 	assert.Equal(501, w.StatusCode, "Status code when area is too large", t)
 }
 
+// TestStrictLevel0 verifies that a strict server serves advertised tiles even
+// when its feature set doesn't support pixel regions, and rejects anything
+// else.  test-world.jp2's info.json override advertises 512px tiles.
+func TestStrictLevel0(t *testing.T) {
+	var strictRequest = func(path string) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var reqPath = "/foo/bar/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/" + path
+		var req, _ = http.NewRequest("GET", reqPath, strings.NewReader(""))
+		req.RequestURI = reqPath
+
+		var h = NewImageHandler(rootDir(), "/foo/bar")
+		h.FeatureSet = iiif.FeatureSet0()
+		h.StrictLevel0 = true
+		h.IIIFRoute(w, req)
+		return w
+	}
+
+	var w = strictRequest("0,0,512,400/512,/0/default.jpg")
+	assert.Equal(-1, w.StatusCode, "Advertised tile is served", t)
+	w = strictRequest("256,0,512,400/512,/0/default.jpg")
+	assert.Equal(400, w.StatusCode, "Misaligned tile is rejected", t)
+	w = strictRequest("0,0,512,400/256,/0/default.jpg")
+	assert.Equal(400, w.StatusCode, "Unadvertised scale is rejected", t)
+	w = strictRequest("0,0,512,400/512,/90/default.jpg")
+	assert.Equal(501, w.StatusCode, "Advertised tile with unsupported rotation is rejected", t)
+
+	// Cached images mustn't bypass the check
+	tileCache, _ = lru.New2Q(10)
+	tileCacheRules, _ = loadCacheRules()
+	defer func() {
+		tileCache = nil
+		tileCacheRules = nil
+	}()
+	var u, _ = iiif.NewURL("docker/images/testfile/test-world.jp2/0,0,512,400/256,/0/default.jpg")
+	var key, rule = cacheKey(u, &iiif.Info{Width: 800, Height: 400})
+	saveTileToCache(u, key, rule, []byte("tile"))
+	w = strictRequest("0,0,512,400/256,/0/default.jpg")
+	assert.Equal(400, w.StatusCode, "Unadvertised scale is rejected even when cached", t)
+}
+
+func TestSignedURLs(t *testing.T) {
+	var signer = auth.NewURLSigner([]byte("secret"))
+	var newHandler = func() *ImageHandler {
+		var h = NewImageHandler(rootDir(), "/iiif")
+		h.AddSchemeMap("restricted", "file://"+rootDir()+"/docker/images/testfile")
+		h.URLSigner = signer
+		h.SignedSchemes = []string{"restricted"}
+		return h
+	}
+	var infoRequest = func(id iiif.ID, query string) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var reqPath = "/iiif/" + id.Escaped() + "/info.json"
+		if query != "" {
+			reqPath += "?" + query
+		}
+		var req, _ = http.NewRequest("GET", reqPath, nil)
+		req.RequestURI = reqPath
+		newHandler().IIIFRoute(w, req)
+		return w
+	}
+
+	var w = infoRequest("docker/images/testfile/test-world.jp2", "")
+	assert.Equal(-1, w.StatusCode, "Other schemes don't need a signature", t)
+	w = infoRequest("restricted://test-world.jp2", "")
+	assert.Equal(403, w.StatusCode, "Unsigned request is forbidden", t)
+	w = infoRequest("restricted://test-world.jp2", signer.Sign("restricted://other.jp2", time.Hour).Encode())
+	assert.Equal(403, w.StatusCode, "Another image's signature is forbidden", t)
+	w = infoRequest("restricted://test-world.jp2", signer.Sign("restricted://test-world.jp2", -time.Second).Encode())
+	assert.Equal(403, w.StatusCode, "Expired signature is forbidden", t)
+	w = infoRequest("restricted://test-world.jp2", signer.Sign("restricted://test-world.jp2", time.Hour).Encode())
+	assert.Equal(-1, w.StatusCode, "Signed request is served", t)
+
+	// Base URIs mustn't reveal whether an image exists without a signature
+	var baseRequest = func(id iiif.ID, query string) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var req, _ = http.NewRequest("GET", "/iiif/"+id.Escaped()+"?"+query, nil)
+		req.RequestURI = req.URL.RequestURI()
+		newHandler().IIIFRoute(w, req)
+		return w
+	}
+	w = baseRequest("restricted://test-world.jp2", "")
+	assert.Equal(403, w.StatusCode, "Unsigned base URI for an image is forbidden", t)
+	w = baseRequest("restricted://missing.jp2", "")
+	assert.Equal(403, w.StatusCode, "Unsigned base URI for a missing image is forbidden", t)
+	w = baseRequest("restricted://test-world.jp2", signer.Sign("restricted://test-world.jp2", time.Hour).Encode())
+	assert.Equal(303, w.StatusCode, "Signed base URI is redirected", t)
+
+	// The admin endpoint should mint URLs the IIIF handler accepts
+	w = fakehttp.NewResponseWriter()
+	var req, _ = http.NewRequest("GET", "/admin/sign-url?id=restricted://test-world.jp2&ttl=5m", nil)
+	newHandler().adminSignURL(w, req)
+	assert.Equal(200, w.StatusCode, "Signing succeeds", t)
+	var signed signedURL
+	var err = json.Unmarshal(w.Output, &signed)
+	assert.NilError(err, "decoding signed URL", t)
+	assert.True(strings.HasPrefix(signed.URL, "/iiif/restricted%3A%2F%2Ftest-world.jp2/info.json?"), "signed URL: "+signed.URL, t)
+	w = infoRequest("restricted://test-world.jp2", signed.Query)
+	assert.Equal(-1, w.StatusCode, "Minted URL is served", t)
+}
+
+func TestResolveURL(t *testing.T) {
+	var calls int
+	var skip = func(id iiif.ID) (*url.URL, error) {
+		calls++
+		return nil, plugins.ErrSkipped
+	}
+	var ark = func(id iiif.ID) (*url.URL, error) {
+		if !strings.HasPrefix(string(id), "ark:/") {
+			return nil, plugins.ErrSkipped
+		}
+		if id == "ark:/00000/missing" {
+			return nil, img.ErrDoesNotExist
+		}
+		return url.Parse("s3://bucket/" + strings.TrimPrefix(string(id), "ark:/") + ".jp2")
+	}
+
+	idToURLPlugins = []func(iiif.ID) (*url.URL, error){skip, ark}
+	urlCache, _ = lru.New(10)
+	urlCacheTTL = time.Minute
+	defer func() {
+		idToURLPlugins = nil
+		urlCache = nil
+	}()
+
+	var h = NewImageHandler("/var/local/images", "/iiif")
+	var u, err = h.resolveURL("ark:/12345/abc")
+	assert.NilError(err, "resolving an ark", t)
+	assert.Equal("s3://bucket/12345/abc.jp2", u.String(), "plugin resolves the ark", t)
+	assert.Equal(1, calls, "skipping plugin is called first", t)
+
+	u, err = h.resolveURL("ark:/12345/abc")
+	assert.NilError(err, "resolving a cached ark", t)
+	assert.Equal("s3://bucket/12345/abc.jp2", u.String(), "cached result", t)
+	assert.Equal(1, calls, "plugins aren't called for cached ids", t)
+
+	u, err = h.resolveURL("foo/bar.jp2")
+	assert.NilError(err, "resolving a local id", t)
+	assert.Equal("file:///var/local/images/foo/bar.jp2", u.String(), "scheme map is the fallback", t)
+
+	_, err = h.resolveURL("ark:/00000/missing")
+	assert.Equal(img.ErrDoesNotExist, err, "plugin errors are returned", t)
+
+	urlCacheTTL = -time.Second
+	h.resolveURL("ark:/12345/xyz")
+	calls = 0
+	h.resolveURL("ark:/12345/xyz")
+	assert.Equal(1, calls, "expired results are looked up again", t)
+}
+
+func TestTileCaches(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-tiles-")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	diskTileCache, err = diskcache.New(dir, 1024*1024, diskcache.LRU)
+	if err != nil {
+		t.Fatalf("Unable to open disk cache: %s", err)
+	}
+	tileCacheRules, _ = loadCacheRules()
+	defer func() {
+		tileCache = nil
+		diskTileCache = nil
+		tileCacheRules = nil
+		os.RemoveAll(dir)
+	}()
+
+	var info = &iiif.Info{Width: 2048, Height: 1024}
+	var u, _ = iiif.NewURL("foo.jp2/full/512,/0/default.jpg")
+	var key, rule = cacheKey(u, info)
+	assert.Equal(u.Path+"|v2|"+encodeOptions().Fingerprint(), key, "JPEG tiles are cacheable with only a disk cache", t)
+
+	saveTileToCache(u, key, rule, []byte("tile"))
+	tileCache, _ = lru.New2Q(10)
+	var data, ok = loadTileFromCache(u, key)
+	assert.True(ok, "tile is read from disk", t)
+	assert.Equal("tile", string(data), "tile data from disk", t)
+	assert.True(tileCache.Contains(key), "tiles read from disk are copied into memory", t)
+
+	viper.Set("JPGQuality", 50)
+	defer viper.Reset()
+	var newKey, _ = cacheKey(u, info)
+	assert.True(newKey != key, "encoder settings change the key", t)
+
+	diskTileCache.RemoveGroup(string(u.ID))
+	tileCache.Purge()
+	_, ok = loadTileFromCache(u, key)
+	assert.False(ok, "expired images aren't in the disk cache", t)
+}
+
+func TestTransformImage(t *testing.T) {
+	var seen []string
+	var resize = func(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) {
+		seen = append(seen, "resize")
+		return image.NewGray(image.Rect(0, 0, 10, 10)), nil
+	}
+	var skip = func(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) {
+		seen = append(seen, "skip")
+		return nil, plugins.ErrSkipped
+	}
+	var check = func(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) {
+		seen = append(seen, "check")
+		assert.Equal(10, m.Bounds().Dx(), "later plugins get earlier plugins' output", t)
+		assert.Equal(iiif.ID("foo.jp2"), id, "plugins get the image id", t)
+		return m, nil
+	}
+
+	transformImagePlugins = []func(iiif.ID, *iiif.URL, image.Image) (image.Image, error){resize, skip, check}
+	defer func() { transformImagePlugins = nil }()
+
+	var u, _ = iiif.NewURL("foo.jp2/full/max/0/default.jpg")
+	var m, err = transformImage(u, image.NewGray(image.Rect(0, 0, 100, 100)))
+	assert.NilError(err, "transforming", t)
+	assert.Equal(10, m.Bounds().Dx(), "skipped plugins don't change the image", t)
+	if diff := cmp.Diff([]string{"resize", "skip", "check"}, seen); diff != "" {
+		t.Errorf("plugin order: %s", diff)
+	}
+
+	var fail = func(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) {
+		return nil, fmt.Errorf("oops")
+	}
+	transformImagePlugins = append(transformImagePlugins, fail)
+	_, err = transformImage(u, m)
+	assert.True(err != nil, "plugin errors are returned", t)
+}
+
+func TestAugmentInfo(t *testing.T) {
+	var rights = func(id iiif.ID, info *iiif.Info) error {
+		info.Rights = "http://rightsstatements.org/vocab/InC/1.0/"
+		info.Extensions = map[string]interface{}{"source": string(id)}
+		return nil
+	}
+	var skip = func(id iiif.ID, info *iiif.Info) error {
+		return plugins.ErrSkipped
+	}
+	augmentInfoPlugins = []func(iiif.ID, *iiif.Info) error{skip, rights}
+	defer func() { augmentInfoPlugins = nil }()
+
+	var w = request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", t)
+	assert.Equal(-1, w.StatusCode, "info request succeeds", t)
+	var raw map[string]interface{}
+	var err = json.Unmarshal(w.Output, &raw)
+	assert.NilError(err, "decoding info.json", t)
+	assert.Equal("http://rightsstatements.org/vocab/InC/1.0/", raw["license"], "plugin-added rights", t)
+	assert.Equal("docker/images/testfile/test-world.jp2", raw["source"], "plugin-added extension", t)
+
+	augmentInfoPlugins = append(augmentInfoPlugins, func(iiif.ID, *iiif.Info) error { return fmt.Errorf("oops") })
+	w = request("docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json", t)
+	assert.Equal(500, w.StatusCode, "plugin errors fail the request", t)
+}
+
+func TestAuthorizePlugins(t *testing.T) {
+	var deny = func(req *http.Request, id iiif.ID, u *iiif.URL) (bool, *img.Constraint, error) {
+		if req.Header.Get("X-Blocked") != "" {
+			return false, nil, nil
+		}
+		return true, nil, nil
+	}
+	var limit = func(width int) func(*http.Request, iiif.ID, *iiif.URL) (bool, *img.Constraint, error) {
+		return func(req *http.Request, id iiif.ID, u *iiif.URL) (bool, *img.Constraint, error) {
+			var c = unlimited
+			c.Width = width
+			return true, &c, nil
+		}
+	}
+	var skip = func(req *http.Request, id iiif.ID, u *iiif.URL) (bool, *img.Constraint, error) {
+		return false, nil, plugins.ErrSkipped
+	}
+	authorizePlugins = []func(*http.Request, iiif.ID, *iiif.URL) (bool, *img.Constraint, error){
+		skip, deny, limit(300), limit(400),
+	}
+	defer func() { authorizePlugins = nil }()
+
+	var infoRequest = func(blocked bool) *fakehttp.ResponseWriter {
+		var w = fakehttp.NewResponseWriter()
+		var reqPath = "/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/info.json"
+		var req, _ = http.NewRequest("GET", reqPath, nil)
+		req.RequestURI = reqPath
+		if blocked {
+			req.Header.Set("X-Blocked", "1")
+		}
+		NewImageHandler(rootDir(), "/iiif").IIIFRoute(w, req)
+		return w
+	}
+
+	var w = infoRequest(true)
+	assert.Equal(403, w.StatusCode, "denied request is forbidden", t)
+
+	w = infoRequest(false)
+	assert.Equal(-1, w.StatusCode, "allowed request is served", t)
+	var info iiif.Info
+	var err = json.Unmarshal(w.Output, &info)
+	assert.NilError(err, "decoding info.json", t)
+	assert.Equal(300, info.Profile.MaxWidth, "smallest plugin constraint is used", t)
+	assert.Equal(int64(262144), info.Profile.MaxArea, "the override's max area is kept", t)
+}
+
 // BenchmarkRouting does a benchmark against the routing rules to ensure we
 // aren't creating problems when changing how we interpret the incoming URLs.
 func BenchmarkRouting(b *testing.B) {
@@ -1,9 +1,11 @@
 package main
 
 // ImageInfo holds just enough data to reproduce the dynamic portions of
-// info.json
+// info.json.  Reductions is the number of reduced resolutions the decoder can
+// read, which isn't always related to its reported levels.
 type ImageInfo struct {
 	Width, Height         int
 	TileWidth, TileHeight int
 	Levels                int
+	Reductions            int
 }
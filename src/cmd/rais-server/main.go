@@ -5,6 +5,7 @@
 	"net/http"
 	"net/url"
 	"path"
+	"rais/src/auth"
 	"rais/src/cmd/rais-server/internal/servers"
 	"rais/src/iiif"
 	"rais/src/img"
@@ -37,6 +38,9 @@ func main() {
 
 	setupCaches()
 
+	// parseConf has already validated the color, so we don't check for errors
+	img.RotationBackground, _ = parseHexColor(viper.GetString("RotationBackground"))
+
 	var pluginList string
 
 	// Don't let the default plugin list be used if we have an explicit value of ""
@@ -50,6 +54,14 @@ func main() {
 		LoadPlugins(Logger, strings.Split(pluginList, ","))
 	}
 
+	var externalPlugins = viper.GetString("ExternalPlugins")
+	if externalPlugins != "" && externalPlugins != "-" {
+		var timeout = viper.GetDuration("ExternalPluginTimeout")
+		LoadExternalPlugins(Logger, strings.Split(externalPlugins, ","), timeout)
+	}
+
+	setupCacheRules()
+
 	// Register our JP2 decoder after plugins have been loaded to allow plugins
 	// to handle images - for instance, we might want a pyramidal tiff plugin or
 	// something one day
@@ -73,11 +85,28 @@ func main() {
 		Logger.Warnf("WebPath %q cleaned; using %q instead", webPath, p2)
 		webPath = p2
 	}
+	v3WebPath := viper.GetString("IIIFv3WebPath")
+	if v3WebPath != "" {
+		v3WebPath = path.Clean(v3WebPath)
+		if v3WebPath == webPath {
+			Logger.Fatalf("IIIFv3WebPath cannot be the same as IIIFWebPath (%q)", webPath)
+		}
+	}
+	presentationWebPath := viper.GetString("PresentationWebPath")
+	if presentationWebPath != "" {
+		presentationWebPath = path.Clean(presentationWebPath)
+		if presentationWebPath == webPath || presentationWebPath == v3WebPath {
+			Logger.Fatalf("PresentationWebPath cannot be the same as an image API web path (%q)", presentationWebPath)
+		}
+	}
 	address := viper.GetString("Address")
 	adminAddress := viper.GetString("AdminAddress")
 
 	Logger.Debugf("Serving images from %q", tilePath)
 	ih := NewImageHandler(tilePath, webPath)
+	ih.V3WebPathPrefix = v3WebPath
+	ih.PresentationWebPathPrefix = presentationWebPath
+	ih.CanonicalRedirect = viper.GetBool("CanonicalRedirect")
 	ih.Maximums.Area = viper.GetInt64("ImageMaxArea")
 	ih.Maximums.Width = viper.GetInt("ImageMaxWidth")
 	ih.Maximums.Height = viper.GetInt("ImageMaxHeight")
@@ -91,6 +120,27 @@ func main() {
 		}
 	}
 
+	authWebPath := viper.GetString("AuthWebPath")
+	if authWebPath != "" {
+		authWebPath = path.Clean(authWebPath)
+		if authWebPath == webPath || authWebPath == v3WebPath || authWebPath == presentationWebPath {
+			Logger.Fatalf("AuthWebPath cannot be the same as another web path (%q)", authWebPath)
+		}
+		ih.Auth = setupAuth(authWebPath)
+	}
+
+	signedSchemes := strings.Fields(viper.GetString("SignedURLSchemes"))
+	if len(signedSchemes) > 0 {
+		var secret = []byte(viper.GetString("SignedURLSecret"))
+		if len(secret) == 0 {
+			Logger.Warnf("SignedURLSecret is not set; signed URLs will be invalidated when RAIS restarts")
+			secret = randomSecret()
+		}
+		Logger.Infof("Requiring signed URLs for images with schemes %q", signedSchemes)
+		ih.URLSigner = auth.NewURLSigner(secret)
+		ih.SignedSchemes = signedSchemes
+	}
+
 	iiifBaseURL := viper.GetString("IIIFBaseURL")
 	if iiifBaseURL != "" {
 		baseURL, _ := url.Parse(iiifBaseURL)
@@ -108,6 +158,11 @@ func main() {
 		Logger.Debugf("Setting IIIF capabilities from file '%s'", capfile)
 	}
 
+	ih.StrictLevel0 = viper.GetBool("StrictLevel0") || ih.FeatureSet.IsLevel0()
+	if ih.StrictLevel0 {
+		Logger.Infof("Strict level 0 mode: only advertised tiles and sizes will be served")
+	}
+
 	// Setup server info in our stats structure
 	stats.ServerStart = time.Now()
 	stats.RAISVersion = version.Version
@@ -117,12 +172,27 @@ func main() {
 	var pubSrv = servers.New("RAIS", address)
 	pubSrv.AddMiddleware(logMiddleware)
 	handle(pubSrv, ih.WebPathPrefix+"/", http.HandlerFunc(ih.IIIFRoute))
+	if ih.V3WebPathPrefix != "" {
+		Logger.Infof("Serving IIIF 3.0 requests under %q", ih.V3WebPathPrefix)
+		handle(pubSrv, ih.V3WebPathPrefix+"/", http.HandlerFunc(ih.IIIFRoute))
+	}
+	if ih.PresentationWebPathPrefix != "" {
+		Logger.Infof("Serving IIIF Presentation 3.0 manifests under %q", ih.PresentationWebPathPrefix)
+		handle(pubSrv, ih.PresentationWebPathPrefix+"/", http.HandlerFunc(ih.ManifestRoute))
+	}
+	if ih.Auth != nil {
+		Logger.Infof("Serving IIIF Auth 2 services under %q", ih.Auth.WebPathPrefix)
+		handle(pubSrv, ih.Auth.WebPathPrefix+"/", http.HandlerFunc(ih.Auth.Route))
+	}
 	handle(pubSrv, "/", http.NotFoundHandler())
 
 	var admSrv = servers.New("RAIS Admin", adminAddress)
 	admSrv.AddMiddleware(logMiddleware)
 	admSrv.HandleExact("/admin/stats.json", stats)
 	admSrv.HandlePrefix("/admin/cache/purge", http.HandlerFunc(adminPurgeCache))
+	if ih.URLSigner != nil {
+		admSrv.HandleExact("/admin/sign-url", http.HandlerFunc(ih.adminSignURL))
+	}
 
 	interrupts.TrapIntTerm(shutdown)
 
@@ -185,3 +255,50 @@ func parseSchemeMap(ih *ImageHandler, schemeMapConfig string) error {
 
 	return nil
 }
+
+// setupAuth reads the auth configuration and returns an AuthHandler serving
+// its services under prefix
+func setupAuth(prefix string) *AuthHandler {
+	var htpasswdFile = viper.GetString("AuthHtpasswdFile")
+	if htpasswdFile == "" {
+		Logger.Fatalf("AuthHtpasswdFile must be set when AuthWebPath is set")
+	}
+	var checker, err = auth.LoadHtpasswd(htpasswdFile)
+	if err != nil {
+		Logger.Fatalf("Unable to load htpasswd file %q: %s", htpasswdFile, err)
+	}
+
+	var secret = []byte(viper.GetString("AuthSecret"))
+	if len(secret) == 0 {
+		Logger.Warnf("AuthSecret is not set; tokens will be invalidated when RAIS restarts")
+		secret = randomSecret()
+	}
+
+	var ah = NewAuthHandler(prefix, checker, auth.NewSigner(secret), viper.GetDuration("AuthTokenTTL"))
+	ah.AllowedOrigins = strings.Fields(viper.GetString("AuthAllowedOrigins"))
+	if len(ah.AllowedOrigins) == 0 {
+		Logger.Warnf("AuthAllowedOrigins is not set; no clients will be given access tokens")
+	}
+	var rulesFile = viper.GetString("AuthRulesFile")
+	if rulesFile != "" {
+		var rules []AccessRule
+		rules, err = loadAccessRules(rulesFile)
+		if err != nil {
+			Logger.Fatalf("Unable to load access rules file %q: %s", rulesFile, err)
+		}
+		for _, r := range rules {
+			err = ah.AddRule(r)
+			if err != nil {
+				Logger.Fatalf("Error in access rules file %q: %s", rulesFile, err)
+			}
+		}
+	}
+	for _, pattern := range strings.Fields(viper.GetString("AuthRestrictedIDs")) {
+		err = ah.Restrict(pattern)
+		if err != nil {
+			Logger.Fatalf("Error parsing AuthRestrictedIDs: %s", err)
+		}
+	}
+
+	return ah
+}
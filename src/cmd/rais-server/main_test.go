@@ -1,6 +1,7 @@
 package main
 
 import (
+	"image/color"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -48,3 +49,33 @@ func TestParseSchemeMap(t *testing.T) {
 		})
 	}
 }
+
+func TestParseHexColor(t *testing.T) {
+	var tests = map[string]struct {
+		input    string
+		hasError bool
+		expected color.RGBA
+	}{
+		"white":     {input: "#ffffff", expected: color.RGBA{255, 255, 255, 255}},
+		"no hash":   {input: "102030", expected: color.RGBA{16, 32, 48, 255}},
+		"uppercase": {input: "#ABCDEF", expected: color.RGBA{171, 205, 239, 255}},
+		"too short": {input: "#fff", hasError: true},
+		"not hex":   {input: "#gggggg", hasError: true},
+		"empty":     {input: "", hasError: true},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var c, err = parseHexColor(tc.input)
+			if err == nil && tc.hasError {
+				t.Errorf("expected error, got nil")
+			}
+			if err != nil && !tc.hasError {
+				t.Errorf("expected no error, got %s", err)
+			}
+			if err == nil && c != tc.expected {
+				t.Errorf("expected %#v, got %#v", tc.expected, c)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"rais/src/iiif"
+	"rais/src/presentation"
+	"strings"
+)
+
+// manifestSidecar is the structure of a "-manifest.json" file, which lists
+// the images making up a multi-canvas manifest.  It lives alongside the path
+// its ID maps to, just like info.json overrides.
+type manifestSidecar struct {
+	Label  string          `json:"label"`
+	Images []manifestImage `json:"images"`
+}
+
+// manifestImage is a single image in a manifest sidecar.  The label is
+// optional, and is used as the label of the image's canvas.
+type manifestImage struct {
+	ID    iiif.ID `json:"id"`
+	Label string  `json:"label"`
+}
+
+// ManifestRoute responds to IIIF Presentation 3.0 manifest requests, which
+// look like "<prefix>/<id>/manifest".  The id may be an image, resulting in a
+// single-canvas manifest, or may have a sidecar file listing several images.
+func (ih *ImageHandler) ManifestRoute(w http.ResponseWriter, req *http.Request) {
+	var p = strings.Replace(req.URL.Path, ih.PresentationWebPathPrefix+"/", "", 1)
+	if !strings.HasSuffix(p, "/manifest") {
+		http.NotFound(w, req)
+		return
+	}
+	var id = iiif.URLToID(strings.TrimSuffix(p, "/manifest"))
+
+	var sidecar, err = ih.loadManifestSidecar(id)
+	if err != nil {
+		Logger.Errorf("Cannot parse manifest sidecar for %q: %s", id, err)
+		http.Error(w, "invalid manifest data", 500)
+		return
+	}
+
+	// Image services are advertised under the 3.0 path when there is one, as
+	// Presentation 3.0 clients are most likely to understand Image API 3.0
+	var prefix, version = ih.WebPathPrefix, iiif.V2
+	if ih.V3WebPathPrefix != "" {
+		prefix, version = ih.V3WebPathPrefix, iiif.V3
+	}
+
+	// Each image gets the same checks as its info.json request would, so a
+	// manifest can't reveal anything its images' info responses wouldn't
+	var base = ih.requestBaseURL(req)
+	var m = presentation.NewManifest(base.String()+ih.PresentationWebPathPrefix+"/"+id.Escaped(), sidecar.Label)
+	for _, image := range sidecar.Images {
+		var u = &iiif.URL{Path: image.ID.Escaped() + "/info.json", Version: version, ID: image.ID, Info: true}
+		var res, info, _, e = ih.describeImage(req, u, base, prefix)
+		if e != nil {
+			http.Error(w, e.Message, e.Code)
+			return
+		}
+		res.Destroy()
+		m.AddImage(info, image.Label)
+	}
+
+	var data []byte
+	data, err = json.Marshal(m)
+	if err != nil {
+		Logger.Errorf("Unable to marshal manifest for %q: %s", id, err)
+		http.Error(w, "server error", 500)
+		return
+	}
+
+	var ct = "application/json"
+	if acceptsLD(req) {
+		ct = `application/ld+json;profile="` + presentation.Context + `"`
+	}
+	w.Header().Set("Content-Type", ct)
+	w.Header().Set("Vary", "Accept")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(data)
+}
+
+// loadManifestSidecar reads the list of images for id's manifest.  If id
+// isn't a local file or has no sidecar, the manifest is just the one image.
+func (ih *ImageHandler) loadManifestSidecar(id iiif.ID) (*manifestSidecar, error) {
+	var sidecar = &manifestSidecar{Label: string(id), Images: []manifestImage{{ID: id}}}
+
+	var u, err = ih.resolveURL(id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "file" {
+		return sidecar, nil
+	}
+
+	var data []byte
+	data, err = ioutil.ReadFile(u.Path + "-manifest.json")
+	if os.IsNotExist(err) {
+		return sidecar, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	Logger.Debugf("Loading manifest data from sidecar file (%s-manifest.json)", u.Path)
+	sidecar = &manifestSidecar{Label: string(id)}
+	err = json.Unmarshal(data, sidecar)
+	if err == nil && len(sidecar.Images) == 0 {
+		err = errors.New("no images listed")
+	}
+	return sidecar, err
+}
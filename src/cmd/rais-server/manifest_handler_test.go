@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rais/src/auth"
+	"rais/src/fakehttp"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/presentation"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestLoadManifestSidecar(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-manifest-")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var sidecar = `{"label": "Book", "images": [{"id": "book/1.jp2", "label": "Cover"}, {"id": "book/2.jp2"}]}`
+	ioutil.WriteFile(filepath.Join(dir, "book-manifest.json"), []byte(sidecar), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "empty-manifest.json"), []byte(`{"images": []}`), 0644)
+
+	var h = NewImageHandler(dir, "/iiif")
+	var data *manifestSidecar
+	data, err = h.loadManifestSidecar("book")
+	assert.NilError(err, "loading sidecar", t)
+	var expected = &manifestSidecar{
+		Label:  "Book",
+		Images: []manifestImage{{ID: "book/1.jp2", Label: "Cover"}, {ID: "book/2.jp2"}},
+	}
+	if diff := cmp.Diff(expected, data); diff != "" {
+		t.Errorf("sidecar: %s", diff)
+	}
+
+	data, err = h.loadManifestSidecar("single.jp2")
+	assert.NilError(err, "no sidecar", t)
+	expected = &manifestSidecar{Label: "single.jp2", Images: []manifestImage{{ID: "single.jp2"}}}
+	if diff := cmp.Diff(expected, data); diff != "" {
+		t.Errorf("single image: %s", diff)
+	}
+
+	_, err = h.loadManifestSidecar("empty")
+	assert.True(err != nil, "sidecar with no images is an error", t)
+}
+
+func TestManifestRoute(t *testing.T) {
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.PresentationWebPathPrefix = "/presentation"
+	h.BaseURL, _ = url.Parse("https://example.org")
+
+	var w = fakehttp.NewResponseWriter()
+	var reqPath = "/presentation/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/manifest"
+	var req, _ = http.NewRequest("GET", reqPath, strings.NewReader(""))
+	h.ManifestRoute(w, req)
+	assert.Equal(-1, w.StatusCode, "Manifest request succeeds", t)
+
+	var m presentation.Manifest
+	var err = json.Unmarshal(w.Output, &m)
+	assert.NilError(err, "decoding manifest", t)
+	assert.Equal("https://example.org/presentation/docker%2Fimages%2Ftestfile%2Ftest-world.jp2/manifest", m.ID, "manifest id", t)
+	assert.Equal(1, len(m.Items), "one canvas", t)
+	assert.Equal(800, m.Items[0].Width, "canvas width", t)
+	assert.Equal(400, m.Items[0].Height, "canvas height", t)
+
+	var service = m.Items[0].Items[0].Items[0].Body.Service[0]
+	assert.Equal("https://example.org/iiif/docker%2Fimages%2Ftestfile%2Ftest-world.jp2", service.LDID, "service id", t)
+	assert.Equal("ImageService2", service.LDType, "service type", t)
+
+	w = fakehttp.NewResponseWriter()
+	req, _ = http.NewRequest("GET", "/presentation/docker%2Fimages%2Ftestfile%2Ftest-world.jp2", strings.NewReader(""))
+	h.ManifestRoute(w, req)
+	assert.Equal(404, w.StatusCode, "Non-manifest request is a 404", t)
+}
+
+func TestManifestRouteChecks(t *testing.T) {
+	var manifestRequest = func(h *ImageHandler, id iiif.ID) *fakehttp.ResponseWriter {
+		h.PresentationWebPathPrefix = "/presentation"
+		var w = fakehttp.NewResponseWriter()
+		var req, _ = http.NewRequest("GET", "/presentation/"+id.Escaped()+"/manifest", nil)
+		h.ManifestRoute(w, req)
+		return w
+	}
+
+	var h = NewImageHandler(rootDir(), "/iiif")
+	h.AddSchemeMap("restricted", "file://"+rootDir()+"/docker/images/testfile")
+	h.URLSigner = auth.NewURLSigner([]byte("secret"))
+	h.SignedSchemes = []string{"restricted"}
+	var w = manifestRequest(h, "restricted://test-world.jp2")
+	assert.Equal(403, w.StatusCode, "unsigned manifest for a signed image is forbidden", t)
+
+	authorizePlugins = []func(*http.Request, iiif.ID, *iiif.URL) (bool, *img.Constraint, error){
+		func(req *http.Request, id iiif.ID, u *iiif.URL) (bool, *img.Constraint, error) {
+			return false, nil, nil
+		},
+	}
+	defer func() { authorizePlugins = nil }()
+	w = manifestRequest(NewImageHandler(rootDir(), "/iiif"), "docker/images/testfile/test-world.jp2")
+	assert.Equal(403, w.StatusCode, "Authorize plugins can deny manifests", t)
+}
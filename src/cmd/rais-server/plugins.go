@@ -1,17 +1,24 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"image"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"plugin"
 	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins/registry"
 	"reflect"
 	"sort"
 	"strings"
 
+	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
@@ -19,6 +26,11 @@
 var teardownPlugins []func()
 var purgeCachePlugins []func()
 var expireCachedImagePlugins []func(iiif.ID)
+var idToURLPlugins []func(iiif.ID) (*url.URL, error)
+var transformImagePlugins []func(iiif.ID, *iiif.URL, image.Image) (image.Image, error)
+var transformKeys []string
+var augmentInfoPlugins []func(iiif.ID, *iiif.Info) error
+var authorizePlugins []func(*http.Request, iiif.ID, *iiif.URL) (bool, *img.Constraint, error)
 
 // pluginsFor returns a list of all plugin files which matched the given
 // pattern.  Files are sorted by name.
@@ -40,13 +52,27 @@ func pluginsFor(pattern string) ([]string, error) {
 	return files, nil
 }
 
-// LoadPlugins searches for any plugins matching the pattern given.  If the
-// pattern is not an absolute URL, it is treated as a pattern under the
-// binary's dir/plugins.
+// LoadPlugins enables the plugins named or matched by the given list, in
+// order.  An item which is the name of a compiled-in plugin enables that
+// plugin.  Anything else is a pattern for .so files; if the pattern is not an
+// absolute URL, it is treated as a pattern under the binary's dir/plugins.
 func LoadPlugins(l *logger.Logger, patterns []string) {
-	var plugFiles []string
+	for _, err := range registry.Errors() {
+		l.Errorf("Unable to load compiled-in plugin: %s", err)
+	}
+
+	var plugSources []string
 	var seen = make(map[string]bool)
 	for _, pattern := range patterns {
+		if registry.Lookup(pattern) != nil {
+			if seen[pattern] {
+				l.Fatalf("Cannot load the same plugin twice (%q)", pattern)
+			}
+			seen[pattern] = true
+			plugSources = append(plugSources, pattern)
+			continue
+		}
+
 		var matches, err = pluginsFor(pattern)
 		if err != nil {
 			l.Warnf("Skipping invalid plugin pattern %q: %s", pattern, err)
@@ -63,14 +89,21 @@ func LoadPlugins(l *logger.Logger, patterns []string) {
 			seen[file] = true
 		}
 
-		plugFiles = append(plugFiles, matches...)
+		plugSources = append(plugSources, matches...)
 	}
 
-	for _, file := range plugFiles {
-		l.Infof("Loading plugin %q", file)
-		var err = loadPlugin(file, l)
+	for _, src := range plugSources {
+		var p = registry.Lookup(src)
+		if p != nil {
+			l.Infof("Enabling compiled-in plugin %q", src)
+			enablePlugin(p, "", l)
+			continue
+		}
+
+		l.Infof("Loading plugin %q", src)
+		var err = loadPlugin(src, l)
 		if err != nil {
-			l.Errorf("Unable to load %q: %s", file, err)
+			l.Errorf("Unable to load %q: %s", src, err)
 		}
 	}
 }
@@ -112,46 +145,57 @@ func (pw *pluginWrapper) loadPluginFn(name string, obj interface{}) {
 	pw.functions = append(pw.functions, name)
 }
 
-// loadPlugin attempts to read the given plugin file and extract known symbols.
-// If a plugin exposes Initialize or SetLogger, they're called here once we're
-// sure the plugin is valid.  All other functions are indexed globally for use
-// in the RAIS image serving handler.
+// loadPlugin attempts to read the given plugin file.  Plugins which register
+// themselves with the plugin registry when opened are enabled as-is, except
+// those the registry rejected, which are reported and skipped.  For
+// everything else, known symbols are extracted and validated the same way as
+// registered plugins.
 func loadPlugin(fullpath string, l *logger.Logger) error {
+	var registered = registry.Names()
+	var failed = len(registry.Errors())
 	var pw, err = newPluginWrapper(fullpath)
 	if err != nil {
 		return err
 	}
 
-	// Set up dummy / no-op functions so we can call these without risk
-	var log = func(*logger.Logger) {}
-	var initialize = func() {}
-
-	// Simply initialize those functions we only want indexed if they exist
-	var teardown func()
-	var wrapHandler func(string, http.Handler) (http.Handler, error)
-	var prgCache func()
-	var expCachedImg func(iiif.ID)
+	var added = newNames(registered, registry.Names())
+	var regErrs = registry.Errors()[failed:]
+	for _, err := range regErrs {
+		l.Errorf("Unable to load plugin from %q: %s", fullpath, err)
+	}
+	if len(added) != 0 || len(regErrs) != 0 {
+		for _, name := range added {
+			enablePlugin(registry.Lookup(name), fullpath, l)
+		}
+		return nil
+	}
 
-	pw.loadPluginFn("SetLogger", &log)
-	pw.loadPluginFn("Initialize", &initialize)
-	pw.loadPluginFn("Teardown", &teardown)
-	pw.loadPluginFn("WrapHandler", &wrapHandler)
-	pw.loadPluginFn("PurgeCaches", &prgCache)
-	pw.loadPluginFn("ExpireCachedImage", &expCachedImg)
+	var p = new(registry.Plugin)
+	pw.loadPluginVar("Name", &p.Name)
+	pw.loadPluginVar("APIVersion", &p.APIVersion)
+	pw.loadPluginFn("SetLogger", &p.SetLogger)
+	pw.loadPluginFn("Initialize", &p.Initialize)
+	pw.loadPluginFn("Teardown", &p.Teardown)
+	pw.loadPluginFn("WrapHandler", &p.WrapHandler)
+	pw.loadPluginFn("PurgeCaches", &p.PurgeCaches)
+	pw.loadPluginFn("ExpireCachedImage", &p.ExpireCachedImage)
+	pw.loadPluginFn("IDToURL", &p.IDToURL)
+	pw.loadPluginFn("TransformImage", &p.TransformImage)
+	pw.loadPluginFn("TransformVersion", &p.TransformVersion)
+	pw.loadPluginFn("AugmentInfo", &p.AugmentInfo)
+	pw.loadPluginFn("Authorize", &p.Authorize)
 
 	if len(pw.errors) != 0 {
 		return errors.New(strings.Join(pw.errors, ", "))
 	}
+	err = p.Validate()
+	if err != nil {
+		return err
+	}
 	if len(pw.functions) == 0 {
 		return fmt.Errorf("no known functions exposed")
 	}
 
-	// We need to call SetLogger and Initialize immediately, as they're never
-	// called a second time and they tell us if the plugin is going to be used
-	log(l)
-	initialize()
-
-	// After initialization, we check if the plugin explicitly set itself to Disabled
 	var sym plugin.Symbol
 	sym, err = pw.Lookup("Disabled")
 	if err == nil {
@@ -159,32 +203,155 @@ func loadPlugin(fullpath string, l *logger.Logger) error {
 		if !ok {
 			return fmt.Errorf("non-boolean Disabled value exposed")
 		}
-		if *disabled {
-			l.Infof("%q is disabled", fullpath)
-			return nil
+		p.Disabled = disabled
+	}
+
+	enablePlugin(p, fullpath, l)
+	return nil
+}
+
+// loadPluginVar copies the value of the exported variable with the given name
+// into obj if the plugin exposes it.  If the variable's type doesn't match,
+// an error is added to the pluginWrapper's error list.
+func (pw *pluginWrapper) loadPluginVar(name string, obj interface{}) {
+	var sym, err = pw.Lookup(name)
+	if err != nil {
+		return
+	}
+
+	var objElem = reflect.ValueOf(obj).Elem()
+	var symV = reflect.ValueOf(sym)
+	if symV.Type() != reflect.PtrTo(objElem.Type()) {
+		pw.errors = append(pw.errors, fmt.Sprintf("invalid type for %s (expecting %s)", name, objElem.Type()))
+		return
+	}
+
+	objElem.Set(symV.Elem())
+}
+
+// newNames returns the names in after which aren't in before.  Both lists
+// must be sorted.
+func newNames(before, after []string) []string {
+	var added []string
+	var i int
+	for _, name := range after {
+		for i < len(before) && before[i] < name {
+			i++
+		}
+		if i < len(before) && before[i] == name {
+			continue
 		}
-		l.Debugf("%q is explicitly enabled", fullpath)
+		added = append(added, name)
+	}
+	return added
+}
+
+// enablePlugin calls the plugin's SetLogger and Initialize functions, then
+// indexes its other functions globally for use in the RAIS image serving
+// handler unless the plugin failed to initialize or set itself to Disabled.
+// path is the plugin file, if any, and is only used for reporting.
+func enablePlugin(p *registry.Plugin, path string, l *logger.Logger) {
+	var ps = plugStats{
+		Name:       p.Name,
+		Path:       path,
+		APIVersion: p.APIVersion,
+		Config:     pluginConfigs[p.Name],
+		Functions:  pluginFunctions(p),
 	}
+	defer func() { stats.Plugins = append(stats.Plugins, ps) }()
+
+	// We need to call SetLogger and Initialize immediately, as they're never
+	// called a second time and they tell us if the plugin is going to be used
+	if p.SetLogger != nil {
+		p.SetLogger(l)
+	}
+	if p.Initialize != nil {
+		var err = p.Initialize(pluginSettings(p.Name))
+		if err != nil {
+			l.Errorf("Plugin %q failed to initialize: %s", p.Name, err)
+			return
+		}
+	}
+
+	// After initialization, we check if the plugin explicitly set itself to Disabled
+	if p.Disabled != nil {
+		if *p.Disabled {
+			l.Infof("Plugin %q is disabled", p.Name)
+			return
+		}
+		l.Debugf("Plugin %q is explicitly enabled", p.Name)
+	}
+	ps.Enabled = true
 
 	// Index remaining functions
-	if teardown != nil {
-		teardownPlugins = append(teardownPlugins, teardown)
+	if p.Teardown != nil {
+		teardownPlugins = append(teardownPlugins, p.Teardown)
+	}
+	if p.WrapHandler != nil {
+		wrapHandlerPlugins = append(wrapHandlerPlugins, p.WrapHandler)
 	}
-	if wrapHandler != nil {
-		wrapHandlerPlugins = append(wrapHandlerPlugins, wrapHandler)
+	if p.PurgeCaches != nil {
+		purgeCachePlugins = append(purgeCachePlugins, p.PurgeCaches)
 	}
-	if prgCache != nil {
-		purgeCachePlugins = append(purgeCachePlugins, prgCache)
+	if p.ExpireCachedImage != nil {
+		expireCachedImagePlugins = append(expireCachedImagePlugins, p.ExpireCachedImage)
 	}
-	if expCachedImg != nil {
-		expireCachedImagePlugins = append(expireCachedImagePlugins, expCachedImg)
+	if p.IDToURL != nil {
+		idToURLPlugins = append(idToURLPlugins, p.IDToURL)
 	}
+	if p.TransformImage != nil {
+		transformImagePlugins = append(transformImagePlugins, p.TransformImage)
+		transformKeys = append(transformKeys, transformKey(p))
+	}
+	if p.AugmentInfo != nil {
+		augmentInfoPlugins = append(augmentInfoPlugins, p.AugmentInfo)
+	}
+	if p.Authorize != nil {
+		authorizePlugins = append(authorizePlugins, p.Authorize)
+	}
+}
 
-	// Add info to stats
-	stats.Plugins = append(stats.Plugins, plugStats{
-		Path:      fullpath,
-		Functions: pw.functions,
-	})
+// transformKey identifies a TransformImage plugin and its current output so
+// that images cached before the plugin or its configuration changed are never
+// served.  Plugins which don't report a version are identified by a hash of
+// their settings.
+func transformKey(p *registry.Plugin) string {
+	if p.TransformVersion != nil {
+		return p.Name + "@" + p.TransformVersion()
+	}
 
-	return nil
+	var cfg = pluginConfigs[p.Name]
+	if len(cfg) == 0 {
+		return p.Name
+	}
+	// JSON sorts map keys, so the same settings always give the same hash
+	var data, err = json.Marshal(cfg)
+	if err != nil {
+		return p.Name
+	}
+	var sum = sha256.Sum256(data)
+	return fmt.Sprintf("%s@%x", p.Name, sum[:8])
+}
+
+// pluginSettings returns the settings from the plugin's [plugins.<name>]
+// table in rais.toml
+func pluginSettings(name string) *viper.Viper {
+	var v = viper.New()
+	for key, val := range pluginConfigs[name] {
+		v.Set(key, val)
+	}
+	return v
+}
+
+// pluginFunctions returns the names of the functions p exposes
+func pluginFunctions(p *registry.Plugin) []string {
+	var fns []string
+	var v = reflect.ValueOf(p).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		var f = v.Field(i)
+		if f.Kind() == reflect.Func && !f.IsNil() {
+			fns = append(fns, v.Type().Field(i).Name)
+		}
+	}
+	return fns
 }
@@ -0,0 +1,7 @@
+//go:build plugin_datadog
+// +build plugin_datadog
+
+package main
+
+// Compile the datadog plugin into RAIS when building with "-tags plugin_datadog"
+import _ "rais/src/plugins/datadog"
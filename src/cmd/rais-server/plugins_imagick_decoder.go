@@ -0,0 +1,7 @@
+//go:build plugin_imagick_decoder
+// +build plugin_imagick_decoder
+
+package main
+
+// Compile the imagick-decoder plugin into RAIS when building with "-tags plugin_imagick_decoder"
+import _ "rais/src/plugins/imagick-decoder"
@@ -0,0 +1,7 @@
+//go:build plugin_json_tracer
+// +build plugin_json_tracer
+
+package main
+
+// Compile the json-tracer plugin into RAIS when building with "-tags plugin_json_tracer"
+import _ "rais/src/plugins/json-tracer"
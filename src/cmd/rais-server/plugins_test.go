@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"image"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"rais/src/iiif"
+	"rais/src/plugins/registry"
+	"strings"
+	"testing"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+func TestLoadCompiledInPlugins(t *testing.T) {
+	var initialized []string
+	var settings string
+	var wrap = func(pattern string, h http.Handler) (http.Handler, error) { return h, nil }
+	var enabled, disabled = false, true
+	registry.Register(&registry.Plugin{
+		Name:       "test-enabled",
+		APIVersion: registry.APIVersion,
+		Disabled:   &enabled,
+		Initialize: func(cfg *viper.Viper) error {
+			initialized = append(initialized, "test-enabled")
+			settings = cfg.GetString("Setting")
+			return nil
+		},
+		WrapHandler: wrap,
+		Teardown:    func() {},
+	})
+	registry.Register(&registry.Plugin{
+		Name:       "test-disabled",
+		APIVersion: registry.APIVersion,
+		Disabled:   &disabled,
+		Initialize: func(*viper.Viper) error {
+			initialized = append(initialized, "test-disabled")
+			return nil
+		},
+		WrapHandler: wrap,
+	})
+	registry.Register(&registry.Plugin{
+		Name:       "test-failed",
+		APIVersion: registry.APIVersion,
+		Initialize: func(*viper.Viper) error {
+			initialized = append(initialized, "test-failed")
+			return errors.New("bad config")
+		},
+		WrapHandler: wrap,
+	})
+	registry.Register(&registry.Plugin{
+		Name:       "test-unlisted",
+		APIVersion: registry.APIVersion,
+		Initialize: func(*viper.Viper) error {
+			initialized = append(initialized, "test-unlisted")
+			return nil
+		},
+		WrapHandler: wrap,
+	})
+	registry.Register(&registry.Plugin{
+		Name:       "test-old",
+		APIVersion: registry.APIVersion - 1,
+		Initialize: func(*viper.Viper) error {
+			initialized = append(initialized, "test-old")
+			return nil
+		},
+		WrapHandler: wrap,
+	})
+	pluginConfigs["test-enabled"] = map[string]interface{}{"setting": "foo"}
+	defer func() {
+		wrapHandlerPlugins = nil
+		teardownPlugins = nil
+		stats.Plugins = nil
+		delete(pluginConfigs, "test-enabled")
+	}()
+
+	LoadPlugins(logger.New(logger.Warn), []string{"test-disabled", "test-failed", "test-old", "test-enabled"})
+
+	assert.Equal(3, len(initialized), "listed plugins are initialized, except the incompatible one", t)
+	assert.Equal("test-disabled", initialized[0], "plugins are initialized in order", t)
+	assert.Equal("foo", settings, "the plugin's config table is passed to Initialize", t)
+	assert.Equal(1, len(wrapHandlerPlugins), "only the enabled plugin's handler wrapper is indexed", t)
+	assert.Equal(1, len(teardownPlugins), "teardown is indexed", t)
+	assert.Equal(3, len(stats.Plugins), "listed plugins are in stats", t)
+	assert.False(stats.Plugins[0].Enabled, "the disabled plugin isn't enabled in stats", t)
+	assert.False(stats.Plugins[1].Enabled, "the failed plugin isn't enabled in stats", t)
+
+	var ps = stats.Plugins[2]
+	assert.Equal("test-enabled", ps.Name, "plugin name in stats", t)
+	assert.True(ps.Enabled, "the enabled plugin is enabled in stats", t)
+	assert.Equal("", ps.Path, "compiled-in plugins have no path", t)
+	assert.Equal(registry.APIVersion, ps.APIVersion, "plugin API version in stats", t)
+	assert.Equal("foo", ps.Config["setting"], "plugin config in stats", t)
+	assert.Equal(3, len(ps.Functions), "plugin functions in stats", t)
+}
+
+func TestReadPluginConfigs(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "rais-config-")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	var path = filepath.Join(dir, "rais.toml")
+	var conf = "Plugins = \"test-a\"\nAddress = \":9999\"\n\n[plugins.test-a]\nFile = \"/tmp/a.png\"\nMinWidth = 10\n"
+	err = ioutil.WriteFile(path, []byte(conf), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write config: %s", err)
+	}
+	viper.SetConfigFile(path)
+	defer func() {
+		viper.Reset()
+		delete(pluginConfigs, "test-a")
+	}()
+
+	err = readPluginConfigs(path)
+	assert.NilError(err, "reading the config", t)
+	assert.Equal("test-a", viper.GetString("Plugins"), "the Plugins setting is kept", t)
+	assert.Equal(":9999", viper.GetString("Address"), "other settings are kept", t)
+	assert.Equal("/tmp/a.png", pluginConfigs["test-a"]["File"], "plugin table string", t)
+	assert.Equal(int64(10), pluginConfigs["test-a"]["MinWidth"], "plugin table integer", t)
+
+	err = ioutil.WriteFile(path, []byte("plugins = \"test-a\"\n[plugins]\n"), 0600)
+	if err != nil {
+		t.Fatalf("Unable to write config: %s", err)
+	}
+	err = readPluginConfigs(path)
+	assert.True(err != nil, "a config with a duplicate plugins key is rejected", t)
+}
+
+func TestTransformCacheKeys(t *testing.T) {
+	var transform = func(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) { return m, nil }
+	var version = "v1"
+	var versioned = &registry.Plugin{
+		Name:             "test-versioned",
+		APIVersion:       registry.APIVersion,
+		TransformImage:   transform,
+		TransformVersion: func() string { return version },
+	}
+	var configured = &registry.Plugin{
+		Name:           "test-configured",
+		APIVersion:     registry.APIVersion,
+		TransformImage: transform,
+	}
+	pluginConfigs["test-configured"] = map[string]interface{}{"file": "a.png"}
+	tileCacheRules, _ = loadCacheRules()
+	tileCache, _ = lru.New2Q(10)
+	defer func() {
+		transformImagePlugins = nil
+		transformKeys = nil
+		stats.Plugins = nil
+		tileCacheRules = nil
+		tileCache = nil
+		delete(pluginConfigs, "test-configured")
+	}()
+
+	var info = &iiif.Info{Width: 2048, Height: 2048}
+	var u, _ = iiif.NewURL("foo.jp2/0,0,1024,1024/512,/0/default.jpg")
+	var plain, _ = cacheKey(u, info)
+
+	var l = logger.New(logger.Warn)
+	enablePlugin(versioned, "", l)
+	enablePlugin(configured, "", l)
+	assert.Equal(2, len(transformKeys), "transform plugins are added to the cache keys", t)
+	assert.Equal("test-versioned@v1", transformKeys[0], "plugin-supplied version", t)
+	assert.True(strings.HasPrefix(transformKeys[1], "test-configured@"), "config hash is used without a version", t)
+
+	var key, _ = cacheKey(u, info)
+	assert.True(key != plain, "transform plugins change the cache key", t)
+
+	var before = transformKeys
+	transformKeys = nil
+	version = "v2"
+	enablePlugin(versioned, "", l)
+	pluginConfigs["test-configured"]["file"] = "b.png"
+	enablePlugin(configured, "", l)
+	assert.True(before[0] != transformKeys[0], "a new version changes the key", t)
+	assert.True(before[1] != transformKeys[1], "new settings change the key", t)
+}
+
+func TestNewNames(t *testing.T) {
+	var added = newNames([]string{"b", "d"}, []string{"a", "b", "c", "d", "e"})
+	assert.Equal(3, len(added), "three names were added", t)
+	assert.Equal("a", added[0], "first added name", t)
+	assert.Equal("c", added[1], "second added name", t)
+	assert.Equal("e", added[2], "third added name", t)
+}
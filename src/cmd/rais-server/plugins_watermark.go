@@ -0,0 +1,7 @@
+//go:build plugin_watermark
+// +build plugin_watermark
+
+package main
+
+// Compile the watermark plugin into RAIS when building with "-tags plugin_watermark"
+import _ "rais/src/plugins/watermark"
@@ -8,8 +8,12 @@
 )
 
 type plugStats struct {
-	Path      string
-	Functions []string
+	Name       string
+	Path       string
+	APIVersion int
+	Enabled    bool
+	Config     map[string]interface{}
+	Functions  []string
 }
 
 type cacheStats struct {
@@ -17,6 +21,7 @@ type cacheStats struct {
 	GetHits    uint64
 	SetCount   uint64
 	Length     int
+	Bytes      int64
 	m          sync.Mutex
 	Enabled    bool
 	HitPercent float64
@@ -52,14 +57,16 @@ func (cs *cacheStats) Set() {
 // know only one thread can possibly exist!  (e.g., when first setting up the
 // object)
 type serverStats struct {
-	m           sync.Mutex
-	InfoCache   cacheStats
-	TileCache   cacheStats
-	Plugins     []plugStats
-	RAISVersion string
-	RAISBuild   string
-	ServerStart time.Time
-	Uptime      string
+	m             sync.Mutex
+	InfoCache     cacheStats
+	TileCache     cacheStats
+	DiskTileCache cacheStats
+	URLCache      cacheStats
+	Plugins       []plugStats
+	RAISVersion   string
+	RAISBuild     string
+	ServerStart   time.Time
+	Uptime        string
 }
 
 // Serialize writes the stats data to w in JSON format
@@ -82,6 +89,15 @@ func (s *serverStats) calculateDerivedStats() {
 		s.TileCache.setHitPercent()
 		s.TileCache.Length = tileCache.Len()
 	}
+	if diskTileCache != nil {
+		s.DiskTileCache.setHitPercent()
+		s.DiskTileCache.Length = diskTileCache.Len()
+		s.DiskTileCache.Bytes = diskTileCache.Size()
+	}
+	if urlCache != nil {
+		s.URLCache.setHitPercent()
+		s.URLCache.Length = urlCache.Len()
+	}
 
 	s.m.Unlock()
 }
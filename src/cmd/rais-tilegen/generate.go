@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"io/ioutil"
+	"math"
+	"net/url"
+	"os"
+	"path/filepath"
+	"rais/src/encode"
+	"rais/src/iiif"
+	"rais/src/img"
+	"strings"
+	"sync"
+)
+
+var unlimited = img.Constraint{Width: math.MaxInt32, Height: math.MaxInt32, Area: math.MaxInt64}
+
+// generator renders static pyramids for IDs
+type generator struct {
+	outputPath  string
+	baseURL     string
+	tileSize    int
+	format      iiif.Format
+	version     iiif.Version
+	concurrency int
+	force       bool
+	encodeOpts  *encode.Options
+}
+
+// generate writes the pyramid for id.  info.json is written last, so its
+// presence means the pyramid is complete and needn't be generated again.
+// When it's missing, only the files which don't already exist are rendered,
+// allowing an interrupted run to pick up where it left off.
+func (g *generator) generate(id string) error {
+	var dir = g.imageDir(id)
+	var infoPath = filepath.Join(dir, "info.json")
+	if !g.force && fileExists(infoPath) {
+		Logger.Infof("Skipping %q: %s already exists", id, infoPath)
+		return nil
+	}
+
+	var res, err = img.NewResource(iiif.ID(id), sourceURL(id))
+	if err != nil {
+		return err
+	}
+	var d img.Decoder
+	d, err = res.Decoder()
+	if err != nil {
+		res.Destroy()
+		return err
+	}
+	var p = newPyramid(d.GetWidth(), d.GetHeight(), g.tileSize)
+	res.Destroy()
+
+	var jobs = p.jobs(iiif.ID(id), g.version, g.format)
+	Logger.Infof("Generating %d images for %q (%d x %d)", len(jobs), id, p.width, p.height)
+	err = g.render(id, dir, jobs)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	data, err = json.Marshal(p.info(g.infoID(id), g.version, g.format))
+	if err != nil {
+		return fmt.Errorf("unable to marshal info.json: %w", err)
+	}
+	return writeFile(infoPath, data)
+}
+
+// render runs the jobs across the generator's workers, returning an error if
+// any of them failed
+func (g *generator) render(id, dir string, jobs []job) error {
+	var queue = make(chan job)
+	var failures int
+	var m sync.Mutex
+	var wg sync.WaitGroup
+
+	for i := 0; i < g.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range queue {
+				var err = g.renderJob(id, filepath.Join(dir, filepath.FromSlash(j.path)), j.url)
+				if err != nil {
+					Logger.Errorf("Unable to render %q for %q: %s", j.path, id, err)
+					m.Lock()
+					failures++
+					m.Unlock()
+				}
+			}
+		}()
+	}
+
+	for _, j := range jobs {
+		queue <- j
+	}
+	close(queue)
+	wg.Wait()
+
+	if failures > 0 {
+		return fmt.Errorf("%d of %d images failed", failures, len(jobs))
+	}
+	return nil
+}
+
+// renderJob writes the image for u to dest unless it already exists
+func (g *generator) renderJob(id, dest string, u *iiif.URL) error {
+	if !g.force && fileExists(dest) {
+		return nil
+	}
+
+	// Resources aren't safe for concurrent use, so each job opens its own, just
+	// as RAIS does for each request
+	var res, err = img.NewResource(iiif.ID(id), sourceURL(id))
+	if err != nil {
+		return err
+	}
+	defer res.Destroy()
+
+	var m image.Image
+	m, err = res.Apply(u, unlimited)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	err = encode.Image(&buf, m, u.Format, encode.GetOutputResolution(res, u, m), g.encodeOpts)
+	if err != nil {
+		return err
+	}
+	return writeFile(dest, buf.Bytes())
+}
+
+// imageDir returns the directory an id's pyramid is written to.  Slashes in
+// the id become subdirectories, and parent directory references are removed
+// so nothing can be written outside the output path.
+func (g *generator) imageDir(id string) string {
+	var clean = strings.Replace(id, "..", "", -1)
+	if u, err := url.Parse(id); err == nil && u.Scheme != "" {
+		clean = strings.Replace(u.Host+u.Path, "..", "", -1)
+	}
+	return filepath.Join(g.outputPath, filepath.FromSlash(clean))
+}
+
+// infoID returns the IIIF id for info.json: the base URL plus the id's path
+// under the output directory, so that clients' requests map to files
+func (g *generator) infoID(id string) string {
+	var rel, _ = filepath.Rel(g.outputPath, g.imageDir(id))
+	var parts = strings.Split(filepath.ToSlash(rel), "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return g.baseURL + "/" + strings.Join(parts, "/")
+}
+
+func fileExists(pth string) bool {
+	var _, err = os.Stat(pth)
+	return err == nil
+}
+
+// writeFile writes data to a temporary file and renames it to pth, so an
+// interrupted run never leaves a partial file where a complete one belongs
+func writeFile(pth string, data []byte) error {
+	var dir = filepath.Dir(pth)
+	var err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	var f *os.File
+	f, err = ioutil.TempFile(dir, ".tilegen-")
+	if err != nil {
+		return err
+	}
+	var tmp = f.Name()
+
+	_, err = f.Write(data)
+	var closeErr = f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, pth)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("unable to write %q: %w", pth, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+// rais-tilegen renders static IIIF level 0 tile pyramids: every tile, every
+// size listed in info.json, and info.json itself, written to a directory tree
+// which any plain web server can host
+package main
+
+import (
+	"net/url"
+	"os"
+	"rais/src/encode"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/openjpeg"
+	"rais/src/plugins"
+	"runtime"
+	"strings"
+
+	"github.com/jessevdk/go-flags"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// Logger is the command's global logger
+var Logger = logger.New(logger.Info)
+
+var opts struct {
+	TilePath    string `long:"tile-path" description:"base path for IDs which aren't URLs" default:"."`
+	OutputPath  string `short:"o" long:"output-path" description:"directory to write the static IIIF tree to" required:"true"`
+	BaseURL     string `long:"base-url" description:"URL the output directory will be served from, e.g., https://example.org/iiif" required:"true"`
+	TileSize    int    `long:"tile-size" description:"width and height of tiles" default:"512"`
+	Format      string `long:"format" description:"output image format" default:"jpg"`
+	V3          bool   `long:"v3" description:"write IIIF 3.0 info.json and paths rather than 2.x"`
+	Concurrency int    `short:"c" long:"concurrency" description:"number of images to render at once (defaults to the number of CPUs)"`
+	JPGQuality  int    `long:"jpg-quality" description:"quality of JPEG output" default:"75"`
+	Force       bool   `long:"force" description:"regenerate everything rather than resuming from existing files"`
+}
+
+func main() {
+	var parser = flags.NewParser(&opts, flags.Default)
+	parser.Usage = "[OPTIONS] id [id...]"
+	var args, err = parser.Parse()
+	if err != nil {
+		os.Exit(1)
+	}
+	if len(args) < 1 {
+		parser.WriteHelp(os.Stderr)
+		os.Exit(1)
+	}
+
+	var g = &generator{
+		outputPath:  opts.OutputPath,
+		baseURL:     strings.TrimRight(opts.BaseURL, "/"),
+		tileSize:    opts.TileSize,
+		format:      iiif.StringToFormat(opts.Format),
+		version:     iiif.V2,
+		concurrency: opts.Concurrency,
+		force:       opts.Force,
+		encodeOpts:  encode.DefaultOptions(),
+	}
+	if opts.V3 {
+		g.version = iiif.V3
+	}
+	if g.concurrency < 1 {
+		g.concurrency = runtime.NumCPU()
+	}
+	g.encodeOpts.JPGQuality = opts.JPGQuality
+
+	if g.format == iiif.FmtUnknown {
+		Logger.Fatalf("Invalid format %q", opts.Format)
+	}
+	if g.tileSize < 1 {
+		Logger.Fatalf("Invalid tile size %d", g.tileSize)
+	}
+
+	img.RegisterDecodeHandler(decodeJP2)
+	img.RegisterStreamReader(fileStreamReader)
+	img.RegisterStreamReader(cloudStreamReader)
+
+	var failed bool
+	for _, id := range args {
+		var err = g.generate(id)
+		if err != nil {
+			Logger.Errorf("Unable to generate %q: %s", id, err)
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// sourceURL returns the URL for reading the given id's image: the id itself
+// if it's a URL, otherwise a file under the tile path
+func sourceURL(id string) *url.URL {
+	var u, err = url.Parse(id)
+	if err == nil && u.Scheme != "" {
+		return u
+	}
+	return &url.URL{Scheme: "file", Path: opts.TilePath + "/" + id}
+}
+
+func decodeJP2(s img.Streamer) (img.DecodeFunc, error) {
+	return func() (img.Decoder, error) { return openjpeg.NewJP2Image(s) }, nil
+}
+
+func fileStreamReader(u *url.URL) (img.OpenStreamFunc, error) {
+	if u.Scheme != "file" {
+		return nil, plugins.ErrSkipped
+	}
+	return func() (img.Streamer, error) { return img.NewFileStream(u.Path) }, nil
+}
+
+func cloudStreamReader(u *url.URL) (img.OpenStreamFunc, error) {
+	return func() (img.Streamer, error) { return img.OpenStream(u) }, nil
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"image"
+	"path"
+	"rais/src/iiif"
+)
+
+// pyramid describes the static tiles and sizes generated for a single image
+type pyramid struct {
+	width        int
+	height       int
+	tileSize     int
+	scaleFactors []int
+}
+
+// job is a single image to render: the request which produces it and the
+// path, relative to the image's output directory, where it will be written
+type job struct {
+	url  *iiif.URL
+	path string
+}
+
+// newPyramid computes the scale factors needed for a w x h image: powers of
+// two from 1 up to the first which fits the whole image in a single tile
+func newPyramid(w, h, tileSize int) *pyramid {
+	var p = &pyramid{width: w, height: h, tileSize: tileSize}
+	for sf := 1; ; sf <<= 1 {
+		p.scaleFactors = append(p.scaleFactors, sf)
+		if ceilDiv(w, sf) <= tileSize && ceilDiv(h, sf) <= tileSize {
+			break
+		}
+	}
+
+	return p
+}
+
+// sizes returns the full-image size at each scale factor, smallest first
+func (p *pyramid) sizes() []iiif.ImageSize {
+	var sizes []iiif.ImageSize
+	for _, sf := range p.scaleFactors {
+		sizes = append([]iiif.ImageSize{{Width: ceilDiv(p.width, sf), Height: ceilDiv(p.height, sf)}}, sizes...)
+	}
+	return sizes
+}
+
+// info returns the level 0 info.json data for the pyramid
+func (p *pyramid) info(id string, v iiif.Version, f iiif.Format) *iiif.Info {
+	var info = iiif.FeatureSet0().Info()
+	info.ID = id
+	info.Version = v
+	info.Width = p.width
+	info.Height = p.height
+	info.Tiles = []iiif.TileSize{{Width: p.tileSize, ScaleFactors: p.scaleFactors}}
+	info.Sizes = p.sizes()
+	if f != iiif.FmtJPG {
+		info.Profile.Formats = []string{string(f)}
+		info.ProfileV3.ExtraFormats = []string{string(f)}
+	}
+
+	return info
+}
+
+// jobs returns everything which has to be rendered for the pyramid: each
+// tile at each scale factor, plus the sizes list.  Paths use the form a
+// client of the given IIIF version would request.  Sizes which are also
+// tiles (when the whole image fits in one tile) are only listed once.
+func (p *pyramid) jobs(id iiif.ID, v iiif.Version, f iiif.Format) []job {
+	var jobs []job
+	var seen = make(map[string]bool)
+	var add = func(crop image.Rectangle, w, h int) {
+		var u = &iiif.URL{
+			Version: v,
+			ID:      id,
+			Region:  p.region(crop),
+			Size:    iiif.Size{Type: iiif.STExact, W: w, H: h},
+			Quality: iiif.QDefault,
+			Format:  f,
+		}
+
+		var size = iiif.Size{Type: iiif.STScaleToWidth, W: w}
+		if v == iiif.V3 {
+			size = u.Size
+		}
+		var pth = path.Join(u.Region.String(), size.String(), "0", string(iiif.QDefault)+"."+string(f))
+		if !seen[pth] {
+			seen[pth] = true
+			jobs = append(jobs, job{url: u, path: pth})
+		}
+	}
+
+	for _, sf := range p.scaleFactors {
+		var span = p.tileSize * sf
+		for y := 0; y < p.height; y += span {
+			for x := 0; x < p.width; x += span {
+				var crop = image.Rect(x, y, minInt(x+span, p.width), minInt(y+span, p.height))
+				add(crop, ceilDiv(crop.Dx(), sf), ceilDiv(crop.Dy(), sf))
+			}
+		}
+	}
+
+	var full = image.Rect(0, 0, p.width, p.height)
+	for _, s := range p.sizes() {
+		add(full, s.Width, s.Height)
+	}
+
+	return jobs
+}
+
+// region returns the IIIF region for the given crop: "full" if it's the
+// whole image, otherwise the pixel coordinates
+func (p *pyramid) region(crop image.Rectangle) iiif.Region {
+	if crop == image.Rect(0, 0, p.width, p.height) {
+		return iiif.Region{Type: iiif.RTFull}
+	}
+	return iiif.Region{
+		Type: iiif.RTPixel,
+		X:    float64(crop.Min.X),
+		Y:    float64(crop.Min.Y),
+		W:    float64(crop.Dx()),
+		H:    float64(crop.Dy()),
+	}
+}
+
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
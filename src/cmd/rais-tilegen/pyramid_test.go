@@ -0,0 +1,101 @@
+package main
+
+import (
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func jobPaths(jobs []job) []string {
+	var paths []string
+	for _, j := range jobs {
+		paths = append(paths, j.path)
+	}
+	return paths
+}
+
+func TestPyramidScaleFactors(t *testing.T) {
+	var tests = map[string]struct {
+		w, h     int
+		expected []int
+	}{
+		"one tile":       {w: 500, h: 300, expected: []int{1}},
+		"exactly a tile": {w: 512, h: 512, expected: []int{1}},
+		"wide":           {w: 1000, h: 300, expected: []int{1, 2}},
+		"tall":           {w: 300, h: 2049, expected: []int{1, 2, 4, 8}},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var p = newPyramid(tc.w, tc.h, 512)
+			if diff := cmp.Diff(tc.expected, p.scaleFactors); diff != "" {
+				t.Errorf(diff)
+			}
+		})
+	}
+}
+
+func TestPyramidJobsV2(t *testing.T) {
+	var p = newPyramid(1000, 300, 512)
+	var expected = []string{
+		"0,0,512,300/512,/0/default.jpg",
+		"512,0,488,300/488,/0/default.jpg",
+		"full/500,/0/default.jpg",
+		"full/1000,/0/default.jpg",
+	}
+	if diff := cmp.Diff(expected, jobPaths(p.jobs("id", iiif.V2, iiif.FmtJPG))); diff != "" {
+		t.Errorf(diff)
+	}
+
+	var expectedSizes = []iiif.ImageSize{{Width: 500, Height: 150}, {Width: 1000, Height: 300}}
+	if diff := cmp.Diff(expectedSizes, p.sizes()); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+func TestPyramidJobsV3(t *testing.T) {
+	var p = newPyramid(1001, 301, 512)
+	var expected = []string{
+		"0,0,512,301/512,301/0/default.png",
+		"512,0,489,301/489,301/0/default.png",
+		"full/501,151/0/default.png",
+		"full/1001,301/0/default.png",
+	}
+	if diff := cmp.Diff(expected, jobPaths(p.jobs("id", iiif.V3, iiif.FmtPNG))); diff != "" {
+		t.Errorf(diff)
+	}
+}
+
+// TestPyramidAdvertised verifies that every tile and size we generate is one
+// RAIS would consider advertised by the generated info.json
+func TestPyramidAdvertised(t *testing.T) {
+	for _, v := range []iiif.Version{iiif.V2, iiif.V3} {
+		var p = newPyramid(3000, 1999, 256)
+		var info = p.info("http://example.org/iiif/id", v, iiif.FmtJPG)
+		for _, j := range p.jobs("id", v, iiif.FmtJPG) {
+			var u, err = iiif.NewVersionedURL("id/"+j.path, v)
+			if err != nil {
+				t.Fatalf("invalid path %q: %s", j.path, err)
+			}
+			if !info.Advertises(u) {
+				t.Errorf("v%d: %q isn't advertised", v, j.path)
+			}
+		}
+	}
+}
+
+func TestInfoID(t *testing.T) {
+	var g = &generator{outputPath: "/tmp/out", baseURL: "https://example.org/iiif"}
+	var tests = map[string]string{
+		"simple.jp2":             "https://example.org/iiif/simple.jp2",
+		"path/to/file.jp2":       "https://example.org/iiif/path/to/file.jp2",
+		"../../etc/passwd":       "https://example.org/iiif/etc/passwd",
+		"s3://bucket/a file.jp2": "https://example.org/iiif/bucket/a%20file.jp2",
+	}
+	for id, expected := range tests {
+		if actual := g.infoID(id); actual != expected {
+			t.Errorf("infoID(%q): expected %q, got %q", id, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,553 @@
+// Package diskcache is a persistent cache of byte slices stored as files in a
+// directory.  The cache holds no more than a configured number of bytes,
+// evicting the least recently or least frequently used entries to make room
+// for new ones.
+//
+// Entries are written to a temporary file and renamed into place, so a crash
+// never leaves a partially-written entry where a reader can see it.  Each
+// entry also carries a checksum, and any entry which doesn't match its
+// checksum (e.g., after a power failure) is treated as a miss and removed.
+// When a cache is opened, it picks up whatever entries a previous run left in
+// the directory.
+//
+// Entries may be given an expiration time, after which they're treated as
+// misses and removed.
+//
+// Entries belong to a group, such as an image ID, so that everything cached
+// for the group can be removed at once.
+package diskcache
+
+import (
+	"bufio"
+	"container/heap"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy determines which entries are evicted when the cache is full
+type Policy string
+
+// Available eviction policies
+const (
+	// LRU evicts the entry which was used least recently
+	LRU Policy = "lru"
+	// LFU evicts the entry which was used least often, falling back to the
+	// least recently used entry when hit counts are equal
+	LFU Policy = "lfu"
+)
+
+// ParsePolicy returns the Policy for s, ignoring case
+func ParsePolicy(s string) (Policy, error) {
+	var p = Policy(strings.ToLower(s))
+	switch p {
+	case LRU, LFU:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid cache policy %q", s)
+}
+
+// ErrTooLarge is returned when an entry is bigger than the cache's budget
+var ErrTooLarge = errors.New("entry is larger than the cache")
+
+const (
+	// tmpDir holds entries while they're being written
+	tmpDir = "tmp"
+	// indexFile holds the usage data written by Sync
+	indexFile = "index"
+	// magic starts every entry so stray files are never served
+	magic = "RDC2"
+	// checksumOffset and expiresOffset locate the header's fields: a checksum
+	// of everything after it, and the entry's expiration time
+	checksumOffset = len(magic)
+	expiresOffset  = checksumOffset + 4
+	// headerLen is the size of the magic string, checksum, and expiration
+	headerLen = expiresOffset + 8
+)
+
+// entry tracks a single file in the cache
+type entry struct {
+	path  string
+	size  int64
+	hits  uint64
+	seq   uint64
+	index int
+}
+
+// Cache is a size-limited cache on disk.  All methods are safe for concurrent
+// use.
+type Cache struct {
+	dir    string
+	budget int64
+
+	m       sync.Mutex
+	entries map[string]*entry
+	queue   evictionQueue
+	size    int64
+	seq     uint64
+}
+
+// New opens the cache in dir, creating the directory if necessary.  Entries
+// from a previous run are kept, oldest first, until the cache holds no more
+// than budget bytes.
+func New(dir string, budget int64, policy Policy) (*Cache, error) {
+	if budget <= 0 {
+		return nil, fmt.Errorf("invalid cache size %d", budget)
+	}
+	var p, err = ParsePolicy(string(policy))
+	if err != nil {
+		return nil, err
+	}
+
+	var c = &Cache{
+		dir:     dir,
+		budget:  budget,
+		entries: make(map[string]*entry),
+		queue:   evictionQueue{lfu: p == LFU},
+	}
+	err = c.recover()
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cache in %q: %s", dir, err)
+	}
+	return c, nil
+}
+
+// Len returns the number of entries in the cache
+func (c *Cache) Len() int {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.entries)
+}
+
+// Size returns the number of bytes the cache's entries use on disk
+func (c *Cache) Size() int64 {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.size
+}
+
+// hashName returns a filesystem-safe name for s
+func hashName(s string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(s)))
+}
+
+// groupPath returns the path to a group's directory, relative to the cache
+// directory.  Groups are spread across subdirectories so no one directory
+// grows too large.
+func groupPath(group string) string {
+	var g = hashName(group)
+	return filepath.Join(g[:2], g)
+}
+
+// entryPath returns the path to an entry, relative to the cache directory
+func entryPath(group, key string) string {
+	return filepath.Join(groupPath(group), hashName(key))
+}
+
+// Get returns the data stored for the given group and key, along with its
+// expiration time.  The time is zero if the entry never expires.
+func (c *Cache) Get(group, key string) ([]byte, time.Time, bool) {
+	var path = entryPath(group, key)
+
+	c.m.Lock()
+	var e, ok = c.entries[path]
+	if ok {
+		c.use(e)
+	}
+	c.m.Unlock()
+
+	if !ok {
+		return nil, time.Time{}, false
+	}
+
+	var data, expires, err = readEntry(filepath.Join(c.dir, path))
+	if err == nil && !expires.IsZero() && time.Now().After(expires) {
+		err = errExpired
+	}
+	if err != nil {
+		c.m.Lock()
+		if c.entries[path] == e {
+			c.remove(e)
+		}
+		c.m.Unlock()
+		return nil, time.Time{}, false
+	}
+	return data, expires, true
+}
+
+var errExpired = errors.New("cache entry has expired")
+
+// readEntry reads the file at path, returning an error if it isn't a valid
+// entry
+func readEntry(path string) ([]byte, time.Time, error) {
+	var expires time.Time
+	var raw, err = ioutil.ReadFile(path)
+	if err != nil {
+		return nil, expires, err
+	}
+	if len(raw) < headerLen || string(raw[:len(magic)]) != magic {
+		return nil, expires, errors.New("invalid cache entry")
+	}
+	if binary.BigEndian.Uint32(raw[checksumOffset:]) != crc32.ChecksumIEEE(raw[expiresOffset:]) {
+		return nil, expires, errors.New("cache entry checksum mismatch")
+	}
+	var nsec = int64(binary.BigEndian.Uint64(raw[expiresOffset:]))
+	if nsec != 0 {
+		expires = time.Unix(0, nsec)
+	}
+	return raw[headerLen:], expires, nil
+}
+
+// Add stores data for the given group and key, replacing anything already
+// stored there, and evicts other entries as needed to stay within budget.  A
+// zero expiration time means the entry never expires.
+func (c *Cache) Add(group, key string, data []byte, expires time.Time) error {
+	var size = int64(headerLen + len(data))
+	if size > c.budget {
+		return ErrTooLarge
+	}
+
+	var tmp, err = c.writeTemp(data, expires)
+	if err != nil {
+		return err
+	}
+
+	var path = entryPath(group, key)
+	var fullPath = filepath.Join(c.dir, path)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	err = os.MkdirAll(filepath.Dir(fullPath), 0755)
+	if err == nil {
+		err = os.Rename(tmp, fullPath)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// The entry is taken out of the eviction queue while we make room so that a
+	// new entry, which is always the least frequently used, isn't immediately
+	// evicted
+	var e, ok = c.entries[path]
+	if ok {
+		heap.Remove(&c.queue, e.index)
+		c.size -= e.size
+	} else {
+		e = &entry{path: path}
+		c.entries[path] = e
+	}
+	c.seq++
+	e.size, e.seq = size, c.seq
+	e.hits++
+	c.evictFor(size)
+	heap.Push(&c.queue, e)
+	c.size += size
+	return nil
+}
+
+// writeTemp writes an entry to a new file in the temp directory, returning
+// the file's path
+func (c *Cache) writeTemp(data []byte, expires time.Time) (string, error) {
+	var f, err = ioutil.TempFile(filepath.Join(c.dir, tmpDir), "entry-")
+	if err != nil {
+		return "", err
+	}
+
+	var header = make([]byte, headerLen)
+	copy(header, magic)
+	if !expires.IsZero() {
+		binary.BigEndian.PutUint64(header[expiresOffset:], uint64(expires.UnixNano()))
+	}
+	var sum = crc32.ChecksumIEEE(header[expiresOffset:])
+	sum = crc32.Update(sum, crc32.IEEETable, data)
+	binary.BigEndian.PutUint32(header[checksumOffset:], sum)
+	_, err = f.Write(header)
+	if err == nil {
+		_, err = f.Write(data)
+	}
+	var closeErr = f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// RemoveGroup removes all entries in the given group
+func (c *Cache) RemoveGroup(group string) {
+	var dir = groupPath(group)
+
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	var infos, _ = ioutil.ReadDir(filepath.Join(c.dir, dir))
+	for _, info := range infos {
+		var e, ok = c.entries[filepath.Join(dir, info.Name())]
+		if ok {
+			c.remove(e)
+		}
+	}
+	os.Remove(filepath.Join(c.dir, dir))
+}
+
+// Purge removes all entries from the cache
+func (c *Cache) Purge() {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	for _, e := range c.entries {
+		c.remove(e)
+	}
+}
+
+// use records a hit on e.  The caller must hold the lock.
+func (c *Cache) use(e *entry) {
+	c.seq++
+	e.hits++
+	e.seq = c.seq
+	heap.Fix(&c.queue, e.index)
+}
+
+// remove deletes e from the cache and the disk.  The caller must hold the
+// lock.
+func (c *Cache) remove(e *entry) {
+	heap.Remove(&c.queue, e.index)
+	delete(c.entries, e.path)
+	c.size -= e.size
+
+	var fullPath = filepath.Join(c.dir, e.path)
+	os.Remove(fullPath)
+	// Removing the group's directory only succeeds once it's empty
+	os.Remove(filepath.Dir(fullPath))
+}
+
+// evictFor removes entries until the cache has room for another n bytes.  The
+// caller must hold the lock.
+func (c *Cache) evictFor(n int64) {
+	for c.size+n > c.budget && c.queue.Len() > 0 {
+		c.remove(c.queue.entries[0])
+	}
+}
+
+// Sync writes the cache's usage data to disk so that eviction order and hit
+// counts survive a restart.  Entries are never lost if Sync isn't called, but
+// their usage is estimated from their modification times.
+func (c *Cache) Sync() error {
+	c.m.Lock()
+	var entries = make([]*entry, len(c.entries))
+	copy(entries, c.queue.entries)
+	var lines = make([]string, len(entries))
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("%s %d\n", filepath.ToSlash(e.path), e.hits)
+	}
+	c.m.Unlock()
+
+	var tmp, err = ioutil.TempFile(filepath.Join(c.dir, tmpDir), "index-")
+	if err != nil {
+		return err
+	}
+	var w = bufio.NewWriter(tmp)
+	for _, line := range lines {
+		w.WriteString(line)
+	}
+	err = w.Flush()
+	var closeErr = tmp.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), filepath.Join(c.dir, indexFile))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// usage is what the index records about an entry
+type usage struct {
+	order int
+	hits  uint64
+}
+
+// readIndex returns the usage data from the last call to Sync, if any.  A
+// missing or damaged index isn't an error; it just means usage has to be
+// guessed.
+func (c *Cache) readIndex() map[string]usage {
+	var index = make(map[string]usage)
+	var f, err = os.Open(filepath.Join(c.dir, indexFile))
+	if err != nil {
+		return index
+	}
+	defer f.Close()
+
+	var scanner = bufio.NewScanner(f)
+	for scanner.Scan() {
+		var fields = strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		var hits, err = strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		index[filepath.FromSlash(fields[0])] = usage{order: len(index), hits: hits}
+	}
+	return index
+}
+
+// found is an entry discovered on disk when the cache is opened
+type found struct {
+	e       *entry
+	indexed bool
+	order   int
+	modTime time.Time
+}
+
+// recover prepares the cache directory and rebuilds the cache's state from
+// whatever a previous run left there
+func (c *Cache) recover() error {
+	var err = os.MkdirAll(c.dir, 0755)
+	if err != nil {
+		return err
+	}
+
+	// Anything in the temp directory was never completely written
+	var tmp = filepath.Join(c.dir, tmpDir)
+	err = os.RemoveAll(tmp)
+	if err == nil {
+		err = os.Mkdir(tmp, 0755)
+	}
+	if err != nil {
+		return err
+	}
+
+	var index = c.readIndex()
+	var list []found
+	var shards []os.FileInfo
+	shards, err = ioutil.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() || !isHashPrefix(shard.Name()) {
+			continue
+		}
+		var groups, _ = ioutil.ReadDir(filepath.Join(c.dir, shard.Name()))
+		for _, group := range groups {
+			if !group.IsDir() || !isHash(group.Name()) {
+				continue
+			}
+			var dir = filepath.Join(shard.Name(), group.Name())
+			var files, _ = ioutil.ReadDir(filepath.Join(c.dir, dir))
+			for _, file := range files {
+				if !file.Mode().IsRegular() || !isHash(file.Name()) {
+					continue
+				}
+				var path = filepath.Join(dir, file.Name())
+				if file.Size() < int64(headerLen) {
+					os.Remove(filepath.Join(c.dir, path))
+					continue
+				}
+				var u, indexed = index[path]
+				var e = &entry{path: path, size: file.Size(), hits: 1}
+				if indexed {
+					e.hits = u.hits
+				}
+				list = append(list, found{e: e, indexed: indexed, order: u.order, modTime: file.ModTime()})
+			}
+		}
+	}
+
+	// Entries from the index keep their order; anything written after the last
+	// sync is considered newer, in order of modification
+	sort.Slice(list, func(i, j int) bool {
+		var a, b = list[i], list[j]
+		if a.indexed != b.indexed {
+			return a.indexed
+		}
+		if a.indexed {
+			return a.order < b.order
+		}
+		return a.modTime.Before(b.modTime)
+	})
+	for _, f := range list {
+		c.seq++
+		f.e.seq = c.seq
+		f.e.index = len(c.queue.entries)
+		c.entries[f.e.path] = f.e
+		c.queue.entries = append(c.queue.entries, f.e)
+		c.size += f.e.size
+	}
+	heap.Init(&c.queue)
+	c.evictFor(0)
+	return nil
+}
+
+// isHash returns true if s looks like a name returned by hashName
+func isHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	return strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// isHashPrefix returns true if s looks like the shard directory for a group
+func isHashPrefix(s string) bool {
+	return len(s) == 2 && strings.Trim(s, "0123456789abcdef") == ""
+}
+
+// evictionQueue is a heap.Interface which orders entries by which should be
+// evicted first
+type evictionQueue struct {
+	entries []*entry
+	lfu     bool
+}
+
+func (q evictionQueue) Len() int {
+	return len(q.entries)
+}
+
+func (q evictionQueue) Less(i, j int) bool {
+	var a, b = q.entries[i], q.entries[j]
+	if q.lfu && a.hits != b.hits {
+		return a.hits < b.hits
+	}
+	return a.seq < b.seq
+}
+
+func (q evictionQueue) Swap(i, j int) {
+	q.entries[i], q.entries[j] = q.entries[j], q.entries[i]
+	q.entries[i].index = i
+	q.entries[j].index = j
+}
+
+func (q *evictionQueue) Push(x interface{}) {
+	var e = x.(*entry)
+	e.index = len(q.entries)
+	q.entries = append(q.entries, e)
+}
+
+func (q *evictionQueue) Pop() interface{} {
+	var n = len(q.entries)
+	var e = q.entries[n-1]
+	q.entries[n-1] = nil
+	q.entries = q.entries[:n-1]
+	return e
+}
@@ -0,0 +1,163 @@
+package diskcache
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// never is the expiration time for entries which don't expire
+var never time.Time
+
+// entrySize is the size on disk of the 100-byte entries these tests use
+const entrySize = 100 + headerLen
+
+func newTestCache(dir string, entries int, policy Policy, t *testing.T) *Cache {
+	var c, err = New(dir, int64(entries*entrySize), policy)
+	if err != nil {
+		t.Fatalf("Unable to open cache: %s", err)
+	}
+	return c
+}
+
+func tempDir(t *testing.T) string {
+	var dir, err = ioutil.TempDir("", "rais-diskcache-")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func data(b byte) []byte {
+	return bytes.Repeat([]byte{b}, 100)
+}
+
+func has(c *Cache, group, key string) bool {
+	var _, _, ok = c.Get(group, key)
+	return ok
+}
+
+func TestAddGet(t *testing.T) {
+	var c = newTestCache(tempDir(t), 10, LRU, t)
+	var _, _, ok = c.Get("img", "a")
+	assert.False(ok, "nothing is cached yet", t)
+
+	assert.NilError(c.Add("img", "a", data('a'), never), "adding an entry", t)
+	var got []byte
+	got, _, ok = c.Get("img", "a")
+	assert.True(ok, "the entry is cached", t)
+	assert.True(bytes.Equal(data('a'), got), "cached data matches", t)
+	assert.Equal(1, c.Len(), "cache length", t)
+	assert.Equal(int64(entrySize), c.Size(), "cache size", t)
+
+	assert.NilError(c.Add("img", "a", data('b'), never), "replacing an entry", t)
+	got, _, _ = c.Get("img", "a")
+	assert.True(bytes.Equal(data('b'), got), "replaced data is returned", t)
+	assert.Equal(int64(entrySize), c.Size(), "replacing doesn't change the size", t)
+
+	assert.Equal(ErrTooLarge, c.Add("img", "big", make([]byte, 10*entrySize), never), "entry bigger than the cache", t)
+}
+
+func TestExpires(t *testing.T) {
+	var c = newTestCache(tempDir(t), 10, LRU, t)
+	var expires = time.Now().Add(time.Hour).Round(0)
+	c.Add("img", "a", data('a'), expires)
+	c.Add("img", "b", data('b'), time.Now().Add(-time.Second))
+
+	var _, got, ok = c.Get("img", "a")
+	assert.True(ok, "unexpired entry is cached", t)
+	assert.True(expires.Equal(got), "expiration time is returned", t)
+	assert.False(has(c, "img", "b"), "expired entry is a miss", t)
+	assert.Equal(1, c.Len(), "expired entry is removed", t)
+}
+
+func TestEvictLRU(t *testing.T) {
+	var c = newTestCache(tempDir(t), 3, LRU, t)
+	c.Add("img", "a", data('a'), never)
+	c.Add("img", "b", data('b'), never)
+	c.Add("img", "c", data('c'), never)
+	c.Get("img", "a")
+	c.Add("img", "d", data('d'), never)
+
+	assert.Equal(3, c.Len(), "cache stays within budget", t)
+	assert.True(has(c, "img", "a"), "recently used entry is kept", t)
+	assert.False(has(c, "img", "b"), "least recently used entry is evicted", t)
+	assert.True(has(c, "img", "d"), "new entry is cached", t)
+}
+
+func TestEvictLFU(t *testing.T) {
+	var c = newTestCache(tempDir(t), 3, LFU, t)
+	c.Add("img", "a", data('a'), never)
+	c.Add("img", "b", data('b'), never)
+	c.Add("img", "c", data('c'), never)
+	c.Get("img", "a")
+	c.Get("img", "a")
+	c.Get("img", "b")
+	c.Get("img", "c")
+	c.Add("img", "d", data('d'), never)
+
+	assert.True(has(c, "img", "a"), "most used entry is kept", t)
+	assert.False(has(c, "img", "b"), "least used, least recent entry is evicted", t)
+	assert.True(has(c, "img", "c"), "equally used but more recent entry is kept", t)
+}
+
+func TestRemoveGroupAndPurge(t *testing.T) {
+	var c = newTestCache(tempDir(t), 10, LRU, t)
+	c.Add("img1", "a", data('a'), never)
+	c.Add("img1", "b", data('b'), never)
+	c.Add("img2", "a", data('a'), never)
+
+	c.RemoveGroup("img1")
+	assert.False(has(c, "img1", "a"), "removed group's entries are gone", t)
+	assert.False(has(c, "img1", "b"), "removed group's entries are gone", t)
+	assert.True(has(c, "img2", "a"), "other groups are kept", t)
+	assert.Equal(int64(entrySize), c.Size(), "size after removing a group", t)
+
+	c.Purge()
+	assert.Equal(0, c.Len(), "purged cache is empty", t)
+	assert.Equal(int64(0), c.Size(), "purged cache size", t)
+}
+
+func TestRecover(t *testing.T) {
+	var dir = tempDir(t)
+	var c = newTestCache(dir, 10, LFU, t)
+	c.Add("img", "a", data('a'), never)
+	c.Add("img", "b", data('b'), never)
+	c.Add("img", "c", data('c'), never)
+	c.Get("img", "a")
+	c.Get("img", "a")
+	assert.NilError(c.Sync(), "syncing the index", t)
+
+	// Simulate a crash mid-write and a damaged entry
+	ioutil.WriteFile(filepath.Join(dir, tmpDir, "entry-123"), data('x'), 0644)
+	var damaged = filepath.Join(dir, entryPath("img", "c"))
+	var raw, _ = ioutil.ReadFile(damaged)
+	raw[len(raw)-1]++
+	ioutil.WriteFile(damaged, raw, 0644)
+
+	c = newTestCache(dir, 2, LFU, t)
+	var tmps, _ = ioutil.ReadDir(filepath.Join(dir, tmpDir))
+	assert.Equal(0, len(tmps), "partial writes are removed", t)
+	assert.Equal(2, c.Len(), "reopened cache is trimmed to its new budget", t)
+	assert.True(has(c, "img", "a"), "hit counts survive a restart", t)
+	assert.False(has(c, "img", "b"), "the least used entry is evicted on open", t)
+	assert.False(has(c, "img", "c"), "damaged entries are misses", t)
+	assert.Equal(1, c.Len(), "damaged entries are removed", t)
+
+	c = newTestCache(dir, 2, LRU, t)
+	assert.True(has(c, "img", "a"), "entries survive without a fresh sync", t)
+}
+
+func TestParsePolicy(t *testing.T) {
+	var p, err = ParsePolicy("LFU")
+	assert.NilError(err, "parsing LFU", t)
+	assert.Equal(LFU, p, "parsed policy", t)
+	_, err = ParsePolicy("fifo")
+	assert.True(err != nil, "unknown policies are rejected", t)
+}
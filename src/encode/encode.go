@@ -0,0 +1,196 @@
+// Package encode writes images in any of the output formats RAIS supports,
+// for use by the server as well as tools which render images ahead of time
+package encode
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
+	"mime"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/openjpeg"
+	"rais/src/pdf"
+	"rais/src/quantize"
+	"rais/src/transform"
+	"rais/src/webp"
+
+	"golang.org/x/image/tiff"
+)
+
+// ErrInvalidFormat is the error returned when encoding fails due to a file
+// format RAIS doesn't support
+var ErrInvalidFormat = errors.New("Unable to encode: unsupported format")
+
+// EncodeFunc writes m to w in a custom format.  The resolution is provided for
+// formats which can store physical dimensions.
+type EncodeFunc func(w io.Writer, m image.Image, r OutputResolution) error
+
+// encoders holds the custom encoders added via RegisterEncoder
+var encoders = make(map[iiif.Format]EncodeFunc)
+
+// RegisterEncoder adds a custom encoder for the given format, which must not
+// be one of the built-in formats or already have an encoder.  The format is
+// registered with the iiif package so URLs requesting it are valid, and
+// mimeType is added to the mime database for Content-Type headers.
+//
+// Like img.RegisterDecodeHandler, this is meant to be called at startup, such
+// as from a plugin's Initialize function, and isn't safe to call while images
+// are being encoded.
+func RegisterEncoder(f iiif.Format, mimeType string, supportsAlpha bool, fn EncodeFunc) error {
+	if f == iiif.FmtUnknown {
+		return errors.New("cannot register an encoder for an empty format")
+	}
+	if _, ok := encoders[f]; ok || f.Valid() {
+		return fmt.Errorf("format %q already has an encoder", f)
+	}
+
+	var err = mime.AddExtensionType("."+string(f), mimeType)
+	if err != nil {
+		return fmt.Errorf("invalid mime type %q for format %q: %s", mimeType, f, err)
+	}
+
+	iiif.RegisterFormat(f, supportsAlpha)
+	encoders[f] = fn
+	return nil
+}
+
+// Options holds the settings for all formats' encoders
+type Options struct {
+	JPGQuality    int
+	GIFDither     bool
+	JP2Lossless   bool
+	JP2Rate       float64
+	JP2Resolution float64
+	PDFDPI        float64
+	WebPQuality   int
+	WebPLossless  bool
+}
+
+// DefaultOptions returns the options RAIS uses when nothing is configured
+func DefaultOptions() *Options {
+	return &Options{
+		JPGQuality:  75,
+		GIFDither:   true,
+		JP2Lossless: true,
+		JP2Rate:     openjpeg.DefaultRate,
+		PDFDPI:      pdf.DefaultDPI,
+		WebPQuality: webp.DefaultQuality,
+	}
+}
+
+// Fingerprint returns a short hash of the options, which changes whenever
+// any of them do, so images encoded with other settings can be kept out of
+// caches
+func (o *Options) Fingerprint() string {
+	var sum = sha256.Sum256([]byte(fmt.Sprintf("%+v", *o)))
+	return fmt.Sprintf("%x", sum[:8])
+}
+
+// OutputResolution describes what an encoder needs to know about an image's
+// physical size: the source image's resolution in pixels per inch (zero if
+// the source doesn't specify one), and how much the request scaled the source
+type OutputResolution struct {
+	SourcePPI float64
+	Scale     float64
+}
+
+// PPI returns the resolution of the output image, using fallback as the
+// source resolution if the source doesn't have one
+func (r OutputResolution) PPI(fallback float64) float64 {
+	var ppi = r.SourcePPI
+	if ppi <= 0 {
+		ppi = fallback
+	}
+	if r.Scale <= 0 {
+		return ppi
+	}
+	return ppi * r.Scale
+}
+
+// GetOutputResolution figures out the OutputResolution of m, which was
+// generated from res for the given request.  The scale is computed from
+// areas so that distorted sizes and rotations don't need special handling.
+func GetOutputResolution(res *img.Resource, u *iiif.URL, m image.Image) OutputResolution {
+	var r = OutputResolution{Scale: 1}
+	var d, err = res.Decoder()
+	if err != nil {
+		return r
+	}
+
+	if rd, ok := d.(img.ResolutionDecoder); ok {
+		var x, y = rd.GetResolution()
+		r.SourcePPI = math.Sqrt(x * y)
+	}
+
+	var crop = u.Region.GetCrop(d.GetWidth(), d.GetHeight())
+	var cw, ch = transform.RotatedBounds(crop.Dx(), crop.Dy(), u.Rotation.Degrees)
+	var b = m.Bounds()
+	if cw > 0 && ch > 0 {
+		r.Scale = math.Sqrt(float64(b.Dx()) * float64(b.Dy()) / (float64(cw) * float64(ch)))
+	}
+
+	return r
+}
+
+// Image writes m to w in the given format, using a registered custom encoder
+// if the format isn't built in.  The resolution is only used by formats which
+// can store physical dimensions.
+func Image(w io.Writer, m image.Image, format iiif.Format, r OutputResolution, o *Options) error {
+	switch format {
+	case iiif.FmtJPG:
+		return jpeg.Encode(w, m, &jpeg.Options{Quality: o.JPGQuality})
+	case iiif.FmtPNG:
+		return png.Encode(w, m)
+	case iiif.FmtGIF:
+		return encodeGIF(w, m, o.GIFDither)
+	case iiif.FmtTIF:
+		return tiff.Encode(w, m, &tiff.Options{Compression: tiff.Deflate, Predictor: true})
+	case iiif.FmtJP2:
+		return openjpeg.Encode(w, m, &openjpeg.EncodeOptions{
+			Lossless:   o.JP2Lossless,
+			Rate:       float32(o.JP2Rate),
+			Resolution: r.PPI(o.JP2Resolution),
+		})
+	case iiif.FmtPDF:
+		return pdf.Encode(w, m, &pdf.Options{
+			DPI:        r.PPI(o.PDFDPI),
+			JPGQuality: o.JPGQuality,
+		})
+	case iiif.FmtWEBP:
+		return webp.Encode(w, m, &webp.Options{
+			Quality:  float32(o.WebPQuality),
+			Lossless: o.WebPLossless,
+		})
+	}
+
+	var fn, ok = encoders[format]
+	if ok {
+		return fn(w, m, r)
+	}
+	return ErrInvalidFormat
+}
+
+// encodeGIF writes m using an exact palette for grayscale and bitonal images,
+// and a median cut palette for everything else
+func encodeGIF(w io.Writer, m image.Image, dither bool) error {
+	var drawer = quantize.Drawer{Dither: dither}
+
+	if gray, ok := m.(*image.Gray); ok {
+		var p = quantize.GrayPalette(256)
+		if quantize.IsBitonal(gray) {
+			p = quantize.Bitonal
+		}
+		var pm = image.NewPaletted(gray.Bounds(), p)
+		drawer.Draw(pm, pm.Rect, gray, gray.Rect.Min)
+		return gif.Encode(w, pm, nil)
+	}
+
+	return gif.Encode(w, m, &gif.Options{NumColors: 256, Quantizer: quantize.MedianCut{}, Drawer: drawer})
+}
@@ -0,0 +1,93 @@
+package encode
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"io"
+	"mime"
+	"rais/src/iiif"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestOutputResolutionPPI(t *testing.T) {
+	var r = OutputResolution{SourcePPI: 400, Scale: 0.5}
+	assert.Equal(200.0, r.PPI(300), "source resolution is scaled", t)
+
+	r = OutputResolution{Scale: 0.5}
+	assert.Equal(150.0, r.PPI(300), "fallback is used and scaled when source resolution is unknown", t)
+
+	r = OutputResolution{}
+	assert.Equal(300.0, r.PPI(300), "zero scale is treated as unscaled", t)
+	assert.Equal(0.0, r.PPI(0), "no source resolution or fallback", t)
+}
+
+func decodeGIF(m image.Image, t *testing.T) *image.Paletted {
+	var buf bytes.Buffer
+	var err = encodeGIF(&buf, m, true)
+	assert.NilError(err, "encoding the GIF", t)
+	var decoded image.Image
+	decoded, err = gif.Decode(&buf)
+	assert.NilError(err, "decoding the GIF", t)
+	return decoded.(*image.Paletted)
+}
+
+func TestOptionsFingerprint(t *testing.T) {
+	var a, b = DefaultOptions(), DefaultOptions()
+	assert.Equal(a.Fingerprint(), b.Fingerprint(), "same options, same fingerprint", t)
+	b.JPGQuality++
+	assert.True(a.Fingerprint() != b.Fingerprint(), "changed options change the fingerprint", t)
+}
+
+func TestEncodeGIFGray(t *testing.T) {
+	var m = image.NewGray(image.Rect(0, 0, 16, 16))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i)
+	}
+	var pm = decodeGIF(m, t)
+	assert.Equal(256, len(pm.Palette), "grayscale gets a full gray palette", t)
+	assert.Equal(color.Gray{200}, color.GrayModel.Convert(pm.At(8, 12)), "gray values are exact", t)
+}
+
+func TestEncodeGIFBitonal(t *testing.T) {
+	var m = image.NewGray(image.Rect(0, 0, 16, 16))
+	m.Pix[7] = 255
+	var pm = decodeGIF(m, t)
+	assert.Equal(2, len(pm.Palette), "bitonal images get a two-color palette", t)
+	assert.Equal(uint8(1), pm.ColorIndexAt(7, 0), "white pixel", t)
+}
+
+func TestEncodeGIFColor(t *testing.T) {
+	var m = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	m.SetRGBA(3, 3, color.RGBA{200, 10, 10, 255})
+	var pm = decodeGIF(m, t)
+	assert.True(len(pm.Palette) <= 256, "palette is limited to 256 colors", t)
+	var r, g, b, _ = pm.At(3, 3).RGBA()
+	assert.Equal([3]uint32{200, 10, 10}, [3]uint32{r >> 8, g >> 8, b >> 8}, "distinct colors are preserved", t)
+}
+
+func TestRegisterEncoder(t *testing.T) {
+	var f = iiif.Format("xyz")
+	var fn = func(w io.Writer, m image.Image, r OutputResolution) error {
+		var _, err = w.Write([]byte("xyz image"))
+		return err
+	}
+
+	var buf bytes.Buffer
+	var m = image.NewGray(image.Rect(0, 0, 4, 4))
+	assert.Equal(ErrInvalidFormat, Image(&buf, m, f, OutputResolution{}, DefaultOptions()), "unregistered format", t)
+
+	assert.NilError(RegisterEncoder(f, "image/x-xyz", true, fn), "registering xyz", t)
+	assert.True(f.Valid(), "xyz is a valid format once registered", t)
+	assert.True(f.SupportsAlpha(), "xyz alpha support", t)
+	assert.Equal("image/x-xyz", mime.TypeByExtension(".xyz"), "xyz mime type", t)
+	assert.NilError(Image(&buf, m, f, OutputResolution{}, DefaultOptions()), "encoding xyz", t)
+	assert.Equal("xyz image", buf.String(), "custom encoder output", t)
+
+	assert.True(RegisterEncoder(f, "image/x-xyz", true, fn) != nil, "registering xyz twice fails", t)
+	assert.True(RegisterEncoder(iiif.FmtJPG, "image/jpeg", false, fn) != nil, "built-in formats can't be replaced", t)
+	assert.True(RegisterEncoder("", "image/x-none", false, fn) != nil, "the empty format can't be registered", t)
+}
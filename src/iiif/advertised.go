@@ -0,0 +1,86 @@
+package iiif
+
+import "image"
+
+// Advertises returns true if u requests exactly one of the tiles or sizes
+// described by the info: a tile from the grid defined by a TileSize and one
+// of its scale factors, or the full image at one of the listed sizes.  Only
+// the region and size are considered; rotation, quality, and format are left
+// to the FeatureSet.
+//
+// Tiles at the right and bottom edges of the image must be clipped to the
+// image, and tile sizes are the region divided by the scale factor, rounded
+// up, as described in the IIIF Image API.
+func (i *Info) Advertises(u *URL) bool {
+	if u.Size.Upscale {
+		return false
+	}
+
+	var crop = u.Region.GetCrop(i.Width, i.Height)
+	for _, t := range i.Tiles {
+		for _, sf := range t.ScaleFactors {
+			if i.isTile(crop, t, sf) && u.Size.resizesTo(crop, ceilDiv(crop.Dx(), sf), ceilDiv(crop.Dy(), sf)) {
+				return true
+			}
+		}
+	}
+
+	if crop != image.Rect(0, 0, i.Width, i.Height) {
+		return false
+	}
+	for _, s := range i.Sizes {
+		if u.Size.resizesTo(crop, s.Width, s.Height) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isTile returns true if crop is a tile in the grid defined by t at the
+// given scale factor
+func (i *Info) isTile(crop image.Rectangle, t TileSize, sf int) bool {
+	var tw, th = t.Width, t.Height
+	if th == 0 {
+		th = tw
+	}
+	tw, th = tw*sf, th*sf
+	if tw <= 0 || th <= 0 {
+		return false
+	}
+
+	var x, y = crop.Min.X, crop.Min.Y
+	if x < 0 || y < 0 || x >= i.Width || y >= i.Height || x%tw != 0 || y%th != 0 {
+		return false
+	}
+
+	return crop.Dx() == minInt(tw, i.Width-x) && crop.Dy() == minInt(th, i.Height-y)
+}
+
+// resizesTo returns true if s is a simple size request which scales region to
+// exactly w x h.  Requests which only specify one dimension need only match
+// that dimension, since the other is computed by the server.
+func (s Size) resizesTo(region image.Rectangle, w, h int) bool {
+	var scaled = s.GetResize(region)
+	switch s.Type {
+	case STScaleToWidth:
+		return scaled.Dx() == w
+	case STScaleToHeight:
+		return scaled.Dy() == h
+	case STFull, STMax, STExact:
+		return scaled.Dx() == w && scaled.Dy() == h
+	}
+
+	return false
+}
+
+func ceilDiv(n, d int) int {
+	return (n + d - 1) / d
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
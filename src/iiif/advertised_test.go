@@ -0,0 +1,58 @@
+package iiif
+
+import "testing"
+
+func TestAdvertises(t *testing.T) {
+	var i = NewInfo()
+	i.Width, i.Height = 1000, 300
+	i.Tiles = []TileSize{
+		{Width: 256, ScaleFactors: []int{1, 2, 4}},
+		{Width: 200, Height: 100, ScaleFactors: []int{3}},
+	}
+	i.Sizes = []ImageSize{{Width: 250, Height: 75}, {Width: 500, Height: 150}}
+
+	var tests = map[string]struct {
+		path     string
+		v        Version
+		expected bool
+	}{
+		"first tile":              {path: "id/0,0,256,256/256,/0/default.jpg", expected: true},
+		"first tile w,h":          {path: "id/0,0,256,256/256,256/0/default.jpg", expected: true},
+		"first tile v3":           {path: "id/0,0,256,256/256,256/0/default.jpg", v: V3, expected: true},
+		"first tile v3 max":       {path: "id/0,0,256,256/max/0/default.jpg", v: V3, expected: true},
+		"edge tile":               {path: "id/768,256,232,44/232,/0/default.jpg", expected: true},
+		"scaled tile":             {path: "id/512,0,488,300/244,/0/default.jpg", expected: true},
+		"scaled odd tile":         {path: "id/0,0,1000,300/250,75/0/default.jpg", expected: true},
+		"rounded up tile":         {path: "id/600,0,400,300/134,/0/default.jpg", expected: true},
+		"rectangular tile":        {path: "id/0,0,600,300/200,100/0/default.jpg", v: V3, expected: true},
+		"listed size":             {path: "id/full/500,/0/default.jpg", expected: true},
+		"listed size by height":   {path: "id/full/,150/0/default.jpg", expected: true},
+		"listed size v3":          {path: "id/full/500,150/0/default.jpg", v: V3, expected: true},
+		"misaligned tile":         {path: "id/10,0,256,256/256,/0/default.jpg", expected: false},
+		"unclipped edge tile":     {path: "id/768,256,256,256/256,/0/default.jpg", expected: false},
+		"wrong tile size":         {path: "id/0,0,256,256/128,/0/default.jpg", expected: false},
+		"unlisted scale factor":   {path: "id/0,0,2048,300/256,/0/default.jpg", expected: false},
+		"unlisted size":           {path: "id/full/400,/0/default.jpg", expected: false},
+		"full size isn't listed":  {path: "id/full/full/0/default.jpg", expected: false},
+		"listed size, bad region": {path: "id/0,0,999,300/500,/0/default.jpg", expected: false},
+		"percent size":            {path: "id/full/pct:50/0/default.jpg", expected: false},
+		"best fit size":           {path: "id/full/!500,150/0/default.jpg", expected: false},
+		"upscaled tile":           {path: "id/0,0,256,256/^256,256/0/default.jpg", v: V3, expected: false},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var v = tc.v
+			if v == 0 {
+				v = V2
+			}
+			var u, err = NewVersionedURL(tc.path, v)
+			if err != nil {
+				t.Fatalf("invalid URL %q: %s", tc.path, err)
+			}
+			if got := i.Advertises(u); got != tc.expected {
+				t.Errorf("expected Advertises(%q) to be %t", tc.path, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package iiif
+
+import "image"
+
+// Canonical returns a copy of u with each parameter in the canonical form
+// defined by u's API version, for an image of the given dimensions.  scaled
+// must be the size the server will actually resize the requested region to,
+// since that depends on server limits when the size is "max".
+//
+// In both versions, the region is "full" or "x,y,w,h", the rotation has no
+// trailing zeroes, and the format is unchanged.  For 2.x, the size is "full",
+// "w,", or "w,h".  For 3.0, the size is "max" or "w,h", prefixed with "^" when
+// the region is upscaled.
+func (u *URL) Canonical(width, height int, scaled image.Rectangle) *URL {
+	var c = *u
+	c.Path = ""
+
+	var crop = u.Region.GetCrop(width, height)
+	c.Region = Region{Type: RTFull}
+	if crop != image.Rect(0, 0, width, height) {
+		c.Region = Region{
+			Type: RTPixel,
+			X:    float64(crop.Min.X),
+			Y:    float64(crop.Min.Y),
+			W:    float64(crop.Dx()),
+			H:    float64(crop.Dy()),
+		}
+	}
+
+	var sw, sh = scaled.Dx(), scaled.Dy()
+	var unscaled = sw == crop.Dx() && sh == crop.Dy()
+	var upscaled = sw > crop.Dx() || sh > crop.Dy()
+	var exact = Size{Type: STExact, W: sw, H: sh}
+
+	if u.Version == V3 {
+		switch {
+		case u.Size.Type == STMax:
+			c.Size = Size{Type: STMax, Upscale: upscaled}
+		case unscaled:
+			c.Size = Size{Type: STMax}
+		default:
+			c.Size = exact
+			c.Size.Upscale = upscaled
+		}
+		return &c
+	}
+
+	var byWidth = Size{Type: STScaleToWidth, W: sw}
+	switch {
+	case unscaled:
+		c.Size = Size{Type: STFull}
+	case byWidth.GetResize(crop).Dy() == sh:
+		c.Size = byWidth
+	default:
+		c.Size = exact
+	}
+
+	if c.Quality == QNative {
+		c.Quality = QDefault
+	}
+
+	return &c
+}
@@ -0,0 +1,51 @@
+package iiif
+
+import (
+	"image"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func canonicalize(path string, v Version, w, h int, scaled image.Rectangle, t *testing.T) string {
+	var u, err = NewVersionedURL(path, v)
+	assert.NilError(err, "NewVersionedURL("+path+")", t)
+	return u.Canonical(w, h, scaled).ParamString()
+}
+
+func TestCanonicalV2(t *testing.T) {
+	var full = image.Rect(0, 0, 800, 400)
+	assert.Equal("full/full/0/default.jpg", canonicalize("id/0,0,800,400/800,/0/native.jpg", V2, 800, 400, full, t),
+		"Full region and size are canonicalized", t)
+	assert.Equal("full/400,/90/default.jpg", canonicalize("id/full/pct:50/90.0/default.jpg", V2, 800, 400, image.Rect(0, 0, 400, 200), t),
+		"Aspect-preserving size is width-only", t)
+	assert.Equal("200,0,400,400/100,50/!90/gray.png", canonicalize("id/square/100,50/!90/gray.png", V2, 800, 400, image.Rect(0, 0, 100, 50), t),
+		"Distorted size is w,h and centered square region is pixels", t)
+}
+
+func TestCanonicalV3(t *testing.T) {
+	var full = image.Rect(0, 0, 800, 400)
+	assert.Equal("full/max/0/default.jpg", canonicalize("id/0,0,800,400/800,400/0/default.jpg", V3, 800, 400, full, t),
+		"Unscaled size is max", t)
+	assert.Equal("full/max/0/default.jpg", canonicalize("id/full/max/0/default.jpg", V3, 800, 400, image.Rect(0, 0, 400, 200), t),
+		"max stays max even when limits shrink the image", t)
+	assert.Equal("full/400,200/0/default.jpg", canonicalize("id/full/400,/0/default.jpg", V3, 800, 400, image.Rect(0, 0, 400, 200), t),
+		"Scaled size is w,h", t)
+	assert.Equal("full/^1600,800/22.5/default.jpg", canonicalize("id/full/^pct:200/22.50/default.jpg", V3, 800, 400, image.Rect(0, 0, 1600, 800), t),
+		"Upscaled size is ^w,h", t)
+	assert.Equal("full/^max/0/default.jpg", canonicalize("id/full/^max/0/default.jpg", V3, 800, 400, image.Rect(0, 0, 1600, 800), t),
+		"Upscaled max is ^max", t)
+}
+
+func TestRequestedParams(t *testing.T) {
+	var u, _ = NewURL(simplePath)
+	assert.Equal("full/full/30/default.jpg", u.RequestedParams(), "RequestedParams", t)
+	assert.Equal(simplePath, u.String(), "String round-trips the escaped ID", t)
+}
+
+func TestProfileURI(t *testing.T) {
+	var i = AllFeatures().Info()
+	assert.Equal("http://iiif.io/api/image/2/level2.json", i.ProfileURI(), "v2 profile", t)
+	i.Version = V3
+	assert.Equal("http://iiif.io/api/image/3/level2.json", i.ProfileURI(), "v3 profile", t)
+}
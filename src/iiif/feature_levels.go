@@ -52,8 +52,56 @@ func FeatureSet2() *FeatureSet {
 	}
 }
 
+// V3FeatureSet0 returns a copy of the feature set required for a
+// level-0-compliant IIIF 3.0 server
+func V3FeatureSet0() *FeatureSet {
+	return &FeatureSet{
+		Default: true,
+		Jpg:     true,
+	}
+}
+
+// V3FeatureSet1 returns a copy of the feature set required for a
+// level-1-compliant IIIF 3.0 server
+func V3FeatureSet1() *FeatureSet {
+	return &FeatureSet{
+		RegionByPx:      true,
+		RegionSquare:    true,
+		SizeByW:         true,
+		SizeByH:         true,
+		SizeByForcedWh:  true,
+		Default:         true,
+		Jpg:             true,
+		BaseURIRedirect: true,
+		Cors:            true,
+		JsonldMediaType: true,
+	}
+}
+
+// V3FeatureSet2 returns a copy of the feature set required for a
+// level-2-compliant IIIF 3.0 server
+func V3FeatureSet2() *FeatureSet {
+	return &FeatureSet{
+		RegionByPx:       true,
+		RegionByPct:      true,
+		RegionSquare:     true,
+		SizeByW:          true,
+		SizeByH:          true,
+		SizeByPct:        true,
+		SizeByForcedWh:   true,
+		SizeByConfinedWh: true,
+		RotationBy90s:    true,
+		Default:          true,
+		Jpg:              true,
+		Png:              true,
+		BaseURIRedirect:  true,
+		Cors:             true,
+		JsonldMediaType:  true,
+	}
+}
+
 // AllFeatures returns the complete list of everything supported by RAIS at
-// this time
+// this time, including any formats registered via RegisterFormat
 func AllFeatures() *FeatureSet {
 	return &FeatureSet{
 		RegionByPx:   true,
@@ -70,21 +118,29 @@ func AllFeatures() *FeatureSet {
 		SizeByConfinedWh:  true,
 		SizeByDistortedWh: true,
 
-		RotationBy90s: true,
-		Mirroring:     true,
+		RotationBy90s:     true,
+		RotationArbitrary: true,
+		Mirroring:         true,
 
 		Default: true,
 		Color:   true,
 		Gray:    true,
 		Bitonal: true,
 
-		Jpg: true,
-		Png: true,
-		Gif: false,
-		Tif: true,
+		Jpg:  true,
+		Png:  true,
+		Gif:  true,
+		Tif:  true,
+		Jp2:  true,
+		Pdf:  true,
+		Webp: true,
 
-		BaseURIRedirect: true,
-		Cors:            true,
-		JsonldMediaType: true,
+		ExtraFormats: RegisteredFormats(),
+
+		BaseURIRedirect:     true,
+		Cors:                true,
+		JsonldMediaType:     true,
+		ProfileLinkHeader:   true,
+		CanonicalLinkHeader: true,
 	}
 }
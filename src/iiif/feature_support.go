@@ -21,6 +21,12 @@ func (fs *FeatureSet) Supported(u *URL) bool {
 		fs.SupportsFormat(u.Format)
 }
 
+// IsLevel0 returns true if the feature set doesn't include everything
+// required for level 1 compliance
+func (fs *FeatureSet) IsLevel0() bool {
+	return !fs.includes(FeatureSet1())
+}
+
 // SupportsRegion just verifies a given region type is supported
 func (fs *FeatureSet) SupportsRegion(r Region) bool {
 	switch r.Type {
@@ -35,8 +41,14 @@ func (fs *FeatureSet) SupportsRegion(r Region) bool {
 	}
 }
 
-// SupportsSize just verifies a given size type is supported
+// SupportsSize just verifies a given size type is supported.  Explicit
+// upscale requests require SizeAboveFull, but 2.x requests can upscale without
+// saying so; callers must check those against the image's dimensions.
 func (fs *FeatureSet) SupportsSize(s Size) bool {
+	if s.Upscale && !fs.SizeAboveFull {
+		return false
+	}
+
 	switch s.Type {
 	case STScaleToWidth:
 		return fs.SizeByW
@@ -103,7 +115,15 @@ func (fs *FeatureSet) SupportsFormat(f Format) bool {
 		return fs.Pdf
 	case FmtWEBP:
 		return fs.Webp
-	default:
+	}
+
+	if _, ok := registered[f]; !ok {
 		return false
 	}
+	for _, extra := range fs.ExtraFormats {
+		if extra == f {
+			return true
+		}
+	}
+	return false
 }
@@ -12,6 +12,14 @@ type TileSize struct {
 	ScaleFactors []int `json:"scaleFactors"`
 }
 
+// ImageSize represents a full-image size which a server can deliver
+// efficiently, reported in an info response's "sizes" list.  This data is
+// serialized in an info request and therefore must have JSON tags.
+type ImageSize struct {
+	Width  int `json:"width"`
+	Height int `json:"height"`
+}
+
 // FeatureSet represents possible IIIF 2.1 features.  The boolean fields are
 // the same as the string to report features, except that the first character
 // should be lowercased.
@@ -64,6 +72,11 @@ type FeatureSet struct {
 	ProfileLinkHeader   bool
 	CanonicalLinkHeader bool
 
+	// ExtraFormats lists formats beyond the built-in ones, such as those added
+	// by encoder plugins.  A format is only reported or supported if it's also
+	// been registered via RegisterFormat.
+	ExtraFormats []Format
+
 	// Non-boolean feature support
 	TileSizes []TileSize
 }
@@ -73,7 +86,7 @@ type FeatureSet struct {
 // they can be used as-is within "formats", "qualities", and/or "supports"
 // arrays.
 func (fs *FeatureSet) toMap() FeaturesMap {
-	return FeaturesMap{
+	var m = FeaturesMap{
 		"regionByPx":          fs.RegionByPx,
 		"regionByPct":         fs.RegionByPct,
 		"regionSquare":        fs.RegionSquare,
@@ -106,21 +119,67 @@ func (fs *FeatureSet) toMap() FeaturesMap {
 		"profileLinkHeader":   fs.ProfileLinkHeader,
 		"canonicalLinkHeader": fs.CanonicalLinkHeader,
 	}
+	fs.addExtraFormats(m)
+	return m
 }
 
-// FeatureCompare returns which features are in common between two FeatureSets,
-// which are exclusive to a, and which are exclusive to b.  The returned maps
-// will ONLY contain keys with a value of true, as opposed to the full list of
-// features and true/false.  This helps to quickly determine equality, subset
-// status, and superset status.
-func FeatureCompare(a, b *FeatureSet) (union, onlyA, onlyB FeaturesMap) {
+// toMapV3 converts a FeatureSet's boolean support values into a map using the
+// IIIF 3.0 feature names.  Several 2.x features were renamed or merged in 3.0,
+// so a single 3.0 feature may be enabled by more than one FeatureSet field.
+// Formats and qualities aren't "features" in 3.0, but we keep them here so
+// level comparisons work the same way they do for 2.x.
+func (fs *FeatureSet) toMapV3() FeaturesMap {
+	var m = FeaturesMap{
+		"regionByPx":          fs.RegionByPx,
+		"regionByPct":         fs.RegionByPct,
+		"regionSquare":        fs.RegionSquare,
+		"sizeByW":             fs.SizeByW,
+		"sizeByH":             fs.SizeByH,
+		"sizeByPct":           fs.SizeByPct,
+		"sizeByWh":            fs.SizeByForcedWh || fs.SizeByDistortedWh,
+		"sizeByConfinedWh":    fs.SizeByConfinedWh || fs.SizeByWh,
+		"sizeUpscaling":       fs.SizeAboveFull,
+		"rotationBy90s":       fs.RotationBy90s,
+		"rotationArbitrary":   fs.RotationArbitrary,
+		"mirroring":           fs.Mirroring,
+		"default":             fs.Default,
+		"color":               fs.Color,
+		"gray":                fs.Gray,
+		"bitonal":             fs.Bitonal,
+		"jpg":                 fs.Jpg,
+		"png":                 fs.Png,
+		"tif":                 fs.Tif,
+		"gif":                 fs.Gif,
+		"jp2":                 fs.Jp2,
+		"pdf":                 fs.Pdf,
+		"webp":                fs.Webp,
+		"baseUriRedirect":     fs.BaseURIRedirect,
+		"cors":                fs.Cors,
+		"jsonldMediaType":     fs.JsonldMediaType,
+		"profileLinkHeader":   fs.ProfileLinkHeader,
+		"canonicalLinkHeader": fs.CanonicalLinkHeader,
+	}
+	fs.addExtraFormats(m)
+	return m
+}
+
+// addExtraFormats flags each of the feature set's registered extra formats as
+// supported in m
+func (fs *FeatureSet) addExtraFormats(m FeaturesMap) {
+	for _, f := range fs.ExtraFormats {
+		if _, ok := registered[f]; ok {
+			m[string(f)] = true
+		}
+	}
+}
+
+// compareMaps returns which keys are true in both maps, only in a, and only
+// in b
+func compareMaps(mapA, mapB FeaturesMap) (union, onlyA, onlyB FeaturesMap) {
 	union = make(FeaturesMap)
 	onlyA = make(FeaturesMap)
 	onlyB = make(FeaturesMap)
 
-	mapA := a.toMap()
-	mapB := b.toMap()
-
 	for feature, supportedA := range mapA {
 		supportedB := mapB[feature]
 		if supportedA && supportedB {
@@ -141,8 +200,30 @@ func FeatureCompare(a, b *FeatureSet) (union, onlyA, onlyB FeaturesMap) {
 	return
 }
 
+// FeatureCompare returns which features are in common between two FeatureSets,
+// which are exclusive to a, and which are exclusive to b.  The returned maps
+// will ONLY contain keys with a value of true, as opposed to the full list of
+// features and true/false.  This helps to quickly determine equality, subset
+// status, and superset status.
+func FeatureCompare(a, b *FeatureSet) (union, onlyA, onlyB FeaturesMap) {
+	return compareMaps(a.toMap(), b.toMap())
+}
+
+// FeatureCompareV3 is identical to FeatureCompare, but uses the IIIF 3.0
+// feature names
+func FeatureCompareV3(a, b *FeatureSet) (union, onlyA, onlyB FeaturesMap) {
+	return compareMaps(a.toMapV3(), b.toMapV3())
+}
+
 // includes returns whether or not fs includes all features in fsIncluded
 func (fs *FeatureSet) includes(fsIncluded *FeatureSet) bool {
 	_, _, onlyYours := FeatureCompare(fs, fsIncluded)
 	return len(onlyYours) == 0
 }
+
+// includesV3 returns whether or not fs includes all features in fsIncluded,
+// using the IIIF 3.0 feature names
+func (fs *FeatureSet) includesV3(fsIncluded *FeatureSet) bool {
+	_, _, onlyYours := FeatureCompareV3(fs, fsIncluded)
+	return len(onlyYours) == 0
+}
@@ -15,9 +15,38 @@
 	FmtWEBP    Format = "webp"
 )
 
-// Formats is the definitive list of all possible Format constants
+// Formats is the definitive list of all possible Format constants, plus any
+// formats added via RegisterFormat
 var Formats = []Format{FmtJPG, FmtTIF, FmtPNG, FmtGIF, FmtJP2, FmtPDF, FmtWEBP}
 
+// registered holds formats added at runtime, mapped to whether or not they
+// support transparency
+var registered = make(map[Format]bool)
+
+// RegisterFormat adds f to the list of valid formats.  This is meant to be
+// called at startup, typically by an encoder plugin, and is not safe to call
+// while requests are being served.  Registering a format which is already
+// valid does nothing.
+func RegisterFormat(f Format, supportsAlpha bool) {
+	if f == FmtUnknown || f.Valid() {
+		return
+	}
+	Formats = append(Formats, f)
+	registered[f] = supportsAlpha
+}
+
+// RegisteredFormats returns the formats which were added via RegisterFormat,
+// in the order they were registered
+func RegisteredFormats() []Format {
+	var list []Format
+	for _, f := range Formats {
+		if _, ok := registered[f]; ok {
+			list = append(list, f)
+		}
+	}
+	return list
+}
+
 // StringToFormat converts val into a Format constant if val is one of our
 // valid Formats
 func StringToFormat(val string) Format {
@@ -40,3 +69,14 @@ func (f Format) Valid() bool {
 
 	return false
 }
+
+// SupportsAlpha returns true if the format can represent transparency, which
+// matters for operations like arbitrary rotation where parts of the image
+// have no source data
+func (f Format) SupportsAlpha() bool {
+	switch f {
+	case FmtPNG, FmtTIF, FmtJP2, FmtWEBP:
+		return true
+	}
+	return registered[f]
+}
@@ -12,3 +12,45 @@ func TestFormatValidity(t *testing.T) {
 		assert.True(Format(f).Valid(), f+" is a valid format", t)
 	}
 }
+
+// registerTestFormat registers f for the duration of a test, restoring the
+// format registry once the test is done
+func registerTestFormat(f Format, supportsAlpha bool, t *testing.T) {
+	var formats = Formats
+	var reg = registered
+	Formats = append([]Format(nil), Formats...)
+	registered = make(map[Format]bool)
+	for k, v := range reg {
+		registered[k] = v
+	}
+	t.Cleanup(func() {
+		Formats = formats
+		registered = reg
+	})
+
+	RegisterFormat(f, supportsAlpha)
+}
+
+func TestRegisterFormat(t *testing.T) {
+	assert.False(Format("jxl").Valid(), "jxl isn't valid until registered", t)
+	registerTestFormat("jxl", true, t)
+	registerTestFormat("avif", false, t)
+
+	assert.True(Format("jxl").Valid(), "jxl is valid once registered", t)
+	assert.Equal(Format("jxl"), StringToFormat("jxl"), "jxl converts to a Format", t)
+	assert.True(Format("jxl").SupportsAlpha(), "jxl alpha support", t)
+	assert.False(Format("avif").SupportsAlpha(), "avif alpha support", t)
+	assert.Equal(2, len(RegisteredFormats()), "registered format count", t)
+
+	var fs = FeatureSet2()
+	assert.False(fs.SupportsFormat("jxl"), "jxl isn't supported by a feature set which doesn't list it", t)
+	fs.ExtraFormats = []Format{"jxl", "bogus"}
+	assert.True(fs.SupportsFormat("jxl"), "jxl is supported once listed", t)
+	assert.False(fs.SupportsFormat("bogus"), "unregistered formats are never supported", t)
+	assert.IncludesString("jxl", fs.Info().Profile.Formats, "jxl in the 2.x profile", t)
+	assert.Equal(1, len(fs.Info().Profile.Formats), "unregistered formats aren't in the 2.x profile", t)
+
+	var p = AllFeatures().ProfileV3()
+	assert.IncludesString("jxl", p.ExtraFormats, "jxl in the 3.0 profile", t)
+	assert.IncludesString("avif", p.ExtraFormats, "avif in the 3.0 profile", t)
+}
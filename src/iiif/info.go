@@ -1,9 +1,11 @@
 package iiif
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"sort"
+	"strings"
 )
 
 // ProfileWrapper is a structure which has to custom-marshal itself to provide
@@ -65,32 +67,288 @@ func (p *ProfileWrapper) UnmarshalJSON(data []byte) error {
 	return nil
 }
 
+// ProfileV3 holds the IIIF 3.0 compliance level and anything supported beyond
+// that level.  Unlike the 2.x profile, these are all top-level properties in
+// a 3.0 info.json, so this structure is never marshaled directly.
+type ProfileV3 struct {
+	Level          string
+	ExtraFormats   []string
+	ExtraQualities []string
+	ExtraFeatures  []string
+}
+
 // Info represents the simplest possible data to provide a valid IIIF
-// information JSON response
+// information JSON response.  The structure mirrors the 2.x info.json; when
+// Version is V3, it's marshaled into the 3.0 structure instead, using
+// ProfileV3 for the compliance data.
 type Info struct {
 	Context  string         `json:"@context"`
 	ID       string         `json:"@id"`
 	Protocol string         `json:"protocol"`
 	Width    int            `json:"width"`
 	Height   int            `json:"height"`
+	Sizes    []ImageSize    `json:"sizes,omitempty"`
 	Tiles    []TileSize     `json:"tiles,omitempty"`
 	Profile  ProfileWrapper `json:"profile"`
+
+	// Service holds related services, such as those used for authorization.
+	// Their structure is defined by the specifications of those services, not
+	// the Image API, so they're marshaled as-is.
+	Service []interface{} `json:"service,omitempty"`
+
+	// Rights is the URI of a rights statement or license for the image.  It's
+	// the "rights" property in 3.0, and "license" in 2.x.
+	Rights string `json:"-"`
+
+	// PartOf lists resources the image is part of, such as a manifest.  It
+	// only exists in 3.0, so it isn't written to 2.x responses.
+	PartOf []interface{} `json:"-"`
+
+	// Extensions holds any other properties, which are added to the top level
+	// of the info.json as-is.  Properties the Image API defines can't be
+	// overridden here.  When an info.json is unmarshaled, any properties RAIS
+	// doesn't otherwise know about end up here.
+	Extensions map[string]interface{} `json:"-"`
+
+	Version   Version   `json:"-"`
+	ProfileV3 ProfileV3 `json:"-"`
+}
+
+// Top-level properties of each info.json structure, so we know which
+// properties are extensions when unmarshaling
+var (
+	keysV2 = []string{"@context", "@id", "protocol", "width", "height", "sizes", "tiles", "profile", "service"}
+	keysV3 = []string{"@context", "id", "type", "protocol", "profile", "width", "height", "maxWidth", "maxHeight",
+		"maxArea", "sizes", "tiles", "extraFormats", "extraQualities", "extraFeatures", "service", "rights", "partOf"}
+)
+
+// infoV2 lets us marshal and unmarshal an Info without recursing into its
+// custom JSON functions
+type infoV2 Info
+
+// infoV3 is the on-the-wire structure for a IIIF 3.0 info.json response
+type infoV3 struct {
+	Context        string        `json:"@context"`
+	ID             string        `json:"id"`
+	Type           string        `json:"type"`
+	Protocol       string        `json:"protocol"`
+	Profile        string        `json:"profile"`
+	Width          int           `json:"width"`
+	Height         int           `json:"height"`
+	MaxWidth       int           `json:"maxWidth,omitempty"`
+	MaxHeight      int           `json:"maxHeight,omitempty"`
+	MaxArea        int64         `json:"maxArea,omitempty"`
+	Sizes          []ImageSize   `json:"sizes,omitempty"`
+	Tiles          []TileSize    `json:"tiles,omitempty"`
+	ExtraFormats   []string      `json:"extraFormats,omitempty"`
+	ExtraQualities []string      `json:"extraQualities,omitempty"`
+	ExtraFeatures  []string      `json:"extraFeatures,omitempty"`
+	Service        []interface{} `json:"service,omitempty"`
+	Rights         string        `json:"rights,omitempty"`
+	PartOf         []interface{} `json:"partOf,omitempty"`
 }
 
 // NewInfo returns the static *Info data that's the same for any info response
 func NewInfo() *Info {
 	return &Info{
-		Context:  "http://iiif.io/api/image/2/context.json",
+		Context:  ContextV2,
 		Protocol: "http://iiif.io/api/image",
 	}
 }
 
+// MarshalJSON implements json.Marshaler, producing a 2.x or 3.0 structure
+// depending on the info's Version
+func (i *Info) MarshalJSON() ([]byte, error) {
+	var data, err = i.marshalVersion()
+	if err == nil && i.Version != V3 && i.Rights != "" {
+		data, err = addExtensions(data, map[string]interface{}{"license": i.Rights})
+	}
+	if err != nil || len(i.Extensions) == 0 {
+		return data, err
+	}
+	return addExtensions(data, i.Extensions)
+}
+
+// marshalVersion returns the JSON for the info's version, without extensions
+func (i *Info) marshalVersion() ([]byte, error) {
+	if i.Version != V3 {
+		var i2 = infoV2(*i)
+		if i2.Context == "" || ContextToVersion(i2.Context) == V3 {
+			i2.Context = ContextV2
+		}
+		if i2.Profile.ConformanceURL == "" {
+			i2.Profile = i.ProfileV3.toV2(i.Profile)
+		}
+		return json.Marshal(&i2)
+	}
+
+	var p3 = i.ProfileV3
+	if p3.Level == "" {
+		p3 = i.Profile.toV3()
+	}
+	return json.Marshal(&infoV3{
+		Context:        ContextV3,
+		ID:             i.ID,
+		Type:           "ImageService3",
+		Protocol:       i.Protocol,
+		Profile:        p3.Level,
+		Width:          i.Width,
+		Height:         i.Height,
+		MaxWidth:       i.Profile.MaxWidth,
+		MaxHeight:      i.Profile.MaxHeight,
+		MaxArea:        i.Profile.MaxArea,
+		Sizes:          i.Sizes,
+		Tiles:          i.Tiles,
+		ExtraFormats:   p3.ExtraFormats,
+		ExtraQualities: p3.ExtraQualities,
+		ExtraFeatures:  p3.ExtraFeatures,
+		Service:        i.Service,
+		Rights:         i.Rights,
+		PartOf:         i.PartOf,
+	})
+}
+
+// addExtensions appends the extension properties, sorted by name, to the JSON
+// object in data.  Properties already in data are skipped.
+func addExtensions(data []byte, ext map[string]interface{}) ([]byte, error) {
+	var core map[string]json.RawMessage
+	var err = json.Unmarshal(data, &core)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for k := range ext {
+		if _, ok := core[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var buf = bytes.NewBuffer(nil)
+	buf.Write(data[:len(data)-1])
+	for _, k := range keys {
+		var key, _ = json.Marshal(k)
+		var val []byte
+		val, err = json.Marshal(ext[k])
+		if err != nil {
+			return nil, fmt.Errorf("invalid extension %q: %s", k, err)
+		}
+		buf.WriteByte(',')
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+
+	return buf.Bytes(), nil
+}
+
+// extensions returns the properties in data which aren't in the known list
+func extensions(data []byte, known []string) (map[string]interface{}, error) {
+	var all map[string]json.RawMessage
+	var err = json.Unmarshal(data, &all)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range known {
+		delete(all, k)
+	}
+	if len(all) == 0 {
+		return nil, nil
+	}
+
+	var ext = make(map[string]interface{}, len(all))
+	for k, v := range all {
+		ext[k] = v
+	}
+	return ext, nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler.  Both 2.x and 3.0 info.json
+// structures are understood, based on the JSON-LD context.
+func (i *Info) UnmarshalJSON(data []byte) error {
+	var probe struct {
+		Context json.RawMessage `json:"@context"`
+	}
+	var err = json.Unmarshal(data, &probe)
+	if err != nil {
+		return err
+	}
+
+	if !isV3Context(probe.Context) {
+		var i2 infoV2
+		err = json.Unmarshal(data, &i2)
+		if err != nil {
+			return err
+		}
+		*i = Info(i2)
+		i.Version = V2
+		i.Extensions, err = extensions(data, keysV2)
+		return err
+	}
+
+	var i3 infoV3
+	err = json.Unmarshal(data, &i3)
+	if err != nil {
+		return err
+	}
+
+	*i = Info{
+		Context:  ContextV3,
+		ID:       i3.ID,
+		Protocol: i3.Protocol,
+		Width:    i3.Width,
+		Height:   i3.Height,
+		Sizes:    i3.Sizes,
+		Tiles:    i3.Tiles,
+		Service:  i3.Service,
+		Rights:   i3.Rights,
+		PartOf:   i3.PartOf,
+		Version:  V3,
+		ProfileV3: ProfileV3{
+			Level:          i3.Profile,
+			ExtraFormats:   i3.ExtraFormats,
+			ExtraQualities: i3.ExtraQualities,
+			ExtraFeatures:  i3.ExtraFeatures,
+		},
+	}
+	i.Profile.MaxWidth = i3.MaxWidth
+	i.Profile.MaxHeight = i3.MaxHeight
+	i.Profile.MaxArea = i3.MaxArea
+	i.Extensions, err = extensions(data, keysV3)
+
+	return err
+}
+
+// isV3Context returns true if the raw "@context" value is, or contains, the
+// IIIF 3.0 context URI.  3.0 allows the context to be a list when extensions
+// are in use.
+func isV3Context(raw json.RawMessage) bool {
+	var ctx string
+	if json.Unmarshal(raw, &ctx) == nil {
+		return ContextToVersion(ctx) == V3
+	}
+
+	var ctxList []string
+	if json.Unmarshal(raw, &ctxList) == nil {
+		for _, ctx = range ctxList {
+			if ContextToVersion(ctx) == V3 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
 // Info returns the default structure for a FeatureSet's info response JSON.
 // The caller is responsible for filling in image-specific values (ID and
 // dimensions).
 func (fs *FeatureSet) Info() *Info {
 	i := NewInfo()
 	i.Profile = fs.Profile()
+	i.ProfileV3 = fs.ProfileV3()
 
 	return i
 }
@@ -125,6 +383,39 @@ func (fs *FeatureSet) Profile() ProfileWrapper {
 	return p
 }
 
+// baseFeatureSetV3 returns a FeatureSet instance for the base IIIF 3.0 level
+// as well as the level's name
+func (fs *FeatureSet) baseFeatureSetV3() (*FeatureSet, string) {
+	FeaturesLevel2 := V3FeatureSet2()
+	if fs.includesV3(FeaturesLevel2) {
+		return FeaturesLevel2, "level2"
+	}
+
+	FeaturesLevel1 := V3FeatureSet1()
+	if fs.includesV3(FeaturesLevel1) {
+		return FeaturesLevel1, "level1"
+	}
+
+	return V3FeatureSet0(), "level0"
+}
+
+// ProfileV3 examines the features in the FeatureSet to determine which IIIF
+// 3.0 level the FeatureSet supports, then adds any variances.
+func (fs *FeatureSet) ProfileV3() ProfileV3 {
+	baseFS, level := fs.baseFeatureSetV3()
+	p := ProfileV3{Level: level}
+
+	_, extraFeatures, _ := FeatureCompareV3(fs, baseFS)
+	if len(extraFeatures) > 0 {
+		var extra = extraProfileFromFeaturesMap(extraFeatures)
+		p.ExtraFormats = extra.Formats
+		p.ExtraQualities = extra.Qualities
+		p.ExtraFeatures = extra.Supports
+	}
+
+	return p
+}
+
 func extraProfileFromFeaturesMap(fm FeaturesMap) profileElement2 {
 	p := profileElement2{
 		Formats:   make([]string, 0),
@@ -153,3 +444,93 @@ func extraProfileFromFeaturesMap(fm FeaturesMap) profileElement2 {
 
 	return p
 }
+
+// v2ToV3Features maps 2.x feature names to their 3.0 equivalents.  Features
+// which no longer exist map to an empty string.
+var v2ToV3Features = map[string]string{
+	"sizeAboveFull":     "sizeUpscaling",
+	"sizeByForcedWh":    "sizeByWh",
+	"sizeByDistortedWh": "sizeByWh",
+	"sizeByWh":          "sizeByConfinedWh",
+	"sizeByWhListed":    "",
+}
+
+// v3ToV2Features maps 3.0 feature names to the 2.x names RAIS uses
+var v3ToV2Features = map[string]string{
+	"sizeUpscaling":    "sizeAboveFull",
+	"sizeByWh":         "sizeByForcedWh",
+	"sizeByConfinedWh": "sizeByWh",
+}
+
+// levelPrefixV2 and levelSuffixV2 wrap a level name ("level1") to produce the
+// 2.x conformance URL
+const levelPrefixV2 = "http://iiif.io/api/image/2/"
+const levelSuffixV2 = ".json"
+
+// levelPrefixV3 is the 3.0 equivalent of levelPrefixV2.  Level names are only
+// turned into URIs for the "profile" Link header in 3.0.
+const levelPrefixV3 = "http://iiif.io/api/image/3/"
+
+// ProfileURI returns the URI of the compliance level the info describes, for
+// use in a "profile" Link header
+func (i *Info) ProfileURI() string {
+	if i.Version == V3 {
+		var p3 = i.ProfileV3
+		if p3.Level == "" {
+			p3 = i.Profile.toV3()
+		}
+		return levelPrefixV3 + p3.Level + levelSuffixV2
+	}
+
+	if i.Profile.ConformanceURL == "" {
+		return i.ProfileV3.toV2(i.Profile).ConformanceURL
+	}
+	return i.Profile.ConformanceURL
+}
+
+// renameFeatures returns a sorted, de-duplicated copy of list with names
+// replaced per the given map
+func renameFeatures(list []string, renames map[string]string) []string {
+	var seen = make(map[string]bool)
+	var out []string
+	for _, name := range list {
+		if newName, ok := renames[name]; ok {
+			name = newName
+		}
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		out = append(out, name)
+	}
+
+	sort.Strings(out)
+	return out
+}
+
+// toV3 does a best-effort conversion of a 2.x profile to 3.0.  This is only
+// needed when a 2.x info.json (e.g., an override file) is served to a 3.0
+// client, so there's no FeatureSet to compute the real 3.0 level.
+func (p ProfileWrapper) toV3() ProfileV3 {
+	var level = strings.TrimSuffix(strings.TrimPrefix(p.ConformanceURL, levelPrefixV2), levelSuffixV2)
+	return ProfileV3{
+		Level:          level,
+		ExtraFormats:   p.Formats,
+		ExtraQualities: p.Qualities,
+		ExtraFeatures:  renameFeatures(p.Supports, v2ToV3Features),
+	}
+}
+
+// toV2 is the inverse of ProfileWrapper.toV3, and is just as much of a
+// best-effort conversion.  The maximums are kept from the given profile, as
+// they're stored there regardless of version.
+func (p ProfileV3) toV2(base ProfileWrapper) ProfileWrapper {
+	var pw = base
+	if p.Level != "" {
+		pw.ConformanceURL = levelPrefixV2 + p.Level + levelSuffixV2
+	}
+	pw.Formats = p.ExtraFormats
+	pw.Qualities = p.ExtraQualities
+	pw.Supports = renameFeatures(p.ExtraFeatures, v3ToV2Features)
+	return pw
+}
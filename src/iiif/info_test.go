@@ -1,6 +1,8 @@
 package iiif
 
 import (
+	"bytes"
+	"encoding/json"
 	"testing"
 
 	"github.com/uoregon-libraries/gopkg/assert"
@@ -61,11 +63,172 @@ func TestAllFeaturesEnabled(t *testing.T) {
 	assert.Equal("http://iiif.io/api/image/2/level2.json", i.Profile.ConformanceURL, "Profile conformance level", t)
 
 	extra := i.Profile.profileElement2
-	assert.Equal(5, len(extra.Supports), "THERE... ARE... FOUR... (plus one) EXTRA... FEATURES!", t)
+	assert.Equal(8, len(extra.Supports), "THERE... ARE... FOUR... (plus four) EXTRA... FEATURES!", t)
 	assert.Equal(0, len(extra.Qualities), "There are 0 extra qualities", t)
-	assert.Equal(1, len(extra.Formats), "There is 1 extra format", t)
+	assert.Equal(5, len(extra.Formats), "There are 5 extra formats", t)
 	assert.IncludesString("regionSquare", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("sizeAboveFull", extra.Supports, "Custom FS support", t)
 	assert.IncludesString("mirroring", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("rotationArbitrary", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("profileLinkHeader", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("canonicalLinkHeader", extra.Supports, "Custom FS support", t)
+	assert.IncludesString("gif", extra.Formats, "Custom FS support", t)
 	assert.IncludesString("tif", extra.Formats, "Custom FS support", t)
+	assert.IncludesString("jp2", extra.Formats, "Custom FS support", t)
+	assert.IncludesString("pdf", extra.Formats, "Custom FS support", t)
+	assert.IncludesString("webp", extra.Formats, "Custom FS support", t)
+}
+
+func TestAllFeaturesV3Profile(t *testing.T) {
+	fs := AllFeatures()
+	p := fs.ProfileV3()
+	assert.Equal("level2", p.Level, "Profile level", t)
+	assert.Equal(5, len(p.ExtraFormats), "There are 5 extra formats", t)
+	assert.IncludesString("gif", p.ExtraFormats, "Extra format", t)
+	assert.IncludesString("tif", p.ExtraFormats, "Extra format", t)
+	assert.IncludesString("jp2", p.ExtraFormats, "Extra format", t)
+	assert.IncludesString("pdf", p.ExtraFormats, "Extra format", t)
+	assert.IncludesString("webp", p.ExtraFormats, "Extra format", t)
+	assert.Equal(3, len(p.ExtraQualities), "There are 3 extra qualities", t)
+	assert.IncludesString("bitonal", p.ExtraQualities, "Extra quality", t)
+	assert.IncludesString("sizeUpscaling", p.ExtraFeatures, "Extra feature uses 3.0 name", t)
+	assert.IncludesString("mirroring", p.ExtraFeatures, "Extra feature", t)
+	assert.IncludesString("rotationArbitrary", p.ExtraFeatures, "Extra feature", t)
+	assert.IncludesString("profileLinkHeader", p.ExtraFeatures, "Extra feature", t)
+	assert.IncludesString("canonicalLinkHeader", p.ExtraFeatures, "Extra feature", t)
+	assert.Equal(5, len(p.ExtraFeatures), "No level 2 features are extras", t)
+}
+
+func TestLevel1V3Profile(t *testing.T) {
+	fs := FeatureSet1()
+	p := fs.ProfileV3()
+	assert.Equal("level0", p.Level, "2.x level 1 lacks regionSquare and sizeByWh for 3.0 level 1", t)
+
+	fs.RegionSquare = true
+	fs.SizeByForcedWh = true
+	p = fs.ProfileV3()
+	assert.Equal("level1", p.Level, "3.0 level 1", t)
+	assert.Equal(1, len(p.ExtraFeatures), "Only one extra feature", t)
+	assert.IncludesString("sizeByPct", p.ExtraFeatures, "Extra feature", t)
+}
+
+func TestInfoV3JSON(t *testing.T) {
+	fs := AllFeatures()
+	i := fs.Info()
+	i.Version = V3
+	i.ID = "http://example.org/iiif/foo"
+	i.Width = 100
+	i.Height = 200
+	i.Profile.MaxWidth = 50
+
+	var data, err = json.Marshal(i)
+	assert.NilError(err, "marshaling v3 info", t)
+
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	assert.Equal(ContextV3, raw["@context"], "3.0 context", t)
+	assert.Equal("http://example.org/iiif/foo", raw["id"], "id", t)
+	assert.Equal("ImageService3", raw["type"], "type", t)
+	assert.Equal("level2", raw["profile"], "profile is a simple string", t)
+	assert.Equal(50.0, raw["maxWidth"], "maxWidth is top-level", t)
+	assert.True(raw["@id"] == nil, "no 2.x @id", t)
+
+	var i2 Info
+	err = json.Unmarshal(data, &i2)
+	assert.NilError(err, "unmarshaling v3 info", t)
+	assert.Equal(V3, i2.Version, "round-trip version", t)
+	assert.Equal("level2", i2.ProfileV3.Level, "round-trip level", t)
+	assert.Equal(50, i2.Profile.MaxWidth, "round-trip maxWidth", t)
+	assert.Equal(100, i2.Width, "round-trip width", t)
+}
+
+func TestInfoV2OverrideAsV3(t *testing.T) {
+	var src = `{"@context":"http://iiif.io/api/image/2/context.json","@id":"x","protocol":"http://iiif.io/api/image",` +
+		`"width":800,"height":400,"profile":["http://iiif.io/api/image/2/level2.json",{"formats":["gif"],"supports":["sizeAboveFull"]}]}`
+	var i Info
+	var err = json.Unmarshal([]byte(src), &i)
+	assert.NilError(err, "unmarshaling v2 info", t)
+	assert.Equal(V2, i.Version, "version", t)
+	assert.Equal("http://iiif.io/api/image/2/level2.json", i.Profile.ConformanceURL, "conformance URL", t)
+
+	i.Version = V3
+	var data []byte
+	data, err = json.Marshal(&i)
+	assert.NilError(err, "marshaling v2 info as v3", t)
+
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	assert.Equal("level2", raw["profile"], "converted profile", t)
+	assert.True(bytes.Contains(data, []byte(`"extraFeatures":["sizeUpscaling"]`)), "converted feature names", t)
+}
+
+func TestInfoSizes(t *testing.T) {
+	var i = AllFeatures().Info()
+	i.Width, i.Height = 400, 200
+	i.Sizes = []ImageSize{{Width: 100, Height: 50}, {Width: 200, Height: 100}}
+
+	var data, err = json.Marshal(i)
+	assert.NilError(err, "marshaling 2.x info", t)
+	assert.True(bytes.Contains(data, []byte(`"sizes":[{"width":100,"height":50},{"width":200,"height":100}]`)), "2.x sizes", t)
+
+	i.Version = V3
+	data, err = json.Marshal(i)
+	assert.NilError(err, "marshaling 3.0 info", t)
+	assert.True(bytes.Contains(data, []byte(`"sizes":[{"width":100,"height":50},{"width":200,"height":100}]`)), "3.0 sizes", t)
+
+	var i2 Info
+	err = json.Unmarshal(data, &i2)
+	assert.NilError(err, "unmarshaling 3.0 info", t)
+	assert.Equal(2, len(i2.Sizes), "3.0 sizes are read", t)
+	assert.Equal(200, i2.Sizes[1].Width, "3.0 sizes are read", t)
+}
+
+func TestInfoExtensions(t *testing.T) {
+	var i = AllFeatures().Info()
+	i.ID = "http://example.org/iiif/foo"
+	i.Width, i.Height = 400, 200
+	i.Rights = "http://rightsstatements.org/vocab/InC/1.0/"
+	i.PartOf = []interface{}{map[string]string{"id": "http://example.org/manifest", "type": "Manifest"}}
+	i.Extensions = map[string]interface{}{
+		"physicalDimensions": map[string]interface{}{"scale": 0.01, "units": "cm"},
+		"width":              9999,
+	}
+
+	var data, err = json.Marshal(i)
+	assert.NilError(err, "marshaling 2.x info", t)
+	var raw map[string]interface{}
+	json.Unmarshal(data, &raw)
+	assert.Equal("http://rightsstatements.org/vocab/InC/1.0/", raw["license"], "2.x rights are a license", t)
+	assert.True(raw["rights"] == nil, "no 3.0 rights in 2.x", t)
+	assert.True(raw["partOf"] == nil, "no partOf in 2.x", t)
+	assert.Equal(400.0, raw["width"], "extensions can't override core properties", t)
+	assert.True(raw["physicalDimensions"] != nil, "extensions are added", t)
+
+	var i2 Info
+	err = json.Unmarshal(data, &i2)
+	assert.NilError(err, "unmarshaling 2.x info", t)
+	assert.Equal(2, len(i2.Extensions), "unknown 2.x properties are extensions", t)
+	assert.True(i2.Extensions["license"] != nil, "2.x license is kept as-is", t)
+
+	i.Version = V3
+	data, err = json.Marshal(i)
+	assert.NilError(err, "marshaling 3.0 info", t)
+	raw = nil
+	json.Unmarshal(data, &raw)
+	assert.Equal("http://rightsstatements.org/vocab/InC/1.0/", raw["rights"], "3.0 rights", t)
+	assert.True(raw["license"] == nil, "no 2.x license in 3.0", t)
+	assert.True(raw["partOf"] != nil, "3.0 partOf", t)
+	assert.True(bytes.HasSuffix(data, []byte(`"physicalDimensions":{"scale":0.01,"units":"cm"}}`)), "extensions come last", t)
+
+	var i3 Info
+	err = json.Unmarshal(data, &i3)
+	assert.NilError(err, "unmarshaling 3.0 info", t)
+	assert.Equal(i.Rights, i3.Rights, "round-trip rights", t)
+	assert.Equal(1, len(i3.PartOf), "round-trip partOf", t)
+	assert.Equal(1, len(i3.Extensions), "round-trip extensions", t)
+
+	var again []byte
+	again, err = json.Marshal(&i3)
+	assert.NilError(err, "re-marshaling 3.0 info", t)
+	assert.True(bytes.Contains(again, []byte(`"physicalDimensions":{"scale":0.01,"units":"cm"}`)), "unmarshaled extensions are written back out", t)
 }
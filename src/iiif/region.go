@@ -108,3 +108,26 @@ func (r Region) GetCrop(w, h int) image.Rectangle {
 
 	return crop
 }
+
+// String returns the region as it would appear in a IIIF URL
+func (r Region) String() string {
+	switch r.Type {
+	case RTFull:
+		return "full"
+	case RTSquare:
+		return "square"
+	}
+
+	var vals = []string{formatFloat(r.X), formatFloat(r.Y), formatFloat(r.W), formatFloat(r.H)}
+	var s = strings.Join(vals, ",")
+	if r.Type == RTPercent {
+		return "pct:" + s
+	}
+	return s
+}
+
+// formatFloat returns the shortest string which represents f exactly, which
+// means whole numbers have no decimal point
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
@@ -41,3 +41,11 @@ func StringToRotation(p string) Rotation {
 func (r Rotation) Valid() bool {
 	return r.Degrees >= 0 && r.Degrees < 360
 }
+
+// String returns the rotation as it would appear in a IIIF URL
+func (r Rotation) String() string {
+	if r.Mirror {
+		return "!" + formatFloat(r.Degrees)
+	}
+	return formatFloat(r.Degrees)
+}
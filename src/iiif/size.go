@@ -35,11 +35,13 @@
 )
 
 // Size represents the type of scaling as well as the parameters for scaling
-// for a IIIF 2.0 server
+// for a IIIF 2.0 server.  Upscale is only valid for IIIF 3.0 requests, where
+// the "^" prefix is required for any size larger than the requested region.
 type Size struct {
 	Type    SizeType
 	Percent float64
 	W, H    int
+	Upscale bool
 }
 
 // StringToSize creates a Size from a string as seen in a IIIF URL.
@@ -48,6 +50,21 @@ func StringToSize(p string) Size {
 		return Size{}
 	}
 
+	if p[0] == '^' {
+		var s = stringToSize(p[1:])
+		s.Upscale = true
+		return s
+	}
+
+	return stringToSize(p)
+}
+
+// stringToSize handles all size parsing except for the upscale prefix
+func stringToSize(p string) Size {
+	if p == "" {
+		return Size{}
+	}
+
 	if p == "full" {
 		return Size{Type: STFull}
 	}
@@ -107,6 +124,18 @@ func (s Size) Valid() bool {
 	return false
 }
 
+// Upscales returns true if the size would result in an image larger than the
+// given region in either dimension.  STMax and STFull never upscale here, as
+// only the image server knows how big "max" is when upscaling is requested.
+func (s Size) Upscales(region image.Rectangle) bool {
+	if s.Type == STMax || s.Type == STFull {
+		return false
+	}
+
+	var scale = s.GetResize(region)
+	return scale.Dx() > region.Dx() || scale.Dy() > region.Dy()
+}
+
 // GetResize determines how a given region would be resized and returns a
 // rectangle representing the scaled image's dimensions.  If STMax is in use,
 // this returns the full region, as only the image server itself would know its
@@ -143,3 +172,29 @@ func (s Size) getBestFit(w, h int) (int, int) {
 	}
 	return int(sf * fW), int(sf * fH)
 }
+
+// String returns the size as it would appear in a IIIF URL
+func (s Size) String() string {
+	var str string
+	switch s.Type {
+	case STFull:
+		str = "full"
+	case STMax:
+		str = "max"
+	case STScaleToWidth:
+		str = strconv.Itoa(s.W) + ","
+	case STScaleToHeight:
+		str = "," + strconv.Itoa(s.H)
+	case STScalePercent:
+		str = "pct:" + formatFloat(s.Percent)
+	case STExact:
+		str = strconv.Itoa(s.W) + "," + strconv.Itoa(s.H)
+	case STBestFit:
+		str = "!" + strconv.Itoa(s.W) + "," + strconv.Itoa(s.H)
+	}
+
+	if s.Upscale {
+		return "^" + str
+	}
+	return str
+}
@@ -108,3 +108,42 @@ func TestGetResize(t *testing.T) {
 	assert.Equal(scale.Dx(), 50, "scale-to-pct Dx", t)
 	assert.Equal(scale.Dy(), 100, "scale-to-pct Dy", t)
 }
+
+func TestSizeUpscale(t *testing.T) {
+	s := StringToSize("^125,")
+	assert.True(s.Valid(), "s.Valid()", t)
+	assert.True(s.Upscale, "s.Upscale", t)
+	assert.Equal(STScaleToWidth, s.Type, "s.Type == STScaleToWidth", t)
+	assert.Equal(125, s.W, "s.W", t)
+
+	s = StringToSize("^!25,50")
+	assert.True(s.Valid(), "s.Valid()", t)
+	assert.True(s.Upscale, "s.Upscale", t)
+	assert.Equal(STBestFit, s.Type, "s.Type == STBestFit", t)
+
+	s = StringToSize("^pct:150")
+	assert.True(s.Valid(), "s.Valid()", t)
+	assert.True(s.Upscale, "s.Upscale", t)
+	assert.Equal(150.0, s.Percent, "s.Percent", t)
+
+	s = StringToSize("^max")
+	assert.True(s.Valid(), "s.Valid()", t)
+	assert.True(s.Upscale, "s.Upscale", t)
+	assert.Equal(STMax, s.Type, "s.Type == STMax", t)
+
+	s = StringToSize("^")
+	assert.False(s.Valid(), "a bare caret isn't valid", t)
+
+	s = StringToSize("125,")
+	assert.False(s.Upscale, "no caret, no upscale", t)
+}
+
+func TestSizeUpscales(t *testing.T) {
+	region := image.Rect(0, 0, 600, 1200)
+	assert.True(StringToSize("601,").Upscales(region), "wider than region", t)
+	assert.True(StringToSize("100,1201").Upscales(region), "taller than region", t)
+	assert.True(StringToSize("pct:101").Upscales(region), "percent over 100", t)
+	assert.False(StringToSize("600,").Upscales(region), "same width", t)
+	assert.False(StringToSize("max").Upscales(region), "max never upscales", t)
+	assert.False(StringToSize("full").Upscales(region), "full never upscales", t)
+}
@@ -23,14 +23,15 @@ func (id ID) Escaped() string {
 
 // URL represents the different options composed into a IIIF URL request
 type URL struct {
-	Path            string
-	ID              ID
-	Region          Region
-	Size            Size
-	Rotation        Rotation
-	Quality         Quality
-	Format          Format
-	Info            bool
+	Path     string
+	Version  Version
+	ID       ID
+	Region   Region
+	Size     Size
+	Rotation Rotation
+	Quality  Quality
+	Format   Format
+	Info     bool
 }
 
 type pathParts struct {
@@ -70,8 +71,19 @@ func (p *pathParts) rejoin() string {
 // theoretically exist for a resource with *any* id.  In those cases it's up to
 // the caller to figure out what to do - the returned URL will have as much
 // information as we're able to parse.
+//
+// NewURL parses the path using IIIF 2.x rules; see NewVersionedURL for 3.0.
 func NewURL(path string) (*URL, error) {
-	var u = &URL{Path: path}
+	return NewVersionedURL(path, V2)
+}
+
+// NewVersionedURL works just like NewURL, but validates the path against the
+// rules of the given IIIF Image API version.  The 3.0 syntax is nearly
+// identical to 2.x, but "full" is no longer a valid size, "native" is no
+// longer a valid quality, and sizes may be prefixed with "^" to allow
+// upscaling.
+func NewVersionedURL(path string, v Version) (*URL, error) {
+	var u = &URL{Path: path, Version: v}
 
 	// Check for an info request first since it's pretty trivial to do
 	if strings.HasSuffix(path, "info.json") {
@@ -105,6 +117,32 @@ func NewURL(path string) (*URL, error) {
 	return u, nil
 }
 
+// ParamString returns the region, size, rotation, quality, and format of u
+// as they'd appear in a IIIF URL, e.g., "full/max/0/default.jpg"
+func (u *URL) ParamString() string {
+	return u.Region.String() + "/" + u.Size.String() + "/" + u.Rotation.String() + "/" +
+		string(u.Quality) + "." + string(u.Format)
+}
+
+// String returns the path u represents, with its ID escaped, suitable for
+// appending to a IIIF server's base URL
+func (u *URL) String() string {
+	if u.Info {
+		return u.ID.Escaped() + "/info.json"
+	}
+	return u.ID.Escaped() + "/" + u.ParamString()
+}
+
+// RequestedParams returns the region, size, rotation, quality, and format
+// exactly as they were in the path u was parsed from
+func (u *URL) RequestedParams() string {
+	var parts = strings.Split(u.Path, "/")
+	if len(parts) < 4 {
+		return u.Path
+	}
+	return strings.Join(parts[len(parts)-4:], "/")
+}
+
 // Valid returns the validity of the request - is the syntax is bad in any way?
 // Are any numbers outside a set range?  Was the identifier blank?  Etc.
 //
@@ -122,13 +160,13 @@ func (u *URL) Error() error {
 	if !u.Region.Valid() {
 		messages = append(messages, "invalid region")
 	}
-	if !u.Size.Valid() {
+	if !u.Size.Valid() || !u.validSizeForVersion() {
 		messages = append(messages, "invalid size")
 	}
 	if !u.Rotation.Valid() {
 		messages = append(messages, "invalid rotation")
 	}
-	if !u.Quality.Valid() {
+	if !u.Quality.Valid() || (u.Version == V3 && u.Quality == QNative) {
 		messages = append(messages, "invalid quality")
 	}
 	if !u.Format.Valid() {
@@ -140,3 +178,21 @@ func (u *URL) Error() error {
 	}
 	return nil
 }
+
+// validSizeForVersion checks the version-specific size rules: 2.x has no
+// upscale syntax, while 3.0 drops "full" and requires the upscale prefix for
+// percentages over 100
+func (u *URL) validSizeForVersion() bool {
+	if u.Version != V3 {
+		return !u.Size.Upscale
+	}
+
+	if u.Size.Type == STFull {
+		return false
+	}
+	if u.Size.Type == STScalePercent && u.Size.Percent > 100 && !u.Size.Upscale {
+		return false
+	}
+
+	return true
+}
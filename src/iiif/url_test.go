@@ -57,3 +57,36 @@ func TestInfoBaseRedirect(t *testing.T) {
 	assert.Equal("empty id, invalid region, invalid size, invalid quality", err.Error(), "base redirects are error cases the caller must handle", t)
 	assert.Equal("", string(i.ID), "identifier", t)
 }
+
+func TestV3URL(t *testing.T) {
+	i, err := NewVersionedURL("foo/full/max/0/default.jpg", V3)
+	assert.NilError(err, "max size is valid in 3.0", t)
+	assert.Equal(V3, i.Version, "version", t)
+
+	i, err = NewVersionedURL("foo/full/full/0/default.jpg", V3)
+	assert.Equal("invalid size", err.Error(), "full size is invalid in 3.0", t)
+
+	i, err = NewVersionedURL("foo/full/max/0/native.jpg", V3)
+	assert.Equal("invalid quality", err.Error(), "native quality is invalid in 3.0", t)
+
+	i, err = NewURL("foo/full/full/0/native.jpg")
+	assert.NilError(err, "full size and native quality are valid in 2.x", t)
+	assert.Equal(V2, i.Version, "NewURL defaults to 2.x", t)
+}
+
+func TestV3UpscaleURL(t *testing.T) {
+	_, err := NewVersionedURL("foo/full/^max/0/default.jpg", V3)
+	assert.NilError(err, "upscaled max is valid in 3.0", t)
+
+	_, err = NewVersionedURL("foo/full/^pct:150/0/default.jpg", V3)
+	assert.NilError(err, "upscaled percent is valid in 3.0", t)
+
+	_, err = NewVersionedURL("foo/full/pct:150/0/default.jpg", V3)
+	assert.Equal("invalid size", err.Error(), "percent over 100 requires the caret in 3.0", t)
+
+	_, err = NewURL("foo/full/^max/0/default.jpg")
+	assert.Equal("invalid size", err.Error(), "2.x has no upscale syntax", t)
+
+	_, err = NewURL("foo/full/pct:150/0/default.jpg")
+	assert.NilError(err, "2.x allows upscaling percentages", t)
+}
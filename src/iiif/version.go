@@ -0,0 +1,42 @@
+package iiif
+
+import "strings"
+
+// Version represents the major version of the IIIF Image API a request or
+// response adheres to.  The zero value is treated as V2 so that existing code
+// which never sets a version keeps its 2.x behavior.
+type Version int
+
+// All IIIF Image API versions RAIS knows how to speak
+const (
+	V2 Version = 2
+	V3 Version = 3
+)
+
+// Context URIs for each API version, used in info.json and in profile-based
+// content negotiation
+const (
+	ContextV2 = "http://iiif.io/api/image/2/context.json"
+	ContextV3 = "http://iiif.io/api/image/3/context.json"
+)
+
+// Context returns the JSON-LD context URI for the version
+func (v Version) Context() string {
+	if v == V3 {
+		return ContextV3
+	}
+	return ContextV2
+}
+
+// ContextToVersion returns the Version a JSON-LD context URI refers to, or 0
+// if the context isn't one we know
+func ContextToVersion(ctx string) Version {
+	ctx = strings.TrimSpace(ctx)
+	switch strings.Replace(ctx, "https://", "http://", 1) {
+	case ContextV2:
+		return V2
+	case ContextV3:
+		return V3
+	}
+	return 0
+}
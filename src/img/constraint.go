@@ -1,5 +1,10 @@
 package img
 
+import (
+	"image"
+	"math"
+)
+
 // Constraint holds maximums the server is willing to return in image dimensions
 type Constraint struct {
 	Width  int
@@ -12,3 +17,52 @@ type Constraint struct {
 func (c Constraint) SmallerThanAny(w, h int) bool {
 	return w > c.Width || h > c.Height || int64(w)*int64(h) > c.Area
 }
+
+// Unlimited returns true if the constraint is set to the maximum values for
+// each dimension, meaning there are no actual limits
+func (c Constraint) Unlimited() bool {
+	return c.Width >= math.MaxInt32 && c.Height >= math.MaxInt32 && c.Area >= math.MaxInt64
+}
+
+// Min returns a constraint holding the smaller of each of c's and c2's
+// maximums
+func (c Constraint) Min(c2 Constraint) Constraint {
+	if c2.Width < c.Width {
+		c.Width = c2.Width
+	}
+	if c2.Height < c.Height {
+		c.Height = c2.Height
+	}
+	if c2.Area < c.Area {
+		c.Area = c2.Area
+	}
+	return c
+}
+
+// ForRegion converts c, a constraint on a full w x h image, into the
+// equivalent constraint on the given region of that image.  This lets a limit
+// on how much detail a user may see apply to tiles and other partial-image
+// requests: a 512x512 tile of a 4000px wide image is too detailed for a user
+// limited to 800px, but a 512x512 tile of a 2000px wide region isn't.
+func (c Constraint) ForRegion(region image.Rectangle, w, h int) Constraint {
+	var rx = float64(region.Dx()) / float64(w)
+	var ry = float64(region.Dy()) / float64(h)
+	if rx > 1 {
+		rx = 1
+	}
+	if ry > 1 {
+		ry = 1
+	}
+
+	var out = c
+	if c.Width < math.MaxInt32 {
+		out.Width = int(float64(c.Width) * rx)
+	}
+	if c.Height < math.MaxInt32 {
+		out.Height = int(float64(c.Height) * ry)
+	}
+	if c.Area < math.MaxInt64 {
+		out.Area = int64(float64(c.Area) * rx * ry)
+	}
+	return out
+}
@@ -0,0 +1,59 @@
+package img
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestConstraintMin(t *testing.T) {
+	var c = Constraint{Width: 800, Height: math.MaxInt32, Area: 1000000}
+	var c2 = Constraint{Width: 1000, Height: 600, Area: math.MaxInt64}
+	var expected = Constraint{Width: 800, Height: 600, Area: 1000000}
+	if diff := cmp.Diff(expected, c.Min(c2)); diff != "" {
+		t.Errorf("Min: %s", diff)
+	}
+	if diff := cmp.Diff(expected, c2.Min(c)); diff != "" {
+		t.Errorf("Min is commutative: %s", diff)
+	}
+}
+
+func TestConstraintForRegion(t *testing.T) {
+	var tests = map[string]struct {
+		c        Constraint
+		region   image.Rectangle
+		expected Constraint
+	}{
+		"full region": {
+			c:        Constraint{Width: 800, Height: 800, Area: 640000},
+			region:   image.Rect(0, 0, 4000, 2000),
+			expected: Constraint{Width: 800, Height: 800, Area: 640000},
+		},
+		"quarter region": {
+			c:        Constraint{Width: 800, Height: 800, Area: 640000},
+			region:   image.Rect(2000, 1000, 3000, 2000),
+			expected: Constraint{Width: 200, Height: 400, Area: 80000},
+		},
+		"unlimited values stay unlimited": {
+			c:        Constraint{Width: 800, Height: math.MaxInt32, Area: math.MaxInt64},
+			region:   image.Rect(0, 0, 1000, 1000),
+			expected: Constraint{Width: 200, Height: math.MaxInt32, Area: math.MaxInt64},
+		},
+		"region larger than image": {
+			c:        Constraint{Width: 800, Height: 800, Area: 640000},
+			region:   image.Rect(0, 0, 8000, 4000),
+			expected: Constraint{Width: 800, Height: 800, Area: 640000},
+		},
+	}
+
+	for name, tc := range tests {
+		t.Run(name, func(t *testing.T) {
+			var got = tc.c.ForRegion(tc.region, 4000, 2000)
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("ForRegion: %s", diff)
+			}
+		})
+	}
+}
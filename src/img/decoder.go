@@ -18,6 +18,22 @@ type Decoder interface {
 	SetResizeWH(int, int)
 }
 
+// ResolutionDecoder is an optional interface for decoders which can read the
+// source image's resolution.  Values are in pixels per inch, and are zero if
+// the image doesn't specify a resolution.
+type ResolutionDecoder interface {
+	GetResolution() (x, y float64)
+}
+
+// PyramidDecoder is an optional interface for decoders which can read the
+// image at reduced resolutions, each half the size of the one before, without
+// decoding it at full size.  GetReductions returns how many reduced
+// resolutions there are.  Images from other decoders are assumed to have only
+// their full resolution.
+type PyramidDecoder interface {
+	GetReductions() int
+}
+
 // DecodeHandler is a function which takes a Streamer and returns a DecodeFunc and
 // optionally an error.  If the error is ErrSkipped, the function is stating
 // that it doesn't handle images the Streamer describes (typically just a brief
@@ -13,4 +13,5 @@ func (re imgError) Error() string {
 	ErrInvalidFiletype        imgError = "invalid or unknown file type"
 	ErrDimensionsExceedLimits imgError = "requested image size exceeds server maximums"
 	ErrNotStreamable          imgError = "no registered streamers"
+	ErrUpscaleNotRequested    imgError = `requested size is larger than the region and must use the "^" prefix`
 )
@@ -13,6 +13,12 @@
 	"rais/src/transform"
 )
 
+// RotationBackground is the color used to fill the space around an image
+// rotated by a non-multiple of 90 degrees when the output format can't
+// represent transparency.  Formats which can are given a transparent
+// background instead.
+var RotationBackground color.Color = color.White
+
 // Resource wraps a streamer and decode function, the two components we must
 // have for any image, as well as the image ID and URL.  The actual decoder is
 // lazy-loaded when it's needed.
@@ -88,18 +94,22 @@ func getDecodeFunc(s Streamer) (d DecodeFunc, err error) {
 }
 
 // getResizeWithConstraints returns a scaled rectangle, computing the best fit
-// for the given dimensions combined with our local constraints
-func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectangle {
+// for the given dimensions combined with our local constraints.  Unless
+// upscale is true, the returned rectangle will never be larger than crop.
+func getResizeWithConstraints(crop image.Rectangle, max Constraint, upscale bool) image.Rectangle {
 	// First figure out the ideal width and height within our max width and height
 	cx := crop.Dx()
 	cy := crop.Dy()
 
-	// Sanity - we don't actually want any upscaling
-	if max.Width > cx {
-		max.Width = cx
-	}
-	if max.Height > cy {
-		max.Height = cy
+	// Sanity - we don't actually want any upscaling unless it was explicitly
+	// requested, and even then we can't upscale to "infinity"
+	if !upscale || max.Unlimited() {
+		if max.Width > cx {
+			max.Width = cx
+		}
+		if max.Height > cy {
+			max.Height = cy
+		}
 	}
 
 	s := iiif.Size{Type: iiif.STBestFit, W: max.Width, H: max.Height}
@@ -108,15 +118,18 @@ func getResizeWithConstraints(crop image.Rectangle, max Constraint) image.Rectan
 	sy := scale.Dy()
 
 	// If this is within the bounds of our max area, we can return, otherwise we
-	// have to scale further
-	area := int64(sx) * int64(sy)
-	if area <= max.Area {
+	// have to scale further.  We use floats here since an upscale with no width
+	// or height limit could overflow an int64.  The area-based scale is
+	// computed from the crop itself: the best fit preserves its aspect ratio,
+	// and this avoids compounding rounding errors when upscaling.
+	area := float64(sx) * float64(sy)
+	if area <= float64(max.Area) {
 		return scale
 	}
 
-	mult := math.Sqrt(float64(max.Area) / float64(area))
-	xf := mult * float64(sx)
-	yf := mult * float64(sy)
+	mult := math.Sqrt(float64(max.Area) / (float64(cx) * float64(cy)))
+	xf := mult * float64(cx)
+	yf := mult * float64(cy)
 	return image.Rect(0, 0, int(xf), int(yf))
 }
 
@@ -149,21 +162,13 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	// Crop and resize have to be prepared before we can decode
 	w, h := decoder.GetWidth(), decoder.GetHeight()
 	crop := u.Region.GetCrop(w, h)
-
-	// If size is "max", we actually want the "best fit" size type, but with our
-	// constraints used instead of a user-supplied value.
-	var scale image.Rectangle
-	if u.Size.Type == iiif.STMax {
-		scale = getResizeWithConstraints(crop, max)
-	} else {
-		scale = u.Size.GetResize(crop)
+	scale, err := GetScale(u, crop, max)
+	if err != nil {
+		return nil, err
 	}
 
 	// Determine the final image output dimensions to test size constraints
-	sw, sh := scale.Dx(), scale.Dy()
-	if u.Rotation.Degrees == 90 || u.Rotation.Degrees == 270 {
-		sw, sh = sh, sw
-	}
+	sw, sh := transform.RotatedBounds(scale.Dx(), scale.Dy(), u.Rotation.Degrees)
 	if max.SmallerThanAny(sw, sh) {
 		return nil, ErrDimensionsExceedLimits
 	}
@@ -177,7 +182,11 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	}
 
 	if u.Rotation.Mirror || u.Rotation.Degrees != 0 {
-		img = rotate(img, u.Rotation)
+		var bg = RotationBackground
+		if u.Format.SupportsAlpha() {
+			bg = color.Transparent
+		}
+		img = rotate(img, u.Rotation, bg)
 	}
 
 	// Unless I'm missing something, QColor doesn't actually change an image -
@@ -193,13 +202,47 @@ func (res *Resource) Apply(u *iiif.URL, max Constraint) (image.Image, error) {
 	return img, nil
 }
 
-func rotate(img image.Image, rot iiif.Rotation) image.Image {
+// GetScale returns the dimensions the cropped region will be resized to for
+// the given request, before any rotation is applied
+func GetScale(u *iiif.URL, crop image.Rectangle, max Constraint) (image.Rectangle, error) {
+	var size = u.Size
+	switch {
+	// If size is "max", we actually want the "best fit" size type, but with our
+	// constraints used instead of a user-supplied value.
+	case size.Type == iiif.STMax:
+		return getResizeWithConstraints(crop, max, size.Upscale), nil
+
+	// IIIF 3.0 best-fit requests without the upscale prefix must not be larger
+	// than the region, so we simply shrink the box the image must fit
+	case u.Version == iiif.V3 && size.Type == iiif.STBestFit && !size.Upscale:
+		if size.W > crop.Dx() {
+			size.W = crop.Dx()
+		}
+		if size.H > crop.Dy() {
+			size.H = crop.Dy()
+		}
+		return size.GetResize(crop), nil
+
+	// All other IIIF 3.0 sizes are an error if they upscale without the prefix
+	case u.Version == iiif.V3 && !size.Upscale && size.Upscales(crop):
+		return image.ZR, ErrUpscaleNotRequested
+	}
+
+	return size.GetResize(crop), nil
+}
+
+func rotate(img image.Image, rot iiif.Rotation, bg color.Color) image.Image {
 	var r transform.Rotator
 	switch img0 := img.(type) {
 	case *image.Gray:
 		r = &transform.GrayRotator{Img: img0}
 	case *image.RGBA:
 		r = &transform.RGBARotator{Img: img0}
+	default:
+		var b = img.Bounds()
+		var img1 = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(img1, img1.Rect, img, b.Min, draw.Src)
+		r = &transform.RGBARotator{Img: img1}
 	}
 
 	if rot.Mirror {
@@ -207,17 +250,26 @@ func rotate(img image.Image, rot iiif.Rotation) image.Image {
 	}
 
 	switch rot.Degrees {
+	case 0:
 	case 90:
 		r.Rotate90()
 	case 180:
 		r.Rotate180()
 	case 270:
 		r.Rotate270()
+	default:
+		return transform.Rotate(r.Image(), rot.Degrees, bg)
 	}
 
 	return r.Image()
 }
 
+// opaque returns false if the image reports that it has transparent pixels
+func opaque(img image.Image) bool {
+	var o, ok = img.(interface{ Opaque() bool })
+	return !ok || o.Opaque()
+}
+
 func grayscale(img image.Image) image.Image {
 	cm := img.ColorModel()
 	if cm == color.GrayModel || cm == color.Gray16Model {
@@ -225,20 +277,57 @@ func grayscale(img image.Image) image.Image {
 	}
 
 	b := img.Bounds()
+	if !opaque(img) {
+		return grayscaleAlpha(img, nil)
+	}
+
 	dst := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
 	draw.Draw(dst, b, img, b.Min, draw.Src)
 	return dst
 }
 
+// grayscaleAlpha converts img to gray while preserving its alpha channel.  Go
+// has no gray-plus-alpha image type, so the result is an RGBA with identical
+// color channels.  If threshold isn't nil, it's run on each (non-premultiplied)
+// gray value, which lets bitonal conversion share this logic.
+func grayscaleAlpha(img image.Image, threshold func(uint8) uint8) *image.RGBA {
+	b := img.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+
+	for i := 0; i < len(dst.Pix); i += 4 {
+		var p = dst.Pix[i : i+4 : i+4]
+		var y = color.GrayModel.Convert(color.RGBA{p[0], p[1], p[2], 255}).(color.Gray).Y
+		if threshold != nil && p[3] > 0 {
+			// Threshold against the real gray value, then premultiply again
+			var unmul = uint8(uint32(y) * 255 / uint32(p[3]))
+			y = uint8(uint32(threshold(unmul)) * uint32(p[3]) / 255)
+		}
+		p[0], p[1], p[2] = y, y, y
+	}
+
+	return dst
+}
+
+// bitonalThreshold is the cutoff for a gray value to be considered white
+func bitonalThreshold(v uint8) uint8 {
+	if v > 190 {
+		return 255
+	}
+	return 0
+}
+
 func bitonal(img image.Image) image.Image {
+	if !opaque(img) {
+		return grayscaleAlpha(img, bitonalThreshold)
+	}
+
 	// First turn the image into 8-bit grayscale for easier manipulation
 	imgGray := grayscale(img).(*image.Gray)
 	b := imgGray.Bounds()
 	imgBitonal := image.NewGray(image.Rect(0, 0, b.Dx(), b.Dy()))
 	for i, pixel := range imgGray.Pix {
-		if pixel > 190 {
-			imgBitonal.Pix[i] = 255
-		}
+		imgBitonal.Pix[i] = bitonalThreshold(pixel)
 	}
 
 	return imgBitonal
@@ -115,3 +115,67 @@ func TestMaxSizeConstrainArea(t *testing.T) {
 	assert.Equal(500, d.resizeW, "resize width", t)
 	assert.Equal(75, d.resizeH, "resize height", t)
 }
+
+func TestV3UpscaleWithoutPrefix(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 200, tw: 128, th: 128, l: 4}
+	var img = &Resource{decoder: d}
+	var url, _ = iiif.NewVersionedURL("identifier/full/800,/0/default.jpg", iiif.V3)
+	var _, err = img.Apply(url, unlimited)
+	assert.Equal(ErrUpscaleNotRequested, err, "upscaling without a caret is an error", t)
+
+	url, _ = iiif.NewURL("identifier/full/800,/0/default.jpg")
+	_, err = img.Apply(url, unlimited)
+	assert.True(err == nil, "2.x requests may upscale", t)
+	assert.Equal(800, d.resizeW, "resize width", t)
+	assert.Equal(400, d.resizeH, "resize height", t)
+}
+
+func TestV3Upscale(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 200, tw: 128, th: 128, l: 4}
+	var img = &Resource{decoder: d}
+	var url, _ = iiif.NewVersionedURL("identifier/full/^800,/0/default.jpg", iiif.V3)
+	var _, err = img.Apply(url, unlimited)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(800, d.resizeW, "resize width", t)
+	assert.Equal(400, d.resizeH, "resize height", t)
+}
+
+func TestV3BestFitNoUpscale(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 200, tw: 128, th: 128, l: 4}
+	var img = &Resource{decoder: d}
+	var url, _ = iiif.NewVersionedURL("identifier/full/!1000,1000/0/default.jpg", iiif.V3)
+	var _, err = img.Apply(url, unlimited)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(400, d.resizeW, "best fit is limited to the region's width", t)
+	assert.Equal(200, d.resizeH, "best fit is limited to the region's height", t)
+
+	url, _ = iiif.NewVersionedURL("identifier/full/^!1000,1000/0/default.jpg", iiif.V3)
+	_, err = img.Apply(url, unlimited)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(1000, d.resizeW, "upscaled best fit width", t)
+	assert.Equal(500, d.resizeH, "upscaled best fit height", t)
+}
+
+func TestV3UpscaleMax(t *testing.T) {
+	var d = &fakeDecoder{w: 400, h: 200, tw: 128, th: 128, l: 4}
+	var img = &Resource{decoder: d}
+	var url, _ = iiif.NewVersionedURL("identifier/full/^max/0/default.jpg", iiif.V3)
+	var _, err = img.Apply(url, unlimited)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(400, d.resizeW, "no constraints means no upscale", t)
+	assert.Equal(200, d.resizeH, "no constraints means no upscale", t)
+
+	var c = unlimited
+	c.Width = 1000
+	_, err = img.Apply(url, c)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(1000, d.resizeW, "upscaled to max width", t)
+	assert.Equal(500, d.resizeH, "upscaled height", t)
+
+	c = unlimited
+	c.Area = 320000
+	_, err = img.Apply(url, c)
+	assert.True(err == nil, "img.Apply should not have errors", t)
+	assert.Equal(800, d.resizeW, "upscaled to max area", t)
+	assert.Equal(400, d.resizeH, "upscaled to max area", t)
+}
@@ -32,6 +32,11 @@ type Info struct {
 	ColorSpace   ColorSpace
 	Prec, Approx uint8
 
+	// Resolution in pixels per inch, from the capture resolution box if
+	// present, otherwise the default display resolution box.  Zero values mean
+	// the image doesn't specify a resolution.
+	XRes, YRes float64
+
 	// From SIZ box - this data can replace the main header data and
 	// some of the colorspace data if necessary
 	LSiz, RSiz     uint16
@@ -6,6 +6,7 @@
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"os"
 )
 
@@ -23,6 +24,10 @@
 	COLR   = []byte{0x63, 0x6f, 0x6c, 0x72} // "colr"
 	SOCSIZ = []byte{0xFF, 0x4F, 0xFF, 0x51}
 	COD    = []byte{0xFF, 0x52}
+	RES    = []byte{0x72, 0x65, 0x73, 0x20} // "res "
+	RESC   = []byte{0x72, 0x65, 0x73, 0x63} // "resc"
+	RESD   = []byte{0x72, 0x65, 0x73, 0x64} // "resd"
+	JP2C   = []byte{0x6a, 0x70, 0x32, 0x63} // "jp2c"
 )
 
 // Scanner reads a Jpeg2000 header and parsing its data into an Info structure
@@ -70,6 +75,9 @@ func (s *Scanner) readInfo(ior io.Reader) {
 	s.scanUntil(COLR)
 	s.readColor()
 
+	// The resolution box is optional, so we peek for it rather than scanning
+	s.peekResolution()
+
 	// Find various SIZ data
 	s.scanUntil(SOCSIZ)
 	s.readBE(&s.i.LSiz, &s.i.RSiz, &s.i.XSiz, &s.i.YSiz, &s.i.XOSiz,
@@ -111,6 +119,63 @@ func (s *Scanner) readColorProfile() {
 	s.i.ColorSpace = CSUnknown
 }
 
+// peekResolution looks for a resolution box in the buffered data which
+// precedes the codestream, without consuming it.  Resolution boxes are tiny
+// and live in the JP2 header, so in practice they'll always be buffered.
+func (s *Scanner) peekResolution() {
+	if s.e != nil {
+		return
+	}
+
+	// Peek returns an error if the file is smaller than the buffer, which is
+	// fine; we just search what we got
+	var data, _ = s.r.Peek(s.r.Size())
+	if i := bytes.Index(data, JP2C); i >= 0 {
+		data = data[:i]
+	}
+
+	var i = bytes.Index(data, RES)
+	if i < 4 {
+		return
+	}
+	var resLen = int(binary.BigEndian.Uint32(data[i-4:]))
+	if resLen < 8 || i-4+resLen > len(data) {
+		return
+	}
+
+	// Walk the res box's children; capture resolution wins over display
+	// resolution, since it describes the physical source
+	var children = data[i+4 : i-4+resLen]
+	for len(children) >= 18 {
+		var boxLen = int(binary.BigEndian.Uint32(children))
+		if boxLen < 18 || boxLen > len(children) {
+			return
+		}
+
+		var boxType = children[4:8]
+		if bytes.Equal(boxType, RESC) || (bytes.Equal(boxType, RESD) && s.i.XRes == 0) {
+			s.i.YRes, s.i.XRes = readResolution(children[8:18])
+		}
+		children = children[boxLen:]
+	}
+}
+
+// readResolution converts the raw data from a resc or resd box into vertical
+// and horizontal pixels per inch.  JP2 resolutions are pixels per metre,
+// stored as (N/D) * 10^E.
+func readResolution(data []byte) (float64, float64) {
+	var ppi = func(n, d uint16, e int8) float64 {
+		if d == 0 {
+			return 0
+		}
+		return float64(n) / float64(d) * math.Pow10(int(e)) * 0.0254
+	}
+
+	var vn, vd = binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+	var hn, hd = binary.BigEndian.Uint16(data[4:]), binary.BigEndian.Uint16(data[6:])
+	return ppi(vn, vd, int8(data[8])), ppi(hn, hd, int8(data[9]))
+}
+
 // scanUntil reads until the given token has been found and fully read
 // in, leaving the io pointer exactly one byte past the token
 func (s *Scanner) scanUntil(token []byte) {
@@ -0,0 +1,103 @@
+package openjpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// ErrNoHeaderBox is returned when JP2 data has no header box for us to add
+// to, which means openjpeg gave us something other than a JP2
+var ErrNoHeaderBox = errors.New("openjpeg: JP2 header box not found")
+
+// jp2 box types we need for writing resolution data
+var (
+	boxJP2Header  = [4]byte{'j', 'p', '2', 'h'}
+	boxResolution = [4]byte{'r', 'e', 's', ' '}
+	boxCaptureRes = [4]byte{'r', 'e', 's', 'c'}
+)
+
+// resBoxDataSize is the size of a "resc" or "resd" box, excluding its header
+const resBoxDataSize = 10
+
+// insertResolutionBox returns a copy of the JP2 data with a resolution
+// superbox, holding a capture resolution box for ppi pixels per inch, appended
+// to the JP2 header box.  openjpeg has no way to write resolution boxes, so we
+// have to patch them in after encoding.
+func insertResolutionBox(data []byte, ppi float64) ([]byte, error) {
+	var start, end = findBox(data, boxJP2Header)
+	if start < 0 {
+		return nil, ErrNoHeaderBox
+	}
+
+	var res = resolutionBox(ppi)
+	var out = make([]byte, 0, len(data)+len(res))
+	out = append(out, data[:end]...)
+	out = append(out, res...)
+	out = append(out, data[end:]...)
+
+	var headerLen = binary.BigEndian.Uint32(out[start:])
+	binary.BigEndian.PutUint32(out[start:], headerLen+uint32(len(res)))
+	return out, nil
+}
+
+// findBox walks the top-level boxes in data, returning the start and end
+// offsets of the first box of type t, or -1 for both if it isn't found
+func findBox(data []byte, t [4]byte) (int, int) {
+	var pos = 0
+	for pos+8 <= len(data) {
+		var size = int(binary.BigEndian.Uint32(data[pos:]))
+		var hdr = 8
+		switch size {
+		case 0:
+			// The box runs to the end of the file
+			size = len(data) - pos
+		case 1:
+			// Extended length; we don't expect these outside the codestream
+			if pos+16 > len(data) {
+				return -1, -1
+			}
+			size = int(binary.BigEndian.Uint64(data[pos+8:]))
+			hdr = 16
+		}
+		if size < hdr || pos+size > len(data) {
+			return -1, -1
+		}
+
+		if data[pos+4] == t[0] && data[pos+5] == t[1] && data[pos+6] == t[2] && data[pos+7] == t[3] {
+			return pos, pos + size
+		}
+		pos += size
+	}
+
+	return -1, -1
+}
+
+// resolutionBox builds a "res " superbox containing a "resc" box.  The JP2
+// format stores resolution as pixels per metre in the form (N/D) * 10^E, so we
+// use a denominator of 254 and pick the exponent which keeps the numerator
+// within 16 bits.
+func resolutionBox(ppi float64) []byte {
+	var e = 2
+	var n = ppi * 100
+	for n > math.MaxUint16 {
+		n /= 10
+		e++
+	}
+	var num = uint16(math.Round(n))
+
+	var resc = make([]byte, 8+resBoxDataSize)
+	binary.BigEndian.PutUint32(resc, uint32(len(resc)))
+	copy(resc[4:], boxCaptureRes[:])
+	binary.BigEndian.PutUint16(resc[8:], num)
+	binary.BigEndian.PutUint16(resc[10:], 254)
+	binary.BigEndian.PutUint16(resc[12:], num)
+	binary.BigEndian.PutUint16(resc[14:], 254)
+	resc[16] = byte(int8(e))
+	resc[17] = byte(int8(e))
+
+	var res = make([]byte, 8, 8+len(resc))
+	binary.BigEndian.PutUint32(res, uint32(8+len(resc)))
+	copy(res[4:], boxResolution[:])
+	return append(res, resc...)
+}
@@ -0,0 +1,203 @@
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <openjpeg.h>
+// #include <stdlib.h>
+// #include "handlers.h"
+// #include "stream.h"
+import "C"
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+// maxResolutions is the number of resolution levels we try to encode, which
+// matches openjpeg's default.  Small images get fewer levels, since each level
+// halves the dimensions.
+const maxResolutions = 6
+
+// DefaultRate is the compression ratio used for lossy encoding when no rate
+// is specified
+const DefaultRate = 20
+
+// ErrEncodeEmpty is returned when asked to encode an image with no pixels
+var ErrEncodeEmpty = errors.New("openjpeg: cannot encode an empty image")
+
+// EncodeOptions tells Encode how to compress the JP2
+type EncodeOptions struct {
+	// Lossless uses the reversible 5-3 wavelet and no rate control, so the
+	// decoded image is identical to the source.  Rate is ignored.
+	Lossless bool
+
+	// Rate is the target compression ratio for lossy encoding, e.g., 20 for
+	// 20:1.  Values below 1 fall back to DefaultRate.
+	Rate float32
+
+	// Resolution, when above zero, is the image's capture resolution in pixels
+	// per inch, and is written to the JP2's resolution box
+	Resolution float64
+}
+
+// Encode writes m to w as a JP2.  Grayscale images are written with a single
+// component; everything else is written as sRGB, with an alpha channel if m
+// isn't fully opaque.  A nil options value results in a lossless encode.
+func Encode(w io.Writer, m image.Image, o *EncodeOptions) error {
+	if o == nil {
+		o = &EncodeOptions{Lossless: true}
+	}
+	if m.Bounds().Empty() {
+		return ErrEncodeEmpty
+	}
+
+	var jp2 = newOPJImage(m)
+	defer C.opj_image_destroy(jp2)
+
+	var data, err = rawEncode(jp2, o)
+	if err != nil {
+		return err
+	}
+
+	if o.Resolution > 0 {
+		data, err = insertResolutionBox(data, o.Resolution)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// rawEncode runs the low-level openjpeg compression of jp2, returning the
+// encoded file's bytes
+func rawEncode(jp2 *C.opj_image_t, o *EncodeOptions) ([]byte, error) {
+	var parameters C.opj_cparameters_t
+	C.opj_set_default_encoder_parameters(&parameters)
+
+	parameters.tcp_numlayers = 1
+	parameters.cp_disto_alloc = 1
+	parameters.tcp_rates[0] = 0
+	if !o.Lossless {
+		parameters.irreversible = 1
+		parameters.tcp_rates[0] = C.float(o.Rate)
+		if o.Rate < 1 {
+			parameters.tcp_rates[0] = DefaultRate
+		}
+	}
+	if jp2.numcomps >= 3 {
+		parameters.tcp_mct = 1
+	}
+
+	var w, h = int(jp2.x1), int(jp2.y1)
+	parameters.numresolution = maxResolutions
+	for parameters.numresolution > 1 && (w>>uint(parameters.numresolution-1) == 0 || h>>uint(parameters.numresolution-1) == 0) {
+		parameters.numresolution--
+	}
+
+	var codec = C.opj_create_compress(C.OPJ_CODEC_JP2)
+	defer C.opj_destroy_codec(codec)
+	C.set_handlers(codec)
+
+	if C.opj_setup_encoder(codec, &parameters, jp2) == C.OPJ_FALSE {
+		return nil, fmt.Errorf("unable to setup encoder")
+	}
+
+	var buf = newOutputBuffer()
+	var stream = C.new_output_stream(C.OPJ_UINT64(1024*64), C.OPJ_UINT64(buf.id))
+	if stream == nil {
+		freeOutputStream(buf.id)
+		return nil, fmt.Errorf("failed to create output stream")
+	}
+	defer C.opj_stream_destroy(stream)
+
+	if C.opj_start_compress(codec, jp2, stream) == C.OPJ_FALSE ||
+		C.opj_encode(codec, stream) == C.OPJ_FALSE ||
+		C.opj_end_compress(codec, stream) == C.OPJ_FALSE {
+		return nil, fmt.Errorf("failed to encode image")
+	}
+
+	return buf.data, nil
+}
+
+// newOPJImage allocates an openjpeg image and copies m's pixel data into it.
+// The caller must destroy the image.
+func newOPJImage(m image.Image) *C.opj_image_t {
+	var b = m.Bounds()
+	var w, h = b.Dx(), b.Dy()
+
+	var numcomps = 1
+	var colorSpace C.OPJ_COLOR_SPACE = C.OPJ_CLRSPC_GRAY
+	var gray, isGray = m.(*image.Gray)
+	var rgba *image.NRGBA
+	if !isGray {
+		rgba = image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(rgba, rgba.Rect, m, b.Min, draw.Src)
+		numcomps = 3
+		colorSpace = C.OPJ_CLRSPC_SRGB
+		if !rgba.Opaque() {
+			numcomps = 4
+		}
+	}
+
+	var params = make([]C.opj_image_cmptparm_t, numcomps)
+	for i := range params {
+		params[i].dx = 1
+		params[i].dy = 1
+		params[i].w = C.OPJ_UINT32(w)
+		params[i].h = C.OPJ_UINT32(h)
+		params[i].prec = 8
+		params[i].bpp = 8
+		params[i].sgnd = 0
+	}
+
+	var jp2 = C.opj_image_create(C.OPJ_UINT32(numcomps), &params[0], colorSpace)
+	jp2.x0, jp2.y0 = 0, 0
+	jp2.x1, jp2.y1 = C.OPJ_UINT32(w), C.OPJ_UINT32(h)
+
+	var comps []C.opj_image_comp_t
+	var compsSlice = (*reflect.SliceHeader)(unsafe.Pointer(&comps))
+	compsSlice.Cap = numcomps
+	compsSlice.Len = numcomps
+	compsSlice.Data = uintptr(unsafe.Pointer(jp2.comps))
+
+	if isGray {
+		var dst = componentSlice(comps[0])
+		for y := 0; y < h; y++ {
+			var row = gray.Pix[gray.PixOffset(b.Min.X, b.Min.Y+y):]
+			for x := 0; x < w; x++ {
+				dst[y*w+x] = C.OPJ_INT32(row[x])
+			}
+		}
+		return jp2
+	}
+
+	if numcomps == 4 {
+		comps[3].alpha = 1
+	}
+	for c := 0; c < numcomps; c++ {
+		var dst = componentSlice(comps[c])
+		for i := range dst {
+			dst[i] = C.OPJ_INT32(rgba.Pix[i<<2+c])
+		}
+	}
+
+	return jp2
+}
+
+// componentSlice returns the component's C-allocated data as a Go slice
+func componentSlice(comp C.opj_image_comp_t) []C.OPJ_INT32 {
+	var data []C.OPJ_INT32
+	var dataSlice = (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	var size = int(comp.w) * int(comp.h)
+	dataSlice.Cap = size
+	dataSlice.Len = size
+	dataSlice.Data = uintptr(unsafe.Pointer(comp.data))
+
+	return data
+}
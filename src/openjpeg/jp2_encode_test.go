@@ -0,0 +1,128 @@
+package openjpeg
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"io/ioutil"
+	"math"
+	"os"
+	"rais/src/img"
+	"rais/src/jp2info"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+// roundTrip encodes m with the given options, then decodes the result
+func roundTrip(m image.Image, o *EncodeOptions, t *testing.T) ([]byte, image.Image) {
+	var buf bytes.Buffer
+	var err = Encode(&buf, m, o)
+	assert.NilError(err, "encoding the image", t)
+
+	var f, _ = ioutil.TempFile("", "rais-jp2-encode-*.jp2")
+	defer os.Remove(f.Name())
+	f.Write(buf.Bytes())
+	f.Close()
+
+	var s img.Streamer
+	s, err = img.NewFileStream(f.Name())
+	assert.NilError(err, "opening the encoded file", t)
+	var jp2 *JP2Image
+	jp2, err = NewJP2Image(s)
+	assert.NilError(err, "reading the encoded file", t)
+	var decoded image.Image
+	decoded, err = jp2.DecodeImage()
+	assert.NilError(err, "decoding the encoded file", t)
+
+	return buf.Bytes(), decoded
+}
+
+func TestEncodeLossless(t *testing.T) {
+	var src, err = jp2i().DecodeImage()
+	assert.NilError(err, "decoding the source image", t)
+	var rgba = src.(*image.RGBA)
+	var sub = rgba.SubImage(image.Rect(100, 50, 300, 150))
+
+	var _, decoded = roundTrip(sub, &EncodeOptions{Lossless: true}, t)
+	assert.Equal(200, decoded.Bounds().Dx(), "decoded width", t)
+	assert.Equal(100, decoded.Bounds().Dy(), "decoded height", t)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if rgba.RGBAAt(x+100, y+50) != decoded.(*image.RGBA).RGBAAt(x, y) {
+				t.Fatalf("pixel at %d,%d differs after lossless encoding", x, y)
+			}
+		}
+	}
+}
+
+func TestEncodeLossy(t *testing.T) {
+	var src, err = jp2i().DecodeImage()
+	assert.NilError(err, "decoding the source image", t)
+
+	var lossless, _ = roundTrip(src, &EncodeOptions{Lossless: true}, t)
+	var lossy, decoded = roundTrip(src, &EncodeOptions{Rate: 40}, t)
+	assert.Equal(800, decoded.Bounds().Dx(), "decoded width", t)
+	assert.True(len(lossy) < len(lossless), "lossy encoding is smaller", t)
+}
+
+func TestEncodeGray(t *testing.T) {
+	var m = image.NewGray(image.Rect(0, 0, 40, 20))
+	for i := range m.Pix {
+		m.Pix[i] = uint8(i)
+	}
+
+	var data, decoded = roundTrip(m, nil, t)
+	var gray, ok = decoded.(*image.Gray)
+	assert.True(ok, "grayscale image decodes as grayscale", t)
+	assert.Equal(m.GrayAt(17, 9), gray.GrayAt(17, 9), "pixel data", t)
+
+	// Enumerated colourspace 17 is greyscale
+	assert.True(bytes.Contains(data, []byte{'c', 'o', 'l', 'r', 1, 0, 0, 0, 0, 0, 17}), "colr box", t)
+}
+
+func TestEncodeAlpha(t *testing.T) {
+	var m = image.NewRGBA(image.Rect(0, 0, 40, 20))
+	m.Set(5, 5, color.RGBA{255, 0, 0, 255})
+
+	var data, _ = roundTrip(m, nil, t)
+	assert.True(bytes.Contains(data, []byte("cdef")), "alpha gets a channel definition box", t)
+	assert.True(bytes.Contains(data, []byte{'c', 'o', 'l', 'r', 1, 0, 0, 0, 0, 0, 16}), "sRGB colr box", t)
+}
+
+func TestEncodeResolution(t *testing.T) {
+	var m = image.NewGray(image.Rect(0, 0, 40, 20))
+	var data, decoded = roundTrip(m, &EncodeOptions{Lossless: true, Resolution: 400}, t)
+	assert.Equal(40, decoded.Bounds().Dx(), "file still decodes", t)
+
+	var start, end = findBox(data, boxJP2Header)
+	assert.True(start >= 0, "header box exists", t)
+	var header = data[start:end]
+	var resStart = bytes.Index(header, []byte("res "))
+	assert.True(resStart > 0, "resolution box is in the header box", t)
+	assert.Equal(end-start, resStart-4+26, "resolution box is the last box in the header", t)
+
+	var info, err = new(jp2info.Scanner).ScanStream(bytes.NewReader(data))
+	assert.NilError(err, "scanning the encoded file", t)
+	assert.True(math.Abs(info.XRes-400) < 0.01, "jp2info reads the horizontal resolution", t)
+	assert.True(math.Abs(info.YRes-400) < 0.01, "jp2info reads the vertical resolution", t)
+}
+
+func TestResolutionBox(t *testing.T) {
+	var box = resolutionBox(300)
+	var expected = []byte{
+		0, 0, 0, 26, 'r', 'e', 's', ' ',
+		0, 0, 0, 18, 'r', 'e', 's', 'c',
+		0x75, 0x30, 0, 254, 0x75, 0x30, 0, 254, 2, 2,
+	}
+	assert.True(bytes.Equal(expected, box), "300 ppi", t)
+
+	box = resolutionBox(1200)
+	assert.Equal(byte(12000>>8), box[16], "1200 ppi numerator is scaled down", t)
+	assert.Equal(byte(3), box[24], "1200 ppi exponent is scaled up", t)
+}
+
+func TestInsertResolutionBoxNoHeader(t *testing.T) {
+	var _, err = insertResolutionBox([]byte{0, 0, 0, 8, 'j', 'P', ' ', ' '}, 300)
+	assert.Equal(ErrNoHeaderBox, err, "no jp2h box", t)
+}
@@ -145,6 +145,18 @@ func (i *JP2Image) GetLevels() int {
 	return int(i.info.Levels)
 }
 
+// GetReductions returns the number of reduced resolutions the image can be
+// decoded at, which is the same as its number of DWT decomposition levels
+func (i *JP2Image) GetReductions() int {
+	return int(i.info.Levels)
+}
+
+// GetResolution returns the horizontal and vertical resolution, in pixels per
+// inch, if the JP2 has a resolution box
+func (i *JP2Image) GetResolution() (x, y float64) {
+	return i.info.XRes, i.info.YRes
+}
+
 // computeDecodeParameters sets up decode area, decode width, and decode height
 // based on the image's info
 func (i *JP2Image) computeDecodeParameters() {
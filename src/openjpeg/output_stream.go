@@ -0,0 +1,116 @@
+package openjpeg
+
+// #cgo pkg-config: libopenjp2
+// #include <openjpeg.h>
+import "C"
+import (
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// outputBuffer is an in-memory, seekable destination for encoded JP2 data.
+// openjpeg has to seek backwards to fill in box lengths once it knows them,
+// so we can't stream directly to an io.Writer.
+type outputBuffer struct {
+	id   uint64
+	data []byte
+	pos  int64
+}
+
+// As with images, openjpeg's write callbacks only give us an id, so we need
+// a lookup for the buffers being written
+var nextOutputID uint64
+var outputs = make(map[uint64]*outputBuffer)
+var outputMutex sync.RWMutex
+
+// newOutputBuffer creates an outputBuffer and indexes it so opj streaming
+// functions can find it
+func newOutputBuffer() *outputBuffer {
+	var b = new(outputBuffer)
+	outputMutex.Lock()
+	nextOutputID++
+	b.id = nextOutputID
+	outputs[b.id] = b
+	outputMutex.Unlock()
+
+	return b
+}
+
+func lookupOutput(id uint64) (*outputBuffer, bool) {
+	outputMutex.RLock()
+	var b, ok = outputs[id]
+	outputMutex.RUnlock()
+
+	return b, ok
+}
+
+// write copies p into the buffer at the current position, growing the buffer
+// as needed
+func (b *outputBuffer) write(p []byte) {
+	var end = b.pos + int64(len(p))
+	if end > int64(len(b.data)) {
+		if end > int64(cap(b.data)) {
+			var grown = make([]byte, end, end*2)
+			copy(grown, b.data)
+			b.data = grown
+		}
+		b.data = b.data[:end]
+	}
+	copy(b.data[b.pos:], p)
+	b.pos = end
+}
+
+//export freeOutputStream
+func freeOutputStream(id uint64) {
+	outputMutex.Lock()
+	delete(outputs, id)
+	outputMutex.Unlock()
+}
+
+//export opjOutputStreamWrite
+func opjOutputStreamWrite(readBuffer unsafe.Pointer, numBytes C.OPJ_SIZE_T, id uint64) C.OPJ_SIZE_T {
+	var b, ok = lookupOutput(id)
+	if !ok {
+		Logger.Errorf("Unable to find output stream %d", id)
+		return opjMinusOneSizeT
+	}
+
+	var data []byte
+	var dataSlice = (*reflect.SliceHeader)(unsafe.Pointer(&data))
+	dataSlice.Cap = int(numBytes)
+	dataSlice.Len = int(numBytes)
+	dataSlice.Data = uintptr(readBuffer)
+
+	b.write(data)
+	return numBytes
+}
+
+//export opjOutputStreamSkip
+//
+// opjOutputStreamSkip moves the write position numBytes ahead; the gap is
+// zero-filled if anything is written after it
+func opjOutputStreamSkip(numBytes C.OPJ_OFF_T, id uint64) C.OPJ_OFF_T {
+	var b, ok = lookupOutput(id)
+	if !ok || b.pos+int64(numBytes) < 0 {
+		Logger.Errorf("Unable to skip %d bytes in output stream %d", numBytes, id)
+		return -1
+	}
+
+	b.pos += int64(numBytes)
+	return numBytes
+}
+
+//export opjOutputStreamSeek
+//
+// opjOutputStreamSeek moves the write position to the absolute offset
+func opjOutputStreamSeek(offset C.OPJ_OFF_T, id uint64) C.OPJ_BOOL {
+	var b, ok = lookupOutput(id)
+	if !ok || offset < 0 {
+		Logger.Errorf("Unable to seek to offset %d in output stream %d", offset, id)
+		return C.OPJ_FALSE
+	}
+
+	b.pos = int64(offset)
+	return C.OPJ_TRUE
+}
@@ -2,3 +2,4 @@
 
 extern opj_stream_t* new_stream(OPJ_UINT64 buffer_size, OPJ_UINT64 stream_id, OPJ_UINT64 data_size);
 extern void GoLog(int level, char *message);
+extern opj_stream_t* new_output_stream(OPJ_UINT64 buffer_size, OPJ_UINT64 stream_id);
@@ -0,0 +1,129 @@
+// Package pdf writes images as minimal single-page PDFs.  The image is
+// JPEG-compressed and embedded directly, since PDF readers can decode JPEG
+// data natively.
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+)
+
+// DefaultDPI is used to compute page dimensions when Options.DPI isn't set
+const DefaultDPI = 300
+
+// pointsPerInch is the PDF user-space unit
+const pointsPerInch = 72
+
+// Options are the encoding parameters.  DPI is the image's resolution, which
+// determines the page's physical size, and JPGQuality is passed through to
+// the JPEG encoder.
+type Options struct {
+	DPI        float64
+	JPGQuality int
+}
+
+// writer wraps an io.Writer to track the offsets PDF's cross-reference table
+// needs, and holds onto the first write error so callers needn't check every
+// write
+type writer struct {
+	w       io.Writer
+	offset  int
+	objects []int
+	err     error
+}
+
+func (w *writer) printf(format string, args ...interface{}) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = fmt.Fprintf(w.w, format, args...)
+	w.offset += n
+}
+
+func (w *writer) write(data []byte) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = w.w.Write(data)
+	w.offset += n
+}
+
+// beginObject records the offset of the next object and writes its header
+func (w *writer) beginObject() {
+	w.objects = append(w.objects, w.offset)
+	w.printf("%d 0 obj\n", len(w.objects))
+}
+
+// Encode writes m to w as a single-page PDF whose page is exactly the size of
+// the image at the requested DPI.  A nil options value uses DefaultDPI and
+// the JPEG encoder's default quality.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{}
+	}
+	var dpi = o.DPI
+	if dpi <= 0 {
+		dpi = DefaultDPI
+	}
+	var quality = o.JPGQuality
+	if quality <= 0 {
+		quality = jpeg.DefaultQuality
+	}
+
+	var b = m.Bounds()
+	if b.Empty() {
+		return fmt.Errorf("pdf: cannot encode an empty image")
+	}
+
+	var colorSpace = "/DeviceRGB"
+	if _, ok := m.(*image.Gray); ok {
+		colorSpace = "/DeviceGray"
+	}
+
+	var jpg bytes.Buffer
+	var err = jpeg.Encode(&jpg, m, &jpeg.Options{Quality: quality})
+	if err != nil {
+		return err
+	}
+
+	var pageW = float64(b.Dx()) * pointsPerInch / dpi
+	var pageH = float64(b.Dy()) * pointsPerInch / dpi
+	var contents = fmt.Sprintf("q %.4f 0 0 %.4f 0 0 cm /Im0 Do Q\n", pageW, pageH)
+
+	var pw = &writer{w: w}
+	pw.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+
+	pw.beginObject()
+	pw.printf("<< /Type /Catalog /Pages 2 0 R >>\nendobj\n")
+
+	pw.beginObject()
+	pw.printf("<< /Type /Pages /Kids [3 0 R] /Count 1 >>\nendobj\n")
+
+	pw.beginObject()
+	pw.printf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.4f %.4f] ", pageW, pageH)
+	pw.printf("/Resources << /XObject << /Im0 4 0 R >> >> /Contents 5 0 R >>\nendobj\n")
+
+	pw.beginObject()
+	pw.printf("<< /Type /XObject /Subtype /Image /Width %d /Height %d ", b.Dx(), b.Dy())
+	pw.printf("/ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode /Length %d >>\n", colorSpace, jpg.Len())
+	pw.printf("stream\n")
+	pw.write(jpg.Bytes())
+	pw.printf("\nendstream\nendobj\n")
+
+	pw.beginObject()
+	pw.printf("<< /Length %d >>\nstream\n%sendstream\nendobj\n", len(contents), contents)
+
+	var xref = pw.offset
+	pw.printf("xref\n0 %d\n0000000000 65535 f \n", len(pw.objects)+1)
+	for _, offset := range pw.objects {
+		pw.printf("%010d 00000 n \n", offset)
+	}
+	pw.printf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(pw.objects)+1, xref)
+
+	return pw.err
+}
@@ -0,0 +1,54 @@
+package pdf
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"regexp"
+	"strconv"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func encode(m image.Image, o *Options, t *testing.T) string {
+	var buf bytes.Buffer
+	var err = Encode(&buf, m, o)
+	assert.NilError(err, "encoding the PDF", t)
+	return buf.String()
+}
+
+func TestEncodeStructure(t *testing.T) {
+	var pdf = encode(image.NewRGBA(image.Rect(0, 0, 600, 300)), &Options{DPI: 150}, t)
+
+	assert.True(bytes.HasPrefix([]byte(pdf), []byte("%PDF-1.4\n")), "PDF header", t)
+	assert.True(bytes.HasSuffix([]byte(pdf), []byte("%%EOF\n")), "PDF trailer", t)
+	assert.True(bytes.Contains([]byte(pdf), []byte("/MediaBox [0 0 288.0000 144.0000]")), "page size", t)
+	assert.True(bytes.Contains([]byte(pdf), []byte("/ColorSpace /DeviceRGB")), "color space", t)
+	assert.True(bytes.Contains([]byte(pdf), []byte("/Width 600 /Height 300")), "image dimensions", t)
+
+	// Every xref entry must point at the start of its object
+	var startxref = regexp.MustCompile(`startxref\n(\d+)\n`).FindStringSubmatch(pdf)
+	assert.Equal(2, len(startxref), "startxref is present", t)
+	var xref, _ = strconv.Atoi(startxref[1])
+	assert.True(bytes.HasPrefix([]byte(pdf[xref:]), []byte("xref\n0 6\n")), "startxref points to xref table", t)
+
+	var entries = regexp.MustCompile(`(\d{10}) 00000 n \n`).FindAllStringSubmatch(pdf[xref:], -1)
+	assert.Equal(5, len(entries), "five objects in the xref table", t)
+	for i, entry := range entries {
+		var offset, _ = strconv.Atoi(entry[1])
+		var header = fmt.Sprintf("%d 0 obj\n", i+1)
+		assert.Equal(header, pdf[offset:offset+len(header)], "xref offset for object "+header, t)
+	}
+}
+
+func TestEncodeGray(t *testing.T) {
+	var pdf = encode(image.NewGray(image.Rect(0, 0, 72, 144)), nil, t)
+	assert.True(bytes.Contains([]byte(pdf), []byte("/ColorSpace /DeviceGray")), "gray color space", t)
+	assert.True(bytes.Contains([]byte(pdf), []byte("/MediaBox [0 0 17.2800 34.5600]")), "page size uses default DPI", t)
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	var err = Encode(&bytes.Buffer{}, image.NewRGBA(image.Rect(0, 0, 0, 10)), nil)
+	assert.True(err != nil, "empty images can't be encoded", t)
+}
@@ -7,21 +7,25 @@
 // DD_API_KEY should be added to your .env, but you should be able to use our
 // demo docker-compose.yml file otherwise.
 //
-// Then, "DatadogAddress" must be added to your rais.toml or else
-// RAIS_DATADOGADDRESS must be in your RAIS environment.  This is shown in our
-// demo docker-compose.yml.
+// Then, "Address" must be added to the [plugins.datadog] table in your
+// rais.toml or else RAIS_DATADOGADDRESS must be in your RAIS environment.  The
+// latter is shown in our demo docker-compose.yml.
 //
-// If you want to set a custom service name, set "DatadogServiceName" or else
-// expose RAIS_DatadogServiceName in your environment.  The default service
-// name is "RAIS/datadog".
+// If you want to set a custom service name, set "ServiceName" in the same
+// table or else expose RAIS_DatadogServiceName in your environment.  The
+// default service name is "RAIS/datadog".
+//
+// The top-level "DatadogAddress" and "DatadogServiceName" settings from older
+// versions of RAIS still work, but the plugin's table takes precedence.
 //
 // If you want instrumentation that goes deeper than request round-tripping,
 // please be aware that RAIS does not currently support this.
 
-package main
+package datadog
 
 import (
 	"net/http"
+	"rais/src/plugins/registry"
 
 	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
@@ -36,20 +40,37 @@
 // the global list unless sanity checks in Initialize() pass
 var Disabled = true
 
+// init makes the plugin available to be compiled into RAIS; it still has to
+// be enabled in the "Plugins" setting
+func init() {
+	registry.Register(&registry.Plugin{
+		Name:        "datadog",
+		APIVersion:  registry.APIVersion,
+		Disabled:    &Disabled,
+		SetLogger:   SetLogger,
+		Initialize:  Initialize,
+		WrapHandler: WrapHandler,
+		Teardown:    Teardown,
+	})
+}
+
 // Initialize reads configuration and sets up the datadog agent
-func Initialize() {
-	var ddaddr = viper.GetString("DatadogAddress")
+func Initialize(cfg *viper.Viper) error {
 	viper.SetDefault("DatadogServiceName", "RAIS/datadog")
-	serviceName = viper.GetString("DatadogServiceName")
+	cfg.SetDefault("Address", viper.GetString("DatadogAddress"))
+	cfg.SetDefault("ServiceName", viper.GetString("DatadogServiceName"))
+	var ddaddr = cfg.GetString("Address")
+	serviceName = cfg.GetString("ServiceName")
 
 	if ddaddr == "" {
-		l.Warnf("DatadogAddress must be configured, or RAIS_DATADOGADDRESS must be set in the environment  **DataDog plugin is disabled**")
-		return
+		l.Warnf("Address must be configured in [plugins.datadog], or RAIS_DATADOGADDRESS must be set in the environment  **DataDog plugin is disabled**")
+		return nil
 	}
 
 	Disabled = false
 	l.Debugf("Connecting to datadog agent at %q", ddaddr)
 	tracer.Start(tracer.WithAgentAddr(ddaddr))
+	return nil
 }
 
 // WrapHandler takes all RAIS routes' handlers and puts the datadog
@@ -0,0 +1,69 @@
+#!/usr/bin/env python3
+#
+# This is an example of an external RAIS plugin written in Python.  It maps
+# IDs like "py:foo.jp2" to files under /var/local/images, leaving all other
+# IDs for RAIS to handle.  To try it out, add it to your rais.toml:
+#
+#     ExternalPlugins = "/path/to/example.py"
+#
+# The image directory can be changed in the plugin's own table:
+#
+#     [plugins.python-example]
+#     ImageRoot = "/mnt/images/"
+#
+# RAIS talks to the plugin over stdin and stdout, one JSON-RPC 1.0 message
+# per line, so anything the plugin wants logged must go to stderr.  See
+# protocol.go for the full list of methods and their arguments.
+
+import json
+import sys
+
+PROTOCOL_VERSION = 1
+image_root = "/var/local/images/"
+
+
+def handshake(args):
+    return {
+        "protocolVersion": PROTOCOL_VERSION,
+        "name": "python-example",
+        "hooks": ["Configure", "IDToURL"],
+    }
+
+
+def configure(args):
+    global image_root
+    image_root = args["config"].get("ImageRoot", image_root)
+    return {}
+
+
+def id_to_url(args):
+    id = args["id"]
+    if not id.startswith("py:"):
+        return {"skipped": True}
+    return {"url": "file://" + image_root + id[3:]}
+
+
+METHODS = {
+    "Plugin.Handshake": handshake,
+    "Plugin.Configure": configure,
+    "Plugin.IDToURL": id_to_url,
+}
+
+
+def main():
+    for line in sys.stdin:
+        request = json.loads(line)
+        response = {"id": request["id"], "result": None, "error": None}
+        try:
+            method = METHODS[request["method"]]
+            response["result"] = method(request["params"][0])
+        except Exception as e:
+            print("error handling %s: %r" % (request["method"], e), file=sys.stderr)
+            response["error"] = str(e)
+
+        sys.stdout.write(json.dumps(response) + "\n")
+        sys.stdout.flush()
+
+
+if __name__ == "__main__":
+    main()
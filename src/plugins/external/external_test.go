@@ -0,0 +1,251 @@
+package external
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"rais/src/img"
+	"rais/src/plugins"
+	"testing"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// testPluginEnv tells the test binary to act as a plugin rather than run
+// tests, so the tests can launch themselves as an external plugin
+const testPluginEnv = "RAIS_EXTERNAL_TEST_PLUGIN"
+
+// testVersionEnv overrides the protocol version the test plugin reports
+const testVersionEnv = "RAIS_EXTERNAL_TEST_VERSION"
+
+var testData = bytes.Repeat([]byte("0123456789"), 100000)
+
+type testPlugin struct {
+	root string
+}
+
+func (*testPlugin) Handshake(args HandshakeArgs, reply *HandshakeReply) error {
+	reply.ProtocolVersion = ProtocolVersion
+	if os.Getenv(testVersionEnv) != "" {
+		reply.ProtocolVersion = 99
+	}
+	reply.Name = "test"
+	reply.Hooks = []string{HookConfigure, HookIDToURL, HookStreamReader, HookDecodeHandler}
+	return nil
+}
+
+func (tp *testPlugin) Configure(args ConfigureArgs, reply *Empty) error {
+	var root, ok = args.Config["root"].(string)
+	if ok {
+		tp.root = root
+	}
+	return nil
+}
+
+func (tp *testPlugin) IDToURL(args IDArgs, reply *URLReply) error {
+	switch args.ID {
+	case "crash":
+		os.Exit(1)
+	case "hang":
+		time.Sleep(time.Minute)
+	case "skip":
+		reply.Skipped = true
+	default:
+		reply.URL = tp.root + args.ID
+	}
+	return nil
+}
+
+func (*testPlugin) StreamInfo(args URLArgs, reply *StreamInfoReply) error {
+	if args.URL != "test://data" {
+		reply.Skipped = true
+		return nil
+	}
+	reply.Size = int64(len(testData))
+	reply.ModTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return nil
+}
+
+func (*testPlugin) StreamRead(args StreamReadArgs, reply *StreamReadReply) error {
+	var end = args.Offset + int64(args.Length)
+	if end > int64(len(testData)) {
+		end = int64(len(testData))
+	}
+	reply.Data = testData[args.Offset:end]
+	return nil
+}
+
+func (*testPlugin) DecodeInfo(args URLArgs, reply *DecodeInfoReply) error {
+	if args.URL != "test://data" {
+		reply.Skipped = true
+		return nil
+	}
+	reply.Width, reply.Height, reply.Levels = 400, 200, 1
+	return nil
+}
+
+// DecodeImage returns an image of the requested size whose first pixel holds
+// the crop's X coordinate
+func (*testPlugin) DecodeImage(args DecodeImageArgs, reply *DecodeImageReply) error {
+	var m = image.NewGray(image.Rect(0, 0, args.ResizeWidth, args.ResizeHeight))
+	m.Pix[0] = uint8(args.X)
+	var buf bytes.Buffer
+	var err = png.Encode(&buf, m)
+	reply.Data = buf.Bytes()
+	return err
+}
+
+func TestMain(m *testing.M) {
+	if os.Getenv(testPluginEnv) != "" {
+		Serve(&testPlugin{root: "file:///images/"})
+		os.Exit(0)
+	}
+
+	os.Setenv(testPluginEnv, "1")
+	os.Exit(m.Run())
+}
+
+func startTestPlugin(transport string, timeout time.Duration, t *testing.T) *Plugin {
+	var p, err = Start(os.Args[0], transport, timeout, logger.New(logger.Warn))
+	if err != nil {
+		t.Fatalf("Unable to start test plugin: %s", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+func TestHandshake(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Second*5, t)
+	assert.Equal("test", p.Name(), "plugin name", t)
+	assert.True(p.Implements(HookIDToURL), "plugin implements IDToURL", t)
+	assert.False(p.Implements(HookPurgeCaches), "plugin doesn't implement PurgeCaches", t)
+
+	os.Setenv(testVersionEnv, "1")
+	defer os.Unsetenv(testVersionEnv)
+	var _, err = Start(os.Args[0], TransportStdio, time.Second*5, logger.New(logger.Warn))
+	assert.True(err != nil, "a plugin with the wrong protocol version is rejected", t)
+}
+
+func TestIDToURL(t *testing.T) {
+	for _, transport := range []string{TransportStdio, TransportUnix} {
+		t.Run(transport, func(t *testing.T) {
+			var p = startTestPlugin(transport, time.Second*5, t)
+			var u, err = p.IDToURL("foo.tif")
+			assert.NilError(err, "resolving foo.tif", t)
+			assert.Equal("file:///images/foo.tif", u.String(), "resolved URL", t)
+
+			_, err = p.IDToURL("skip")
+			assert.Equal(plugins.ErrSkipped, err, "skipped ID", t)
+		})
+	}
+}
+
+func TestRestart(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Millisecond*500, t)
+
+	var _, err = p.IDToURL("crash")
+	assert.True(err != nil, "a crashed plugin returns an error", t)
+	var u *url.URL
+	u, err = p.IDToURL("foo.tif")
+	assert.NilError(err, "the plugin is restarted after crashing", t)
+	assert.Equal("file:///images/foo.tif", u.String(), "resolved URL after crash", t)
+
+	_, err = p.IDToURL("hang")
+	assert.True(err != nil, "a hung plugin returns an error", t)
+	u, err = p.IDToURL("foo.tif")
+	assert.NilError(err, "the plugin is restarted after hanging", t)
+	assert.Equal("file:///images/foo.tif", u.String(), "resolved URL after hang", t)
+}
+
+func TestConfigure(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Second*5, t)
+	var err = p.Configure(map[string]interface{}{"root": "file:///other/"})
+	assert.NilError(err, "configuring the plugin", t)
+
+	var u *url.URL
+	u, err = p.IDToURL("foo.tif")
+	assert.NilError(err, "resolving foo.tif", t)
+	assert.Equal("file:///other/foo.tif", u.String(), "configured root is used", t)
+
+	p.IDToURL("crash")
+	u, err = p.IDToURL("foo.tif")
+	assert.NilError(err, "resolving foo.tif after a crash", t)
+	assert.Equal("file:///other/foo.tif", u.String(), "configuration is resent on restart", t)
+}
+
+func TestStreamReader(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Second*5, t)
+	var _, err = p.StreamReader(mustParse("file:///foo.jp2"))
+	assert.Equal(plugins.ErrSkipped, err, "plugin doesn't stream file URLs", t)
+
+	var open, _ = p.StreamReader(mustParse("test://data"))
+	var s, _ = open()
+	assert.Equal(int64(len(testData)), s.Size(), "stream size", t)
+	assert.Equal(2020, s.ModTime().Year(), "stream modtime", t)
+
+	var data []byte
+	data, err = ioutil.ReadAll(s)
+	assert.NilError(err, "reading the stream", t)
+	assert.True(bytes.Equal(testData, data), "streamed data matches", t)
+
+	s.Seek(-5, io.SeekEnd)
+	data, err = ioutil.ReadAll(s)
+	assert.NilError(err, "reading the stream's end", t)
+	assert.Equal("56789", string(data), "seeking works", t)
+}
+
+func TestDecodeHandler(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Second*5, t)
+	var open, _ = p.StreamReader(mustParse("test://data"))
+	var s, _ = open()
+
+	var fn, err = p.DecodeHandler(s)
+	assert.NilError(err, "getting the decode function", t)
+	var d, _ = fn()
+	assert.Equal(400, d.GetWidth(), "decoder width", t)
+	assert.Equal(200, d.GetHeight(), "decoder height", t)
+	assert.Equal(0, d.(img.PyramidDecoder).GetReductions(), "one level means no reductions", t)
+
+	d.SetCrop(image.Rect(50, 0, 250, 100))
+	d.SetResizeWH(100, 50)
+	var m image.Image
+	m, err = d.DecodeImage()
+	assert.NilError(err, "decoding the image", t)
+	assert.Equal(image.Rect(0, 0, 100, 50), m.Bounds(), "decoded image size", t)
+	assert.Equal(uint8(50), m.(*image.Gray).Pix[0], "crop was sent to the plugin", t)
+}
+
+func TestUnavailable(t *testing.T) {
+	var p = startTestPlugin(TransportStdio, time.Second*5, t)
+	var open, _ = p.StreamReader(mustParse("test://data"))
+	var s, _ = open()
+
+	p.m.Lock()
+	p.starting = true
+	p.m.Unlock()
+	var _, err = p.IDToURL("foo.tif")
+	assert.Equal(ErrUnavailable, err, "calls don't wait for a plugin which is starting", t)
+	p.m.Lock()
+	p.starting = false
+	p.m.Unlock()
+
+	p.Close()
+	_, err = p.StreamReader(mustParse("test://data"))
+	assert.Equal(plugins.ErrSkipped, err, "a stopped plugin skips streams", t)
+	_, err = p.DecodeHandler(s)
+	assert.Equal(plugins.ErrSkipped, err, "a stopped plugin skips decoding", t)
+}
+
+func mustParse(s string) *url.URL {
+	var u, err = url.Parse(s)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
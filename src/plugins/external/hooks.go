@@ -0,0 +1,250 @@
+package external
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"io"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/img"
+	"rais/src/plugins"
+	"time"
+
+	// Plugins may return decoded images as PNG or JPEG data
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// readChunkSize is the minimum number of bytes requested from a plugin when
+// reading a stream, to avoid a round trip for every small read
+const readChunkSize = 256 * 1024
+
+// IDToURL asks the plugin to resolve an image ID to a URL
+func (p *Plugin) IDToURL(id iiif.ID) (*url.URL, error) {
+	var reply URLReply
+	var err = p.Call(MethodIDToURL, IDArgs{ID: string(id)}, &reply)
+	if err != nil {
+		return nil, err
+	}
+	if reply.Skipped {
+		return nil, plugins.ErrSkipped
+	}
+	return url.Parse(reply.URL)
+}
+
+// ExpireCachedImage tells the plugin an image's cached data is no longer
+// valid
+func (p *Plugin) ExpireCachedImage(id iiif.ID) {
+	var err = p.Call(MethodExpireCachedImage, IDArgs{ID: string(id)}, &Empty{})
+	if err != nil {
+		p.l.Errorf("Plugin %q couldn't expire cached image %q: %s", p.info.Name, id, err)
+	}
+}
+
+// PurgeCaches tells the plugin all cached data is no longer valid
+func (p *Plugin) PurgeCaches() {
+	var err = p.Call(MethodPurgeCaches, Empty{}, &Empty{})
+	if err != nil {
+		p.l.Errorf("Plugin %q couldn't purge caches: %s", p.info.Name, err)
+	}
+}
+
+// StreamReader is an img.StreamReader which asks the plugin whether it can
+// stream the given URL.  If the plugin isn't running, the URL is left to
+// other stream readers.
+func (p *Plugin) StreamReader(u *url.URL) (img.OpenStreamFunc, error) {
+	var reply StreamInfoReply
+	var err = p.Call(MethodStreamInfo, URLArgs{URL: u.String()}, &reply)
+	if err == ErrUnavailable {
+		return nil, plugins.ErrSkipped
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply.Skipped {
+		return nil, plugins.ErrSkipped
+	}
+
+	return func() (img.Streamer, error) {
+		return &streamer{p: p, u: u, size: reply.Size, modTime: reply.ModTime}, nil
+	}, nil
+}
+
+// streamer implements img.Streamer by reading data from a plugin
+type streamer struct {
+	p       *Plugin
+	u       *url.URL
+	size    int64
+	modTime time.Time
+	offset  int64
+
+	// buf holds the most recent data read from the plugin, starting at bufOff
+	buf    []byte
+	bufOff int64
+}
+
+func (s *streamer) Location() *url.URL {
+	return s.u
+}
+
+func (s *streamer) Size() int64 {
+	return s.size
+}
+
+func (s *streamer) ModTime() time.Time {
+	return s.modTime
+}
+
+func (s *streamer) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+
+	if s.offset < s.bufOff || s.offset >= s.bufOff+int64(len(s.buf)) {
+		var length = len(p)
+		if length < readChunkSize {
+			length = readChunkSize
+		}
+
+		var reply StreamReadReply
+		var args = StreamReadArgs{URL: s.u.String(), Offset: s.offset, Length: length}
+		var err = s.p.Call(MethodStreamRead, args, &reply)
+		if err != nil {
+			return 0, err
+		}
+		if len(reply.Data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+		s.buf, s.bufOff = reply.Data, s.offset
+	}
+
+	var n = copy(p, s.buf[s.offset-s.bufOff:])
+	s.offset += int64(n)
+	return n, nil
+}
+
+func (s *streamer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += s.offset
+	case io.SeekEnd:
+		offset += s.size
+	default:
+		return 0, errors.New("invalid whence")
+	}
+
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	s.offset = offset
+	return offset, nil
+}
+
+func (s *streamer) Close() error {
+	s.buf = nil
+	return nil
+}
+
+// DecodeHandler is an img.DecodeHandler which asks the plugin whether it can
+// decode the streamer's image.  The plugin is given the image's URL, not its
+// data, so it must be able to read the image on its own.  If the plugin isn't
+// running, the image is left to other decoders.
+func (p *Plugin) DecodeHandler(s img.Streamer) (img.DecodeFunc, error) {
+	var u = s.Location().String()
+	var reply DecodeInfoReply
+	var err = p.Call(MethodDecodeInfo, URLArgs{URL: u}, &reply)
+	if err == ErrUnavailable {
+		return nil, plugins.ErrSkipped
+	}
+	if err != nil {
+		return nil, err
+	}
+	if reply.Skipped {
+		return nil, plugins.ErrSkipped
+	}
+
+	return func() (img.Decoder, error) {
+		return &decoder{p: p, url: u, info: reply}, nil
+	}, nil
+}
+
+// decoder implements img.Decoder by asking a plugin for decoded image data
+type decoder struct {
+	p      *Plugin
+	url    string
+	info   DecodeInfoReply
+	crop   image.Rectangle
+	resize image.Point
+}
+
+func (d *decoder) GetWidth() int {
+	return d.info.Width
+}
+
+func (d *decoder) GetHeight() int {
+	return d.info.Height
+}
+
+func (d *decoder) GetTileWidth() int {
+	return d.info.TileWidth
+}
+
+func (d *decoder) GetTileHeight() int {
+	return d.info.TileHeight
+}
+
+func (d *decoder) GetLevels() int {
+	return d.info.Levels
+}
+
+// GetReductions returns one less than the number of levels, since plugins
+// report the full resolution as a level
+func (d *decoder) GetReductions() int {
+	if d.info.Levels < 1 {
+		return 0
+	}
+	return d.info.Levels - 1
+}
+
+func (d *decoder) SetCrop(r image.Rectangle) {
+	d.crop = r
+}
+
+func (d *decoder) SetResizeWH(w, h int) {
+	d.resize = image.Pt(w, h)
+}
+
+// DecodeImage asks the plugin for the image cropped and resized as
+// requested.  As with other decoders, no crop means the full image, and no
+// resize means the cropped region's dimensions.
+func (d *decoder) DecodeImage() (image.Image, error) {
+	var crop = d.crop
+	if crop == image.ZR {
+		crop = image.Rect(0, 0, d.info.Width, d.info.Height)
+	}
+	var resize = d.resize
+	if resize.X == 0 || resize.Y == 0 {
+		resize = crop.Size()
+	}
+
+	var args = DecodeImageArgs{
+		URL:          d.url,
+		X:            crop.Min.X,
+		Y:            crop.Min.Y,
+		Width:        crop.Dx(),
+		Height:       crop.Dy(),
+		ResizeWidth:  resize.X,
+		ResizeHeight: resize.Y,
+	}
+	var reply DecodeImageReply
+	var err = d.p.Call(MethodDecodeImage, args, &reply)
+	if err != nil {
+		return nil, err
+	}
+
+	var m image.Image
+	m, _, err = image.Decode(bytes.NewReader(reply.Data))
+	return m, err
+}
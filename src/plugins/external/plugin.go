@@ -0,0 +1,423 @@
+package external
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// SocketEnv is the environment variable holding the path a plugin using the
+// Unix socket transport must listen on
+const SocketEnv = "RAIS_PLUGIN_SOCKET"
+
+// Transports for talking to a plugin
+const (
+	TransportStdio = "stdio"
+	TransportUnix  = "unix"
+)
+
+// restartDelay is the minimum time between attempts to restart a plugin, so
+// a plugin which can't start isn't relaunched on every request
+const restartDelay = time.Second
+
+// Errors a plugin call can return when the plugin itself is at fault
+var (
+	ErrTimeout     = errors.New("plugin didn't respond in time")
+	ErrUnavailable = errors.New("plugin isn't running")
+)
+
+// Plugin manages an external plugin process
+type Plugin struct {
+	command   string
+	transport string
+	timeout   time.Duration
+	l         *logger.Logger
+	info      HandshakeReply
+	config    map[string]interface{}
+
+	m         sync.Mutex
+	proc      *process
+	lastStart time.Time
+	starting  bool
+	closed    bool
+}
+
+// process is a running plugin process and the connection to it
+type process struct {
+	cmd     *exec.Cmd
+	client  *rpc.Client
+	sockDir string
+}
+
+// Start launches the given command as a plugin and performs the protocol
+// handshake.  transport must be TransportStdio or TransportUnix.  Calls
+// which take longer than timeout fail, and the plugin is restarted.
+func Start(command, transport string, timeout time.Duration, l *logger.Logger) (*Plugin, error) {
+	if transport != TransportStdio && transport != TransportUnix {
+		return nil, fmt.Errorf("invalid transport %q", transport)
+	}
+
+	var p = &Plugin{command: command, transport: transport, timeout: timeout, l: l, lastStart: time.Now()}
+	var proc, err = p.start(nil)
+	if err != nil {
+		return nil, err
+	}
+	p.proc = proc
+	return p, nil
+}
+
+// Name returns the name the plugin reported in its handshake
+func (p *Plugin) Name() string {
+	return p.info.Name
+}
+
+// Command returns the path to the plugin's executable
+func (p *Plugin) Command() string {
+	return p.command
+}
+
+// Hooks returns the hooks the plugin reported in its handshake
+func (p *Plugin) Hooks() []string {
+	return p.info.Hooks
+}
+
+// Implements returns true if the plugin reported the given hook in its
+// handshake
+func (p *Plugin) Implements(hook string) bool {
+	for _, h := range p.info.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// start launches a plugin process, verifies its handshake, and sends it the
+// given settings, if any.  The plugin's current process isn't touched, so
+// this doesn't need the lock, but only one start may run at a time.
+func (p *Plugin) start(config map[string]interface{}) (*process, error) {
+	var proc, err = p.launch()
+	if err != nil {
+		return nil, err
+	}
+
+	var info HandshakeReply
+	err = p.call(proc.client, MethodHandshake, HandshakeArgs{ProtocolVersion: ProtocolVersion}, &info)
+	if err == nil {
+		err = p.checkHandshake(info)
+	}
+	if err != nil {
+		proc.stop()
+		return nil, fmt.Errorf("handshake with %q failed: %s", p.command, err)
+	}
+
+	// The handshake info is only recorded the first time; hooks are fixed once
+	// RAIS has registered them, and other goroutines read the info unlocked
+	if p.info.Name == "" {
+		p.info = info
+	}
+
+	if config != nil {
+		err = p.configure(proc.client, config)
+		if err != nil {
+			proc.stop()
+			return nil, err
+		}
+	}
+	return proc, nil
+}
+
+// Configure sends the plugin its settings if it implements the Configure
+// hook.  The settings are kept so they can be sent again if the plugin has to
+// be restarted.
+func (p *Plugin) Configure(config map[string]interface{}) error {
+	if config == nil {
+		config = make(map[string]interface{})
+	}
+
+	p.m.Lock()
+	p.config = config
+	var proc = p.proc
+	p.m.Unlock()
+
+	if proc == nil {
+		return ErrUnavailable
+	}
+	return p.configure(proc.client, config)
+}
+
+// configure sends settings to the plugin over the given client
+func (p *Plugin) configure(client *rpc.Client, config map[string]interface{}) error {
+	if !p.Implements(HookConfigure) {
+		return nil
+	}
+
+	var err = p.call(client, MethodConfigure, ConfigureArgs{Config: config}, &Empty{})
+	if err != nil {
+		return fmt.Errorf("unable to configure %q: %s", p.info.Name, err)
+	}
+	return nil
+}
+
+// checkHandshake validates a handshake reply.  Once a plugin has started, it
+// must report the same name on restart.
+func (p *Plugin) checkHandshake(info HandshakeReply) error {
+	if info.ProtocolVersion != ProtocolVersion {
+		return fmt.Errorf("plugin speaks protocol version %d; RAIS requires version %d", info.ProtocolVersion, ProtocolVersion)
+	}
+	if info.Name == "" {
+		return errors.New("plugin didn't report a name")
+	}
+	if p.info.Name != "" && p.info.Name != info.Name {
+		return fmt.Errorf("plugin name changed from %q to %q", p.info.Name, info.Name)
+	}
+	return nil
+}
+
+// launch starts a plugin process and connects to it
+func (p *Plugin) launch() (*process, error) {
+	var proc = &process{cmd: exec.Command(p.command)}
+	var stderr, err = proc.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	go p.logOutput(stderr)
+
+	var conn io.ReadWriteCloser
+	switch p.transport {
+	case TransportStdio:
+		conn, err = stdioConn(proc.cmd)
+		if err == nil {
+			err = proc.cmd.Start()
+		}
+	case TransportUnix:
+		conn, err = p.unixConn(proc)
+	}
+	if err != nil {
+		proc.stop()
+		return nil, fmt.Errorf("unable to start %q: %s", p.command, err)
+	}
+
+	proc.client = jsonrpc.NewClient(conn)
+	return proc, nil
+}
+
+// pipeConn joins a process's stdout and stdin into a single connection
+type pipeConn struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+// Close closes both pipes
+func (c pipeConn) Close() error {
+	var err = c.WriteCloser.Close()
+	var err2 = c.ReadCloser.Close()
+	if err == nil {
+		err = err2
+	}
+	return err
+}
+
+func stdioConn(cmd *exec.Cmd) (io.ReadWriteCloser, error) {
+	var w, err = cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	var r io.ReadCloser
+	r, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	return pipeConn{r, w}, nil
+}
+
+// unixConn starts the process with a socket path in its environment, and
+// waits until the plugin is listening on it
+func (p *Plugin) unixConn(proc *process) (io.ReadWriteCloser, error) {
+	var dir, err = ioutil.TempDir("", "rais-plugin-")
+	if err != nil {
+		return nil, err
+	}
+	proc.sockDir = dir
+	var cmd = proc.cmd
+
+	var sock = filepath.Join(dir, "plugin.sock")
+	cmd.Env = append(os.Environ(), SocketEnv+"="+sock)
+	var stdout io.ReadCloser
+	stdout, err = cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	go p.logOutput(stdout)
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	var deadline = time.Now().Add(p.timeout)
+	for {
+		var conn, dialErr = net.Dial("unix", sock)
+		if dialErr == nil {
+			return conn, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("plugin never listened on %q: %s", sock, dialErr)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// logOutput sends anything the plugin writes to r to the RAIS logs
+func (p *Plugin) logOutput(r io.Reader) {
+	var scanner = bufio.NewScanner(r)
+	for scanner.Scan() {
+		p.l.Infof("Plugin %q: %s", p.command, scanner.Text())
+	}
+}
+
+// stop shuts down the process and connection, and waits on the process so it
+// doesn't linger as a zombie
+func (proc *process) stop() {
+	if proc.client != nil {
+		proc.client.Close()
+	}
+	if proc.cmd.Process != nil {
+		proc.cmd.Process.Kill()
+		go proc.cmd.Wait()
+	}
+	if proc.sockDir != "" {
+		os.RemoveAll(proc.sockDir)
+	}
+}
+
+// relaunch stops the plugin's process, if it has one, and starts a new one.
+// The lock is released while the plugin starts, which can take as long as
+// the timeout, so other calls fail with ErrUnavailable instead of waiting.
+// The caller must hold the lock.
+func (p *Plugin) relaunch() error {
+	if p.proc != nil {
+		p.proc.stop()
+		p.proc = nil
+	}
+	p.starting = true
+	p.lastStart = time.Now()
+	var config = p.config
+
+	p.m.Unlock()
+	var proc, err = p.start(config)
+	p.m.Lock()
+
+	p.starting = false
+	if err != nil {
+		return err
+	}
+	if p.closed {
+		proc.stop()
+		return ErrUnavailable
+	}
+	p.proc = proc
+	return nil
+}
+
+// restart stops and relaunches the plugin unless it's already been restarted
+// since the given client failed
+func (p *Plugin) restart(failed *rpc.Client) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed || p.starting || p.proc == nil || p.proc.client != failed {
+		return
+	}
+
+	p.l.Warnf("Restarting plugin %q", p.info.Name)
+	var err = p.relaunch()
+	if err != nil {
+		p.l.Errorf("Unable to restart plugin %q: %s", p.info.Name, err)
+	}
+}
+
+// getClient returns the current RPC client, starting the plugin if it isn't
+// running, nobody else is starting it, and it's been long enough since the
+// last attempt
+func (p *Plugin) getClient() (*rpc.Client, error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if p.closed || p.starting {
+		return nil, ErrUnavailable
+	}
+	if p.proc != nil {
+		return p.proc.client, nil
+	}
+	if time.Since(p.lastStart) < restartDelay {
+		return nil, ErrUnavailable
+	}
+
+	var err = p.relaunch()
+	if err != nil {
+		p.l.Errorf("Unable to restart plugin %q: %s", p.info.Name, err)
+		return nil, ErrUnavailable
+	}
+	return p.proc.client, nil
+}
+
+// Call sends a request to the plugin and waits for its reply.  If the plugin
+// doesn't respond in time or its connection fails, it's restarted, and the
+// call returns an error.  Errors the plugin itself returns are passed along
+// as-is.
+func (p *Plugin) Call(method string, args, reply interface{}) error {
+	var client, err = p.getClient()
+	if err != nil {
+		return err
+	}
+
+	err = p.call(client, method, args, reply)
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(rpc.ServerError); ok {
+		return err
+	}
+
+	p.restart(client)
+	return fmt.Errorf("plugin %q failed calling %s: %s", p.info.Name, method, err)
+}
+
+// call makes a single request on the given client, enforcing the timeout
+func (p *Plugin) call(client *rpc.Client, method string, args, reply interface{}) error {
+	var c = client.Go(method, args, reply, make(chan *rpc.Call, 1))
+	var timer = time.NewTimer(p.timeout)
+	defer timer.Stop()
+
+	select {
+	case <-c.Done:
+		return c.Error
+	case <-timer.C:
+		return ErrTimeout
+	}
+}
+
+// Close shuts down the plugin.  It won't be restarted after this.
+func (p *Plugin) Close() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	p.closed = true
+	if p.proc != nil {
+		p.proc.stop()
+		p.proc = nil
+	}
+}
@@ -0,0 +1,150 @@
+// Package external runs RAIS plugins as separate processes, talking to them
+// over stdio or a Unix socket.  Plugins can be written in any language, and a
+// plugin which crashes or hangs is restarted rather than taking RAIS down.
+//
+// The protocol is JSON-RPC 1.0 as implemented by Go's net/rpc/jsonrpc: each
+// request is a JSON object with "method", "params" (a one-element array
+// holding the arguments), and "id"; each response is a JSON object with the
+// request's "id", "result", and "error" (null on success, a string
+// otherwise).  Requests may be pipelined, but a plugin can answer them one at
+// a time.
+//
+// RAIS first calls Plugin.Handshake.  The plugin must reply with the protocol
+// version it speaks, its name, and the hooks it implements.  Only the methods
+// for those hooks are called after that.  If the plugin implements the
+// Configure hook, RAIS then sends it the settings in its [plugins.<name>]
+// table in rais.toml.  This happens again any time the plugin is restarted.
+//
+// All calls are stateless: streams and decoders are identified by URL rather
+// than by a handle, so restarting a plugin never invalidates anything RAIS is
+// holding onto.
+package external
+
+import "time"
+
+// ProtocolVersion is the version of the protocol described here.  Plugins
+// speaking any other version are rejected at startup.
+const ProtocolVersion = 1
+
+// Methods RAIS calls on a plugin
+const (
+	MethodHandshake         = "Plugin.Handshake"
+	MethodConfigure         = "Plugin.Configure"
+	MethodIDToURL           = "Plugin.IDToURL"
+	MethodExpireCachedImage = "Plugin.ExpireCachedImage"
+	MethodPurgeCaches       = "Plugin.PurgeCaches"
+	MethodStreamInfo        = "Plugin.StreamInfo"
+	MethodStreamRead        = "Plugin.StreamRead"
+	MethodDecodeInfo        = "Plugin.DecodeInfo"
+	MethodDecodeImage       = "Plugin.DecodeImage"
+)
+
+// Hooks a plugin can report in its handshake
+const (
+	// HookConfigure: the plugin implements Plugin.Configure
+	HookConfigure = "Configure"
+	// HookIDToURL: the plugin implements Plugin.IDToURL
+	HookIDToURL = "IDToURL"
+	// HookExpireCachedImage: the plugin implements Plugin.ExpireCachedImage
+	HookExpireCachedImage = "ExpireCachedImage"
+	// HookPurgeCaches: the plugin implements Plugin.PurgeCaches
+	HookPurgeCaches = "PurgeCaches"
+	// HookStreamReader: the plugin implements Plugin.StreamInfo and
+	// Plugin.StreamRead
+	HookStreamReader = "StreamReader"
+	// HookDecodeHandler: the plugin implements Plugin.DecodeInfo and
+	// Plugin.DecodeImage
+	HookDecodeHandler = "DecodeHandler"
+)
+
+// HandshakeArgs tells the plugin which protocol version RAIS speaks
+type HandshakeArgs struct {
+	ProtocolVersion int `json:"protocolVersion"`
+}
+
+// HandshakeReply describes the plugin
+type HandshakeReply struct {
+	ProtocolVersion int      `json:"protocolVersion"`
+	Name            string   `json:"name"`
+	Hooks           []string `json:"hooks"`
+}
+
+// ConfigureArgs holds the plugin's settings
+type ConfigureArgs struct {
+	Config map[string]interface{} `json:"config"`
+}
+
+// IDArgs holds an image's IIIF identifier
+type IDArgs struct {
+	ID string `json:"id"`
+}
+
+// URLArgs holds an image's URL
+type URLArgs struct {
+	URL string `json:"url"`
+}
+
+// Empty is the argument or reply for methods which don't need any data
+type Empty struct{}
+
+// URLReply is the reply to Plugin.IDToURL.  If Skipped is true, the plugin
+// doesn't handle the given ID, and other plugins or RAIS's scheme map will be
+// used instead.
+type URLReply struct {
+	Skipped bool   `json:"skipped"`
+	URL     string `json:"url"`
+}
+
+// StreamInfoReply is the reply to Plugin.StreamInfo.  If Skipped is true,
+// the plugin doesn't handle the given URL.
+type StreamInfoReply struct {
+	Skipped bool      `json:"skipped"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// StreamReadArgs asks for up to Length bytes of the image at URL, starting
+// at Offset
+type StreamReadArgs struct {
+	URL    string `json:"url"`
+	Offset int64  `json:"offset"`
+	Length int    `json:"length"`
+}
+
+// StreamReadReply holds the data read, base64-encoded in JSON.  Fewer bytes
+// than requested are only returned at the end of the stream.
+type StreamReadReply struct {
+	Data []byte `json:"data"`
+}
+
+// DecodeInfoReply is the reply to Plugin.DecodeInfo.  If Skipped is true,
+// the plugin doesn't decode the given URL.  Levels is the number of
+// resolutions the plugin can decode, each half the size of the one before,
+// including the full resolution.  Images without tiles should report zero for
+// the tile dimensions and one for the levels.
+type DecodeInfoReply struct {
+	Skipped    bool `json:"skipped"`
+	Width      int  `json:"width"`
+	Height     int  `json:"height"`
+	TileWidth  int  `json:"tileWidth"`
+	TileHeight int  `json:"tileHeight"`
+	Levels     int  `json:"levels"`
+}
+
+// DecodeImageArgs asks for the region of the image at URL described by X, Y,
+// Width, and Height, resized to ResizeWidth x ResizeHeight
+type DecodeImageArgs struct {
+	URL          string `json:"url"`
+	X            int    `json:"x"`
+	Y            int    `json:"y"`
+	Width        int    `json:"width"`
+	Height       int    `json:"height"`
+	ResizeWidth  int    `json:"resizeWidth"`
+	ResizeHeight int    `json:"resizeHeight"`
+}
+
+// DecodeImageReply holds the decoded image as a PNG or JPEG, base64-encoded
+// in JSON
+type DecodeImageReply struct {
+	Data []byte `json:"data"`
+}
@@ -0,0 +1,57 @@
+package external
+
+import (
+	"io"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+)
+
+// stdio joins stdin and stdout into a single connection for a plugin
+type stdio struct {
+	io.Reader
+	io.Writer
+}
+
+// Close is a no-op, as a plugin's stdio lives as long as the plugin
+func (stdio) Close() error {
+	return nil
+}
+
+// Serve lets plugins written in Go answer RAIS's requests.  impl must have
+// methods matching the protocol, such as:
+//
+//	func (p *MyPlugin) Handshake(args external.HandshakeArgs, reply *external.HandshakeReply) error
+//	func (p *MyPlugin) IDToURL(args external.IDArgs, reply *external.URLReply) error
+//
+// Serve uses whichever transport RAIS launched the plugin with, and returns
+// when RAIS disconnects.
+func Serve(impl interface{}) error {
+	var server = rpc.NewServer()
+	var err = server.RegisterName("Plugin", impl)
+	if err != nil {
+		return err
+	}
+
+	var sock = os.Getenv(SocketEnv)
+	if sock == "" {
+		server.ServeCodec(jsonrpc.NewServerCodec(stdio{os.Stdin, os.Stdout}))
+		return nil
+	}
+
+	var l net.Listener
+	l, err = net.Listen("unix", sock)
+	if err != nil {
+		return err
+	}
+	defer l.Close()
+
+	var conn net.Conn
+	conn, err = l.Accept()
+	if err != nil {
+		return err
+	}
+	server.ServeCodec(jsonrpc.NewServerCodec(conn))
+	return nil
+}
@@ -1,4 +1,4 @@
-package main
+package imagickdecoder
 
 /*
 #cgo pkg-config: MagickCore
@@ -1,7 +1,7 @@
-// Package magick is a hacked up port of the minimal functionality we need
+// Package imagickdecoder is a hacked up port of the minimal functionality we need
 // to satisfy the img.Decoder interface.  Code is based in part on
 // github.com/quirkey/magick
-package main
+package imagickdecoder
 
 /*
 #cgo pkg-config: MagickCore
@@ -15,14 +15,27 @@
 	"path/filepath"
 	"rais/src/img"
 	"rais/src/plugins"
+	"rais/src/plugins/registry"
 	"strings"
 	"unsafe"
 
+	"github.com/spf13/viper"
 	"github.com/uoregon-libraries/gopkg/logger"
 )
 
 var l *logger.Logger
 
+// init makes the plugin available to be compiled into RAIS; it still has to
+// be enabled in the "Plugins" setting
+func init() {
+	registry.Register(&registry.Plugin{
+		Name:       "imagick-decoder",
+		APIVersion: registry.APIVersion,
+		SetLogger:  SetLogger,
+		Initialize: Initialize,
+	})
+}
+
 // SetLogger is called by the RAIS server's plugin manager to let plugins use
 // the central logger
 func SetLogger(raisLogger *logger.Logger) {
@@ -31,12 +44,13 @@ func SetLogger(raisLogger *logger.Logger) {
 
 // Initialize sets up the MagickCore stuff and registers the TIFF, PNG, JPG,
 // and GIF decoders
-func Initialize() {
+func Initialize(cfg *viper.Viper) error {
 	path, _ := os.Getwd()
 	cPath := C.CString(path)
 	defer C.free(unsafe.Pointer(cPath))
 	C.MagickCoreGenesis(cPath, C.MagickFalse)
 	img.RegisterDecodeHandler(decodeCommonFile)
+	return nil
 }
 
 func makeError(exception *C.ExceptionInfo) error {
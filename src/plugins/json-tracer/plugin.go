@@ -1,7 +1,11 @@
 // This file creates a plugin for instrumenting RAIS for internal use.  It
 // provides similar information as the DataDog plugin, but in a more "raw" way.
-// Usage will require a new configuration value, "TracerOut", or an environment
-// value in RAIS_TRACEROUT.
+// Usage will require a new configuration value, "Out", in the
+// [plugins.json-tracer] table of rais.toml, or an environment value in
+// RAIS_TRACEROUT.  "FlushSeconds" in the same table (or
+// RAIS_TRACERFLUSHSECONDS) sets how often traces are written.  The top-level
+// "TracerOut" and "TracerFlushSeconds" settings from older versions of RAIS
+// still work, but the plugin's table takes precedence.
 //
 // To avoid docker-compose file proliferation, this plugin doesn't provide an
 // example for stringing together docker-compose.blah.yml files.  To use this
@@ -11,10 +15,11 @@
 //   - RAIS_TRACEROUT=/tmp/rais-traces.json
 //   - RAIS_TRACERFLUSHSECONDS=10
 
-package main
+package jsontracer
 
 import (
 	"net/http"
+	plugreg "rais/src/plugins/registry"
 	"time"
 
 	"github.com/spf13/viper"
@@ -29,23 +34,40 @@
 // the global list unless sanity checks in Initialize() pass
 var Disabled = true
 
+// init makes the plugin available to be compiled into RAIS; it still has to
+// be enabled in the "Plugins" setting
+func init() {
+	plugreg.Register(&plugreg.Plugin{
+		Name:        "json-tracer",
+		APIVersion:  plugreg.APIVersion,
+		Disabled:    &Disabled,
+		SetLogger:   SetLogger,
+		Initialize:  Initialize,
+		WrapHandler: WrapHandler,
+		Teardown:    Teardown,
+	})
+}
+
 // flushTime is the duration after which events are flushed to disk
 var flushTime time.Duration
 
 // Initialize reads configuration and sets up the JSON output directory
-func Initialize() {
+func Initialize(cfg *viper.Viper) error {
 	viper.SetDefault("TracerFlushSeconds", 10)
-	flushTime = time.Second * time.Duration(viper.GetInt("TracerFlushSeconds"))
-	jsonOut = viper.GetString("TracerOut")
+	cfg.SetDefault("FlushSeconds", viper.GetInt("TracerFlushSeconds"))
+	cfg.SetDefault("Out", viper.GetString("TracerOut"))
+	flushTime = time.Second * time.Duration(cfg.GetInt("FlushSeconds"))
+	jsonOut = cfg.GetString("Out")
 
 	if jsonOut == "" {
-		l.Warnf("TracerOut must be configured, or RAIS_TRACEROUT must be set in the environment  **JSON Tracer plugin is disabled**")
-		return
+		l.Warnf("Out must be configured in [plugins.json-tracer], or RAIS_TRACEROUT must be set in the environment  **JSON Tracer plugin is disabled**")
+		return nil
 	}
 
 	reg = new(registry)
 
 	Disabled = false
+	return nil
 }
 
 // WrapHandler takes all RAIS routes' handlers and wraps them with the JSON
@@ -0,0 +1,6 @@
+// This builds the json-tracer plugin as a .so file for RAIS to load at runtime.
+// Importing the plugin registers it, so nothing else is needed here.
+
+package main
+
+import _ "rais/src/plugins/json-tracer"
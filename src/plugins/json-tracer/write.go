@@ -1,4 +1,4 @@
-package main
+package jsontracer
 
 import (
 	"encoding/json"
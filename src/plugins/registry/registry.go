@@ -0,0 +1,133 @@
+// Package registry lets plugins be compiled directly into RAIS rather than
+// loaded from a .so file.  A plugin package calls Register from its init
+// function, and a file in the server with a build tag imports the plugin
+// package, e.g.:
+//
+//	// +build plugin_foo
+//
+//	package main
+//
+//	import _ "example.org/rais-plugins/foo"
+//
+// Registered plugins are still only used if they're listed by name in the
+// "Plugins" setting.
+package registry
+
+import (
+	"fmt"
+	"image"
+	"net/http"
+	"net/url"
+	"rais/src/iiif"
+	"rais/src/img"
+	"sort"
+	"sync"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+// APIVersion is the version of the plugin API described by Plugin.  RAIS
+// refuses to load plugins which declare any other version.
+const APIVersion = 2
+
+// Plugin holds the functions a plugin exposes.  Each field corresponds to the
+// exported symbol of the same name which RAIS looks for in .so plugins.  Name
+// and APIVersion are required; the rest may be nil.
+//
+// Initialize is given the plugin's settings from its table in rais.toml, and
+// a plugin which returns an error from Initialize isn't used.
+//
+// TransformVersion is called once, after Initialize, by plugins which expose
+// TransformImage.  It should describe anything which affects the plugin's
+// output, such as a hash of an overlay image, since RAIS uses it in tile cache
+// keys.  Plugins without it are identified by a hash of their settings.
+type Plugin struct {
+	// Name is how the plugin is enabled in the "Plugins" setting, and names the
+	// [plugins.<name>] table in rais.toml which holds the plugin's settings
+	Name string
+
+	// APIVersion must be the APIVersion constant the plugin was written for
+	APIVersion int
+
+	// Disabled is checked after Initialize is called, and the plugin is
+	// skipped if it's true
+	Disabled *bool
+
+	SetLogger         func(*logger.Logger)
+	Initialize        func(*viper.Viper) error
+	Teardown          func()
+	WrapHandler       func(string, http.Handler) (http.Handler, error)
+	PurgeCaches       func()
+	ExpireCachedImage func(iiif.ID)
+	IDToURL           func(iiif.ID) (*url.URL, error)
+	TransformImage    func(iiif.ID, *iiif.URL, image.Image) (image.Image, error)
+	TransformVersion  func() string
+	AugmentInfo       func(iiif.ID, *iiif.Info) error
+	Authorize         func(*http.Request, iiif.ID, *iiif.URL) (bool, *img.Constraint, error)
+}
+
+// Validate returns an error if p doesn't declare a name or isn't compatible
+// with this version of RAIS
+func (p *Plugin) Validate() error {
+	if p.Name == "" {
+		return fmt.Errorf("plugin must declare a name")
+	}
+	if p.APIVersion != APIVersion {
+		return fmt.Errorf("plugin %q uses plugin API version %d, but RAIS requires version %d",
+			p.Name, p.APIVersion, APIVersion)
+	}
+	return nil
+}
+
+var m sync.Mutex
+var plugins = make(map[string]*Plugin)
+var failures []error
+
+// Register adds p to the list of compiled-in plugins.  If p isn't valid or
+// another plugin has already registered the same name, p is skipped and the
+// error is recorded for Errors to return, as this is meant to be called from
+// init functions where there's no way to handle an error.
+func Register(p *Plugin) {
+	m.Lock()
+	defer m.Unlock()
+
+	var err = p.Validate()
+	if err == nil && plugins[p.Name] != nil {
+		err = fmt.Errorf("plugin %q registered twice", p.Name)
+	}
+	if err != nil {
+		failures = append(failures, err)
+		return
+	}
+	plugins[p.Name] = p
+}
+
+// Errors returns the errors recorded for plugins which couldn't be
+// registered, in the order they were registered
+func Errors() []error {
+	m.Lock()
+	defer m.Unlock()
+	return append([]error(nil), failures...)
+}
+
+// Lookup returns the plugin registered with the given name, or nil if there
+// isn't one
+func Lookup(name string) *Plugin {
+	m.Lock()
+	defer m.Unlock()
+	return plugins[name]
+}
+
+// Names returns the names of all registered plugins, sorted alphabetically
+func Names() []string {
+	m.Lock()
+	defer m.Unlock()
+
+	var names []string
+	for name := range plugins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
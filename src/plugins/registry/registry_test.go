@@ -0,0 +1,29 @@
+package registry
+
+import (
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRegister(t *testing.T) {
+	Register(&Plugin{Name: "foo", APIVersion: APIVersion})
+	Register(&Plugin{Name: "bar", APIVersion: APIVersion})
+
+	assert.Equal("foo", Lookup("foo").Name, "foo is registered", t)
+	assert.True(Lookup("baz") == nil, "baz isn't registered", t)
+	assert.Equal(2, len(Names()), "two names are registered", t)
+	assert.Equal("bar", Names()[0], "names are sorted", t)
+
+	var expectError = func(p *Plugin, msg string) {
+		var failed = len(Errors())
+		Register(p)
+		assert.Equal(failed+1, len(Errors()), msg, t)
+	}
+	expectError(&Plugin{Name: "foo", APIVersion: APIVersion}, "duplicate names are recorded as errors")
+	expectError(&Plugin{Name: "old", APIVersion: 1}, "incompatible API versions are recorded as errors")
+	expectError(&Plugin{APIVersion: APIVersion}, "empty names are recorded as errors")
+
+	assert.True(Lookup("old") == nil, "incompatible plugins aren't registered", t)
+	assert.Equal(2, len(Names()), "invalid plugins don't add names", t)
+}
@@ -0,0 +1,114 @@
+// This file is an example of post-processing images after they're decoded and
+// transformed, but before they're encoded.  It stamps a semi-transparent
+// watermark in the bottom-right corner of every image at least a certain
+// width, leaving smaller images (such as most tiles) alone.
+//
+// "File" must be added to the [plugins.watermark] table in your rais.toml,
+// pointing to a PNG image.  Optionally, set "MinWidth" (default 800) to change
+// which images are watermarked, and "Opacity" (default 0.3) to make the
+// watermark more or less visible.
+//
+// Images are cached after this plugin runs.  TransformVersion reports a hash
+// of the watermark image and settings, which RAIS adds to its tile cache keys,
+// so changing the watermark never serves stale tiles.
+
+package watermark
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"rais/src/iiif"
+	"rais/src/plugins"
+	"rais/src/plugins/registry"
+
+	"github.com/spf13/viper"
+	"github.com/uoregon-libraries/gopkg/logger"
+)
+
+var l *logger.Logger
+var mark image.Image
+var minWidth int
+var opacity *image.Uniform
+var version string
+
+// Disabled lets the plugin manager know not to add this plugin's functions to
+// the global list unless sanity checks in Initialize() pass
+var Disabled = true
+
+// init makes the plugin available to be compiled into RAIS; it still has to
+// be enabled in the "Plugins" setting
+func init() {
+	registry.Register(&registry.Plugin{
+		Name:             "watermark",
+		APIVersion:       registry.APIVersion,
+		Disabled:         &Disabled,
+		SetLogger:        SetLogger,
+		Initialize:       Initialize,
+		TransformImage:   TransformImage,
+		TransformVersion: TransformVersion,
+	})
+}
+
+// SetLogger is called by the RAIS server's plugin manager to let plugins use
+// the central logger
+func SetLogger(raisLogger *logger.Logger) {
+	l = raisLogger
+}
+
+// Initialize reads configuration and loads the watermark image
+func Initialize(cfg *viper.Viper) error {
+	cfg.SetDefault("MinWidth", 800)
+	cfg.SetDefault("Opacity", 0.3)
+	var file = cfg.GetString("File")
+	if file == "" {
+		l.Warnf("File must be configured in [plugins.watermark]  **watermark plugin is disabled**")
+		return nil
+	}
+
+	var data, err = ioutil.ReadFile(file)
+	if err != nil {
+		l.Errorf("Unable to open watermark %q: %s  **watermark plugin is disabled**", file, err)
+		return nil
+	}
+
+	mark, err = png.Decode(bytes.NewReader(data))
+	if err != nil {
+		l.Errorf("Unable to decode watermark %q: %s  **watermark plugin is disabled**", file, err)
+		return nil
+	}
+
+	minWidth = cfg.GetInt("MinWidth")
+	var alpha = uint8(cfg.GetFloat64("Opacity") * 255)
+	opacity = image.NewUniform(color.Alpha{alpha})
+	var sum = sha256.Sum256(data)
+	version = fmt.Sprintf("%x-%d-%d", sum[:8], minWidth, alpha)
+	Disabled = false
+	return nil
+}
+
+// TransformVersion identifies the watermark image and settings in use, so
+// tiles cached with a different watermark aren't reused
+func TransformVersion() string {
+	return version
+}
+
+// TransformImage draws the watermark over images which are large enough to
+// need it
+func TransformImage(id iiif.ID, u *iiif.URL, m image.Image) (image.Image, error) {
+	var b, mb = m.Bounds(), mark.Bounds()
+	if b.Dx() < minWidth || b.Dx() < mb.Dx() || b.Dy() < mb.Dy() {
+		return nil, plugins.ErrSkipped
+	}
+
+	var dst = image.NewRGBA(b)
+	draw.Draw(dst, b, m, b.Min, draw.Src)
+	var r = image.Rect(b.Max.X-mb.Dx(), b.Max.Y-mb.Dy(), b.Max.X, b.Max.Y)
+	draw.DrawMask(dst, r, mark, mb.Min, opacity, image.Point{}, draw.Over)
+	return dst, nil
+}
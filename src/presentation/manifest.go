@@ -0,0 +1,178 @@
+// Package presentation builds simple IIIF Presentation API 3.0 manifests for
+// images RAIS serves
+package presentation
+
+import (
+	"fmt"
+	"path"
+	"rais/src/iiif"
+	"strconv"
+	"strings"
+)
+
+// Context is the JSON-LD context URI for Presentation 3.0 documents
+const Context = "http://iiif.io/api/presentation/3/context.json"
+
+// Label is a language map.  We never know what language a label is in, so
+// we always use "none" as the language.
+type Label map[string][]string
+
+// NewLabel returns a Label holding the given string
+func NewLabel(s string) Label {
+	return Label{"none": {s}}
+}
+
+// Manifest is the top-level Presentation resource describing an object made
+// up of one or more images
+type Manifest struct {
+	Context string    `json:"@context"`
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Label   Label     `json:"label"`
+	Items   []*Canvas `json:"items"`
+
+	base string
+}
+
+// Canvas is a single view of an object, such as a page of a book
+type Canvas struct {
+	ID     string            `json:"id"`
+	Type   string            `json:"type"`
+	Label  Label             `json:"label,omitempty"`
+	Width  int               `json:"width"`
+	Height int               `json:"height"`
+	Items  []*AnnotationPage `json:"items"`
+}
+
+// AnnotationPage holds the annotations which paint content onto a canvas
+type AnnotationPage struct {
+	ID    string        `json:"id"`
+	Type  string        `json:"type"`
+	Items []*Annotation `json:"items"`
+}
+
+// Annotation associates an image with the canvas it's painted onto
+type Annotation struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	Motivation string `json:"motivation"`
+	Body       *Image `json:"body"`
+	Target     string `json:"target"`
+}
+
+// Image is the content resource for a canvas: a IIIF image request plus the
+// image service clients use to request tiles and other sizes
+type Image struct {
+	ID      string    `json:"id"`
+	Type    string    `json:"type"`
+	Format  string    `json:"format"`
+	Width   int       `json:"width"`
+	Height  int       `json:"height"`
+	Service []Service `json:"service"`
+}
+
+// Service describes a IIIF Image API service.  Image API 2.x services use
+// JSON-LD's "@id" and "@type" keys, while 3.0 uses plain "id" and "type", so
+// only one of each pair should be set.  Service holds the image's own
+// services, such as those used for authorization.
+type Service struct {
+	LDID    string        `json:"@id,omitempty"`
+	LDType  string        `json:"@type,omitempty"`
+	ID      string        `json:"id,omitempty"`
+	Type    string        `json:"type,omitempty"`
+	Profile string        `json:"profile"`
+	Service []interface{} `json:"service,omitempty"`
+}
+
+// NewManifest returns a manifest with no canvases.  Its ID is base plus
+// "/manifest", and canvases and annotations get IDs under base as well.
+func NewManifest(base, label string) *Manifest {
+	return &Manifest{
+		Context: Context,
+		ID:      base + "/manifest",
+		Type:    "Manifest",
+		Label:   NewLabel(label),
+		Items:   []*Canvas{},
+		base:    base,
+	}
+}
+
+// AddImage appends a canvas which displays the image described by info.  The
+// info's ID must be set to its image service's URL.
+func (m *Manifest) AddImage(info *iiif.Info, label string) {
+	var n = strconv.Itoa(len(m.Items) + 1)
+	var c = &Canvas{
+		ID:     m.base + "/canvas/" + n,
+		Type:   "Canvas",
+		Width:  info.Width,
+		Height: info.Height,
+	}
+	if label != "" {
+		c.Label = NewLabel(label)
+	}
+
+	c.Items = []*AnnotationPage{{
+		ID:   m.base + "/page/" + n,
+		Type: "AnnotationPage",
+		Items: []*Annotation{{
+			ID:         m.base + "/annotation/" + n,
+			Type:       "Annotation",
+			Motivation: "painting",
+			Body:       NewImage(info),
+			Target:     c.ID,
+		}},
+	}}
+
+	m.Items = append(m.Items, c)
+}
+
+// NewImage returns the content resource for the image described by info.  If
+// info lists sizes, the largest is used, as it's a size the server has said it
+// can deliver; otherwise the full image is requested.
+func NewImage(info *iiif.Info) *Image {
+	var w, h = info.Width, info.Height
+	var size = "full"
+	if info.Version == iiif.V3 {
+		size = "max"
+	}
+	if len(info.Sizes) > 0 {
+		var largest = info.Sizes[0]
+		for _, s := range info.Sizes {
+			if s.Width > largest.Width {
+				largest = s
+			}
+		}
+		if largest.Width != w || largest.Height != h {
+			w, h = largest.Width, largest.Height
+			size = fmt.Sprintf("%d,", w)
+			if info.Version == iiif.V3 {
+				size = fmt.Sprintf("%d,%d", w, h)
+			}
+		}
+	}
+
+	return &Image{
+		ID:      info.ID + "/full/" + size + "/0/default.jpg",
+		Type:    "Image",
+		Format:  "image/jpeg",
+		Width:   w,
+		Height:  h,
+		Service: []Service{NewService(info)},
+	}
+}
+
+// NewService returns the image service block for info, in the form the
+// Presentation API requires for the info's Image API version
+func NewService(info *iiif.Info) Service {
+	var profile = info.ProfileURI()
+	if info.Version == iiif.V3 {
+		return Service{
+			ID:      info.ID,
+			Type:    "ImageService3",
+			Profile: strings.TrimSuffix(path.Base(profile), ".json"),
+			Service: info.Service,
+		}
+	}
+
+	return Service{LDID: info.ID, LDType: "ImageService2", Profile: profile, Service: info.Service}
+}
@@ -0,0 +1,105 @@
+package presentation
+
+import (
+	"bytes"
+	"encoding/json"
+	"rais/src/iiif"
+	"reflect"
+	"testing"
+)
+
+func compact(s string, t *testing.T) string {
+	var buf bytes.Buffer
+	var err = json.Compact(&buf, []byte(s))
+	if err != nil {
+		t.Fatalf("invalid JSON %q: %s", s, err)
+	}
+	return buf.String()
+}
+
+func TestManifestV3(t *testing.T) {
+	var info = iiif.AllFeatures().Info()
+	info.ID = "https://example.org/iiif/3/page1.jp2"
+	info.Version = iiif.V3
+	info.Width, info.Height = 1000, 800
+
+	var m = NewManifest("https://example.org/presentation/page1.jp2", "page1.jp2")
+	m.AddImage(info, "")
+
+	var data, err = json.Marshal(m)
+	if err != nil {
+		t.Fatalf("Unable to marshal manifest: %s", err)
+	}
+
+	var expected = compact(`{
+		"@context": "http://iiif.io/api/presentation/3/context.json",
+		"id": "https://example.org/presentation/page1.jp2/manifest",
+		"type": "Manifest",
+		"label": {"none": ["page1.jp2"]},
+		"items": [{
+			"id": "https://example.org/presentation/page1.jp2/canvas/1",
+			"type": "Canvas",
+			"width": 1000,
+			"height": 800,
+			"items": [{
+				"id": "https://example.org/presentation/page1.jp2/page/1",
+				"type": "AnnotationPage",
+				"items": [{
+					"id": "https://example.org/presentation/page1.jp2/annotation/1",
+					"type": "Annotation",
+					"motivation": "painting",
+					"body": {
+						"id": "https://example.org/iiif/3/page1.jp2/full/max/0/default.jpg",
+						"type": "Image",
+						"format": "image/jpeg",
+						"width": 1000,
+						"height": 800,
+						"service": [{"id": "https://example.org/iiif/3/page1.jp2", "type": "ImageService3", "profile": "level2"}]
+					},
+					"target": "https://example.org/presentation/page1.jp2/canvas/1"
+				}]
+			}]
+		}]
+	}`, t)
+	if string(data) != expected {
+		t.Errorf("Expected manifest:\n%s\nGot:\n%s", expected, data)
+	}
+}
+
+func TestManifestV2MultipleCanvases(t *testing.T) {
+	var m = NewManifest("https://example.org/presentation/book", "My Book")
+	for i, id := range []string{"page1", "page2"} {
+		var info = iiif.FeatureSet1().Info()
+		info.ID = "https://example.org/iiif/" + id
+		info.Width, info.Height = 4000, 3000+i
+		info.Sizes = []iiif.ImageSize{{Width: 1000, Height: 750}, {Width: 2000, Height: 1500}}
+		info.Service = []interface{}{"https://example.org/auth/" + id}
+		m.AddImage(info, id)
+	}
+
+	if len(m.Items) != 2 {
+		t.Fatalf("Expected 2 canvases, got %d", len(m.Items))
+	}
+
+	var c = m.Items[1]
+	if c.ID != "https://example.org/presentation/book/canvas/2" {
+		t.Errorf("Unexpected canvas ID %q", c.ID)
+	}
+	if c.Width != 4000 || c.Height != 3001 {
+		t.Errorf("Canvas should use the image's full dimensions; got %d x %d", c.Width, c.Height)
+	}
+
+	var body = c.Items[0].Items[0].Body
+	if body.ID != "https://example.org/iiif/page2/full/2000,/0/default.jpg" {
+		t.Errorf("Body should request the largest listed size; got %q", body.ID)
+	}
+	var expected = Service{
+		LDID:    "https://example.org/iiif/page2",
+		LDType:  "ImageService2",
+		Profile: "http://iiif.io/api/image/2/level1.json",
+		Service: []interface{}{"https://example.org/auth/page2"},
+	}
+	if !reflect.DeepEqual(body.Service[0], expected) {
+		t.Errorf("Expected service %#v, got %#v", expected, body.Service[0])
+	}
+}
@@ -0,0 +1,179 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// cacheBits is the per-channel precision of the nearest-color cache used for
+// non-gray images.  At six bits, a cached lookup is never more than a couple
+// of levels off, which is invisible once an image is reduced to 256 colors.
+const cacheBits = 6
+
+// Drawer is a draw.Drawer which maps images onto a palette far faster than
+// the standard library by caching nearest-color lookups.  If Dither is true,
+// Floyd-Steinberg error diffusion is applied.
+//
+// Drawer only handles *image.Paletted destinations; anything else is handed
+// off to the standard library's drawers.
+type Drawer struct {
+	Dither bool
+}
+
+// Draw implements draw.Drawer
+func (d Drawer) Draw(dst draw.Image, r image.Rectangle, src image.Image, sp image.Point) {
+	var pm, ok = dst.(*image.Paletted)
+	if !ok || len(pm.Palette) == 0 {
+		if d.Dither {
+			draw.FloydSteinberg.Draw(dst, r, src, sp)
+		} else {
+			draw.Draw(dst, r, src, sp, draw.Src)
+		}
+		return
+	}
+
+	// Clip the same way the standard library does
+	var orig = r.Min
+	r = r.Intersect(dst.Bounds()).Intersect(src.Bounds().Add(orig.Sub(sp)))
+	if r.Empty() {
+		return
+	}
+	sp = sp.Add(r.Min.Sub(orig))
+
+	var p = newPaletteMap(pm.Palette)
+	var lookup = p.nearestCached
+	if _, isGray := src.(*image.Gray); isGray {
+		lookup = p.nearestGray
+	}
+
+	// Error rows have a one-pixel border on each side so diffusion needn't
+	// check boundaries
+	var w = r.Dx()
+	var cur, next [][3]int32
+	if d.Dither {
+		cur, next = make([][3]int32, w+2), make([][3]int32, w+2)
+	}
+
+	for y := 0; y < r.Dy(); y++ {
+		var out = pm.Pix[pm.PixOffset(r.Min.X, r.Min.Y+y):]
+		for x := 0; x < w; x++ {
+			var c = pixel(src, sp.X+x, sp.Y+y)
+			if d.Dither {
+				for i := 0; i < 3; i++ {
+					c[i] = clamp(c[i] + (cur[x+1][i]+8)>>4)
+				}
+			}
+
+			var idx = lookup(c)
+			out[x] = idx
+			if !d.Dither {
+				continue
+			}
+
+			var pc = p.colors[idx]
+			for i := 0; i < 3; i++ {
+				var e = c[i] - pc[i]
+				cur[x+2][i] += e * 7
+				next[x][i] += e * 3
+				next[x+1][i] += e * 5
+				next[x+2][i] += e
+			}
+		}
+
+		if d.Dither {
+			cur, next = next, cur
+			for i := range next {
+				next[i] = [3]int32{}
+			}
+		}
+	}
+}
+
+// pixel returns the 8-bit RGB values of src at x, y, avoiding the color
+// interface for the image types RAIS normally produces
+func pixel(src image.Image, x, y int) [3]int32 {
+	switch img := src.(type) {
+	case *image.RGBA:
+		var i = img.PixOffset(x, y)
+		return [3]int32{int32(img.Pix[i]), int32(img.Pix[i+1]), int32(img.Pix[i+2])}
+	case *image.Gray:
+		var v = int32(img.Pix[img.PixOffset(x, y)])
+		return [3]int32{v, v, v}
+	}
+
+	var r, g, b, _ = src.At(x, y).RGBA()
+	return [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+}
+
+func clamp(v int32) int32 {
+	if v < 0 {
+		return 0
+	}
+	if v > 255 {
+		return 255
+	}
+	return v
+}
+
+// paletteMap finds the nearest palette entry for colors, caching results
+type paletteMap struct {
+	colors [][3]int32
+	cache  []int16
+	gray   [256]int16
+}
+
+func newPaletteMap(p color.Palette) *paletteMap {
+	var m = &paletteMap{
+		colors: make([][3]int32, len(p)),
+		cache:  make([]int16, 1<<(cacheBits*3)),
+	}
+	for i, c := range p {
+		var r, g, b, _ = c.RGBA()
+		m.colors[i] = [3]int32{int32(r >> 8), int32(g >> 8), int32(b >> 8)}
+	}
+	for i := range m.cache {
+		m.cache[i] = -1
+	}
+	for i := range m.gray {
+		m.gray[i] = -1
+	}
+	return m
+}
+
+// nearest returns the index of the palette color closest to c by squared
+// euclidean distance
+func (m *paletteMap) nearest(c [3]int32) uint8 {
+	var best, bestDist = 0, int32(1<<31 - 1)
+	for i, pc := range m.colors {
+		var dr, dg, db = c[0] - pc[0], c[1] - pc[1], c[2] - pc[2]
+		var dist = dr*dr + dg*dg + db*db
+		if dist < bestDist {
+			best, bestDist = i, dist
+			if dist == 0 {
+				break
+			}
+		}
+	}
+	return uint8(best)
+}
+
+// nearestCached looks up the nearest color for the center of c's cache cell
+func (m *paletteMap) nearestCached(c [3]int32) uint8 {
+	const shift = 8 - cacheBits
+	const half = 1 << (shift - 1)
+	var key = c[0]>>shift<<(cacheBits*2) | c[1]>>shift<<cacheBits | c[2]>>shift
+	if m.cache[key] < 0 {
+		m.cache[key] = int16(m.nearest([3]int32{c[0]>>shift<<shift | half, c[1]>>shift<<shift | half, c[2]>>shift<<shift | half}))
+	}
+	return uint8(m.cache[key])
+}
+
+// nearestGray is an exact lookup for gray colors, so gray palettes don't
+// lose precision to the cache.  Only the red channel is examined.
+func (m *paletteMap) nearestGray(c [3]int32) uint8 {
+	if m.gray[c[0]] < 0 {
+		m.gray[c[0]] = int16(m.nearest([3]int32{c[0], c[0], c[0]}))
+	}
+	return uint8(m.gray[c[0]])
+}
@@ -0,0 +1,90 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestDrawerExact(t *testing.T) {
+	var src = fourColors()
+	var p = MedianCut{}.Quantize(make(color.Palette, 0, 256), src)
+	var dst = image.NewPaletted(src.Rect, p)
+	Drawer{}.Draw(dst, dst.Rect, src, image.Point{})
+
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			assert.Equal(src.At(x, y), dst.At(x, y), "exact palette reproduces the image", t)
+		}
+	}
+}
+
+func TestDrawerMatchesStandardLibrary(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i * 7)
+	}
+	var p = MedianCut{}.Quantize(make(color.Palette, 0, 32), src)
+
+	var ours = image.NewPaletted(src.Rect, p)
+	Drawer{}.Draw(ours, ours.Rect, src, image.Point{})
+	var theirs = image.NewPaletted(src.Rect, p)
+	draw.Draw(theirs, theirs.Rect, src, image.Point{}, draw.Src)
+
+	var diffs int
+	for i := range ours.Pix {
+		if ours.Pix[i] != theirs.Pix[i] {
+			diffs++
+		}
+	}
+	assert.True(diffs < len(ours.Pix)/20, "cached lookups are nearly always the true nearest color", t)
+}
+
+func TestDrawerDither(t *testing.T) {
+	var src = image.NewGray(image.Rect(0, 0, 100, 100))
+	for i := range src.Pix {
+		src.Pix[i] = 128
+	}
+
+	var dst = image.NewPaletted(src.Rect, Bitonal)
+	Drawer{}.Draw(dst, dst.Rect, src, image.Point{})
+	var white = 0
+	for _, idx := range dst.Pix {
+		white += int(idx)
+	}
+	assert.Equal(10000, white, "undithered mid-gray is all white", t)
+
+	Drawer{Dither: true}.Draw(dst, dst.Rect, src, image.Point{})
+	white = 0
+	for _, idx := range dst.Pix {
+		white += int(idx)
+	}
+	assert.True(white > 4500 && white < 5500, "dithered mid-gray is roughly half white", t)
+}
+
+func TestDrawerOffsets(t *testing.T) {
+	var src = fourColors().SubImage(image.Rect(10, 10, 20, 20))
+	var dst = image.NewPaletted(image.Rect(5, 5, 15, 15), Bitonal)
+	Drawer{}.Draw(dst, dst.Rect, src, image.Pt(10, 10))
+	assert.Equal(color.Gray{0}, dst.At(5, 5), "dark gray maps to black", t)
+	assert.Equal(color.Gray{0}, dst.At(14, 14), "last pixel is drawn", t)
+}
+
+func TestGrayPalette(t *testing.T) {
+	var p = GrayPalette(256)
+	assert.Equal(256, len(p), "palette size", t)
+	assert.Equal(color.Gray{0}, p[0], "first entry is black", t)
+	assert.Equal(color.Gray{137}, p[137], "entries map directly to gray levels", t)
+	assert.Equal(color.Gray{255}, p[255], "last entry is white", t)
+}
+
+func TestIsBitonal(t *testing.T) {
+	var m = image.NewGray(image.Rect(0, 0, 4, 4))
+	m.Pix[3] = 255
+	assert.True(IsBitonal(m), "black and white image", t)
+	m.Pix[5] = 254
+	assert.False(IsBitonal(m), "image with a gray pixel", t)
+}
@@ -0,0 +1,183 @@
+// Package quantize reduces images to a limited palette, primarily so RAIS can
+// produce decent-looking GIFs.  MedianCut implements draw.Quantizer and Drawer
+// implements draw.Drawer, so both can be handed directly to gif.Encode.
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"sort"
+)
+
+// histogramBits is the number of bits per channel used to bucket colors.
+// Five bits gives us 32768 buckets, which keeps the histogram small while
+// still being far more precise than a 256-color palette can represent.
+const histogramBits = 5
+
+// bucket holds the pixel count and channel sums for all colors which fall
+// into a single histogram cell
+type bucket struct {
+	key        [3]uint8
+	count      uint64
+	sumR, sumG uint64
+	sumB       uint64
+}
+
+// average returns the mean color of all pixels in the bucket(s)
+func average(buckets []bucket) color.RGBA {
+	var count, r, g, b uint64
+	for _, bk := range buckets {
+		count += bk.count
+		r += bk.sumR
+		g += bk.sumG
+		b += bk.sumB
+	}
+	return color.RGBA{uint8(r / count), uint8(g / count), uint8(b / count), 255}
+}
+
+// box is a set of buckets which will eventually become one palette entry
+type box struct {
+	buckets []bucket
+	count   uint64
+	axis    int
+	span    uint8
+}
+
+func newBox(buckets []bucket) *box {
+	var b = &box{buckets: buckets}
+	var lo, hi = [3]uint8{255, 255, 255}, [3]uint8{}
+	for _, bk := range buckets {
+		b.count += bk.count
+		for c := 0; c < 3; c++ {
+			if bk.key[c] < lo[c] {
+				lo[c] = bk.key[c]
+			}
+			if bk.key[c] > hi[c] {
+				hi[c] = bk.key[c]
+			}
+		}
+	}
+
+	for c := 0; c < 3; c++ {
+		if hi[c]-lo[c] > b.span {
+			b.axis, b.span = c, hi[c]-lo[c]
+		}
+	}
+	return b
+}
+
+// priority determines which box gets split next.  Weighting the color range
+// by pixel count avoids spending palette entries on rare outlier colors, while
+// still splitting large areas of subtle gradients.
+func (b *box) priority() uint64 {
+	return uint64(b.span) * b.count
+}
+
+// split divides the box at the median pixel along its widest axis
+func (b *box) split() (*box, *box) {
+	var axis = b.axis
+	sort.Slice(b.buckets, func(i, j int) bool {
+		return b.buckets[i].key[axis] < b.buckets[j].key[axis]
+	})
+
+	var half = b.count / 2
+	var sum uint64
+	var i = 1
+	for ; i < len(b.buckets)-1; i++ {
+		sum += b.buckets[i-1].count
+		if sum >= half {
+			break
+		}
+	}
+
+	return newBox(b.buckets[:i]), newBox(b.buckets[i:])
+}
+
+// MedianCut is a draw.Quantizer which builds a palette using Heckbert's
+// median cut algorithm
+type MedianCut struct{}
+
+// Quantize appends up to cap(p)-len(p) colors to p which best represent the
+// colors in m
+func (MedianCut) Quantize(p color.Palette, m image.Image) color.Palette {
+	var n = cap(p) - len(p)
+	if n <= 0 {
+		return p
+	}
+
+	var buckets = histogram(m)
+	if len(buckets) == 0 {
+		return p
+	}
+
+	var boxes = []*box{newBox(buckets)}
+	for len(boxes) < n {
+		var best = -1
+		for i, b := range boxes {
+			if len(b.buckets) > 1 && (best < 0 || b.priority() > boxes[best].priority()) {
+				best = i
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		var b1, b2 = boxes[best].split()
+		boxes[best] = b1
+		boxes = append(boxes, b2)
+	}
+
+	for _, b := range boxes {
+		p = append(p, average(b.buckets))
+	}
+	return p
+}
+
+// histogram buckets every pixel in m, returning only the buckets which have
+// at least one pixel
+func histogram(m image.Image) []bucket {
+	const shift = 8 - histogramBits
+	var cells = make([]bucket, 1<<(histogramBits*3))
+	var add = func(r, g, b uint8) {
+		var k = [3]uint8{r >> shift, g >> shift, b >> shift}
+		var cell = &cells[int(k[0])<<(histogramBits*2)|int(k[1])<<histogramBits|int(k[2])]
+		cell.key = k
+		cell.count++
+		cell.sumR += uint64(r)
+		cell.sumG += uint64(g)
+		cell.sumB += uint64(b)
+	}
+
+	var bounds = m.Bounds()
+	switch img := m.(type) {
+	case *image.RGBA:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			var pix = img.Pix[img.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				add(pix[x*4], pix[x*4+1], pix[x*4+2])
+			}
+		}
+	case *image.Gray:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			var pix = img.Pix[img.PixOffset(bounds.Min.X, y):]
+			for x := 0; x < bounds.Dx(); x++ {
+				add(pix[x], pix[x], pix[x])
+			}
+		}
+	default:
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				var r, g, b, _ = m.At(x, y).RGBA()
+				add(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+			}
+		}
+	}
+
+	var used []bucket
+	for _, cell := range cells {
+		if cell.count > 0 {
+			used = append(used, cell)
+		}
+	}
+	return used
+}
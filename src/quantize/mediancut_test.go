@@ -0,0 +1,50 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func fourColors() *image.RGBA {
+	var m = image.NewRGBA(image.Rect(0, 0, 20, 20))
+	var colors = []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {40, 40, 40, 255}}
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			m.SetRGBA(x, y, colors[(x/10)+(y/10)*2])
+		}
+	}
+	return m
+}
+
+func TestMedianCutFewColors(t *testing.T) {
+	var p = MedianCut{}.Quantize(make(color.Palette, 0, 256), fourColors())
+	assert.Equal(4, len(p), "palette has one entry per distinct color", t)
+	assert.True(p.Index(color.RGBA{255, 0, 0, 255}) >= 0, "red is in the palette", t)
+	assert.Equal(color.RGBA{40, 40, 40, 255}, p[p.Index(color.RGBA{40, 40, 40, 255})], "exact gray is in the palette", t)
+}
+
+func TestMedianCutLimit(t *testing.T) {
+	var m = image.NewRGBA(image.Rect(0, 0, 256, 256))
+	for y := 0; y < 256; y++ {
+		for x := 0; x < 256; x++ {
+			m.SetRGBA(x, y, color.RGBA{uint8(x), uint8(y), uint8(x ^ y), 255})
+		}
+	}
+
+	var p = MedianCut{}.Quantize(make(color.Palette, 0, 16), m)
+	assert.Equal(16, len(p), "palette is limited to the requested size", t)
+
+	p = MedianCut{}.Quantize(make(color.Palette, 0, 256), m)
+	assert.Equal(256, len(p), "palette fills up when there are enough colors", t)
+}
+
+func TestMedianCutExistingPalette(t *testing.T) {
+	var p = make(color.Palette, 1, 3)
+	p[0] = color.Transparent
+	p = MedianCut{}.Quantize(p, fourColors())
+	assert.Equal(3, len(p), "only the remaining capacity is filled", t)
+	assert.Equal(color.Transparent, p[0], "existing entries are kept", t)
+}
@@ -0,0 +1,33 @@
+package quantize
+
+import (
+	"image"
+	"image/color"
+)
+
+// Bitonal is a palette for images which are only black and white
+var Bitonal = color.Palette{color.Gray{0}, color.Gray{255}}
+
+// GrayPalette returns a palette of n evenly spaced grays from black to white.
+// n must be between 2 and 256.
+func GrayPalette(n int) color.Palette {
+	var p = make(color.Palette, n)
+	for i := range p {
+		p[i] = color.Gray{uint8(i * 255 / (n - 1))}
+	}
+	return p
+}
+
+// IsBitonal returns true if every pixel in m is pure black or pure white
+func IsBitonal(m *image.Gray) bool {
+	var b = m.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		var pix = m.Pix[m.PixOffset(b.Min.X, y):]
+		for x := 0; x < b.Dx(); x++ {
+			if pix[x] != 0 && pix[x] != 255 {
+				return false
+			}
+		}
+	}
+	return true
+}
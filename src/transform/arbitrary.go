@@ -0,0 +1,144 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+)
+
+// RotatedBounds returns the width and height of the bounding box needed to
+// hold a w x h image rotated by the given number of degrees.  Per the IIIF
+// spec, this is the size of the image returned for an arbitrary rotation.
+func RotatedBounds(w, h int, degrees float64) (int, int) {
+	var sin, cos = math.Sincos(degrees * math.Pi / 180)
+	sin, cos = math.Abs(sin), math.Abs(cos)
+	var fw, fh = float64(w), float64(h)
+
+	return int(math.Round(fw*cos + fh*sin)), int(math.Round(fw*sin + fh*cos))
+}
+
+// Rotate returns a new image holding src rotated clockwise by the given
+// number of degrees.  The new image's dimensions are the bounding box of the
+// rotated source, and any area not covered by the source is filled with bg.
+// Pixels are computed with bilinear interpolation, so the source's edges are
+// smoothly blended into the background.
+//
+// The returned image is an *image.Gray if src is grayscale and bg is an
+// opaque gray; otherwise it's an *image.RGBA.
+func Rotate(src image.Image, degrees float64, bg color.Color) image.Image {
+	var s = newSampler(src, bg)
+	var b = src.Bounds()
+	var w, h = RotatedBounds(b.Dx(), b.Dy(), degrees)
+	var dst = image.NewRGBA(image.Rect(0, 0, w, h))
+
+	// We walk the destination pixels and use the inverse rotation to find
+	// where each one came from, measuring everything from pixel centers
+	var sin, cos = math.Sincos(degrees * math.Pi / 180)
+	var srcCX, srcCY = float64(b.Dx()) / 2, float64(b.Dy()) / 2
+	var dstCX, dstCY = float64(w) / 2, float64(h) / 2
+	for y := 0; y < h; y++ {
+		var dy = float64(y) + 0.5 - dstCY
+		for x := 0; x < w; x++ {
+			var dx = float64(x) + 0.5 - dstCX
+			var sx = dx*cos + dy*sin + srcCX - 0.5
+			var sy = -dx*sin + dy*cos + srcCY - 0.5
+
+			var px = s.bilinear(sx, sy)
+			var i = dst.PixOffset(x, y)
+			dst.Pix[i] = clampByte(px[0])
+			dst.Pix[i+1] = clampByte(px[1])
+			dst.Pix[i+2] = clampByte(px[2])
+			dst.Pix[i+3] = clampByte(px[3])
+		}
+	}
+
+	if !s.grayOut {
+		return dst
+	}
+
+	var gray = image.NewGray(dst.Rect)
+	for i := range gray.Pix {
+		gray.Pix[i] = dst.Pix[i<<2]
+	}
+	return gray
+}
+
+// sampler reads premultiplied pixel data from a Gray or RGBA image, returning
+// the background color for any point outside the image
+type sampler struct {
+	gray    *image.Gray
+	rgba    *image.RGBA
+	w, h    int
+	bg      [4]float64
+	grayOut bool
+}
+
+func newSampler(src image.Image, bg color.Color) *sampler {
+	var s = new(sampler)
+	var r, g, b, a = bg.RGBA()
+	s.bg = [4]float64{float64(r >> 8), float64(g >> 8), float64(b >> 8), float64(a >> 8)}
+
+	switch img := src.(type) {
+	case *image.Gray:
+		s.gray = img
+		s.grayOut = r == g && g == b && a == 0xffff
+	case *image.RGBA:
+		s.rgba = img
+	default:
+		var bounds = src.Bounds()
+		s.rgba = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(s.rgba, s.rgba.Rect, src, bounds.Min, draw.Src)
+	}
+
+	var bounds = src.Bounds()
+	s.w, s.h = bounds.Dx(), bounds.Dy()
+	return s
+}
+
+// at returns the pixel at x, y relative to the image's top-left corner
+func (s *sampler) at(x, y int) [4]float64 {
+	if x < 0 || y < 0 || x >= s.w || y >= s.h {
+		return s.bg
+	}
+
+	if s.gray != nil {
+		var min = s.gray.Rect.Min
+		var v = float64(s.gray.Pix[s.gray.PixOffset(min.X+x, min.Y+y)])
+		return [4]float64{v, v, v, 255}
+	}
+
+	var min = s.rgba.Rect.Min
+	var i = s.rgba.PixOffset(min.X+x, min.Y+y)
+	var p = s.rgba.Pix[i : i+4 : i+4]
+	return [4]float64{float64(p[0]), float64(p[1]), float64(p[2]), float64(p[3])}
+}
+
+// bilinear returns the interpolated pixel value at the given fractional
+// coordinates
+func (s *sampler) bilinear(x, y float64) [4]float64 {
+	var x0, y0 = math.Floor(x), math.Floor(y)
+	var fx, fy = x - x0, y - y0
+	var ix, iy = int(x0), int(y0)
+
+	var tl, tr = s.at(ix, iy), s.at(ix+1, iy)
+	var bl, br = s.at(ix, iy+1), s.at(ix+1, iy+1)
+
+	var out [4]float64
+	for c := 0; c < 4; c++ {
+		var top = tl[c] + (tr[c]-tl[c])*fx
+		var bottom = bl[c] + (br[c]-bl[c])*fx
+		out[c] = top + (bottom-top)*fy
+	}
+	return out
+}
+
+func clampByte(v float64) uint8 {
+	if v <= 0 {
+		return 0
+	}
+	if v >= 255 {
+		return 255
+	}
+	return uint8(v + 0.5)
+}
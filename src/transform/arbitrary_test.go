@@ -0,0 +1,75 @@
+package transform
+
+import (
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func TestRotatedBounds(t *testing.T) {
+	var w, h = RotatedBounds(400, 200, 90)
+	assert.Equal(200, w, "90-degree width", t)
+	assert.Equal(400, h, "90-degree height", t)
+
+	w, h = RotatedBounds(400, 200, 180)
+	assert.Equal(400, w, "180-degree width", t)
+	assert.Equal(200, h, "180-degree height", t)
+
+	w, h = RotatedBounds(100, 100, 45)
+	assert.Equal(141, w, "45-degree width", t)
+	assert.Equal(141, h, "45-degree height", t)
+}
+
+func TestRotateTransparent(t *testing.T) {
+	var src = image.NewRGBA(image.Rect(0, 0, 100, 50))
+	for i := range src.Pix {
+		src.Pix[i] = 255
+	}
+
+	var dst = Rotate(src, 30, color.Transparent)
+	var rgba, ok = dst.(*image.RGBA)
+	assert.True(ok, "RGBA source returns an RGBA image", t)
+
+	var w, h = RotatedBounds(100, 50, 30)
+	assert.Equal(w, rgba.Rect.Dx(), "width is the bounding box width", t)
+	assert.Equal(h, rgba.Rect.Dy(), "height is the bounding box height", t)
+	assert.Equal(uint8(0), rgba.RGBAAt(0, 0).A, "corner is transparent", t)
+	assert.Equal(uint8(255), rgba.RGBAAt(w/2, h/2).A, "center is opaque", t)
+	assert.Equal(uint8(255), rgba.RGBAAt(w/2, h/2).R, "center is the source color", t)
+}
+
+func TestRotateGray(t *testing.T) {
+	var src = image.NewGray(image.Rect(0, 0, 100, 50))
+	var dst = Rotate(src, 10, color.White)
+	var gray, ok = dst.(*image.Gray)
+	assert.True(ok, "Gray source with a gray background returns a Gray image", t)
+	assert.Equal(uint8(255), gray.GrayAt(0, 0).Y, "corner is the background color", t)
+	assert.Equal(uint8(0), gray.GrayAt(gray.Rect.Dx()/2, gray.Rect.Dy()/2).Y, "center is the source color", t)
+
+	dst = Rotate(src, 10, color.RGBA{255, 0, 0, 255})
+	_, ok = dst.(*image.RGBA)
+	assert.True(ok, "Gray source with a colored background returns an RGBA image", t)
+}
+
+func TestRotateSubImage(t *testing.T) {
+	// Only the right half of the source is white, and only the right half is
+	// rotated
+	var src = image.NewRGBA(image.Rect(0, 0, 200, 100))
+	var gsrc = image.NewGray(image.Rect(0, 0, 200, 100))
+	for y := 0; y < 100; y++ {
+		for x := 100; x < 200; x++ {
+			src.SetRGBA(x, y, color.RGBA{255, 255, 255, 255})
+			gsrc.SetGray(x, y, color.Gray{255})
+		}
+	}
+
+	var rgba = Rotate(src.SubImage(image.Rect(100, 0, 200, 100)), 30, color.Black).(*image.RGBA)
+	var w, h = rgba.Rect.Dx(), rgba.Rect.Dy()
+	assert.Equal(uint8(255), rgba.RGBAAt(w/2, h/2).R, "RGBA sub-image's own pixels are used", t)
+
+	var gray = Rotate(gsrc.SubImage(image.Rect(100, 0, 200, 100)), 30, color.Black).(*image.Gray)
+	w, h = gray.Rect.Dx(), gray.Rect.Dy()
+	assert.Equal(uint8(255), gray.GrayAt(w/2, h/2).Y, "Gray sub-image's own pixels are used", t)
+}
@@ -0,0 +1,74 @@
+// Package webp wraps libwebp's simple encoding API so RAIS can deliver WebP
+// images.  Only encoding is supported; RAIS has no need to read WebP sources.
+package webp
+
+// #cgo pkg-config: libwebp
+// #include <stdlib.h>
+// #include <webp/decode.h>
+// #include <webp/encode.h>
+import "C"
+
+import (
+	"errors"
+	"image"
+	"image/draw"
+	"io"
+	"unsafe"
+)
+
+// DefaultQuality is used when Encode is given nil options
+const DefaultQuality = 75
+
+// ErrEncodeFailed is returned when libwebp is unable to encode an image
+var ErrEncodeFailed = errors.New("webp: unable to encode image")
+
+// Options are the encoding parameters.  Quality ranges from 0 to 100 and is
+// ignored for lossless encoding.
+type Options struct {
+	Quality  float32
+	Lossless bool
+}
+
+// Encode writes the image m to w in WebP format with the given options.  A
+// nil options value results in lossy encoding at DefaultQuality.
+func Encode(w io.Writer, m image.Image, o *Options) error {
+	if o == nil {
+		o = &Options{Quality: DefaultQuality}
+	}
+
+	var src = toNRGBA(m)
+	var b = src.Rect
+	if b.Empty() {
+		return ErrEncodeFailed
+	}
+
+	var out *C.uint8_t
+	var pix = (*C.uint8_t)(unsafe.Pointer(&src.Pix[0]))
+	var width, height, stride = C.int(b.Dx()), C.int(b.Dy()), C.int(src.Stride)
+	var size C.size_t
+	if o.Lossless {
+		size = C.WebPEncodeLosslessRGBA(pix, width, height, stride, &out)
+	} else {
+		size = C.WebPEncodeRGBA(pix, width, height, stride, C.float(o.Quality), &out)
+	}
+	if size == 0 || out == nil {
+		return ErrEncodeFailed
+	}
+	defer C.WebPFree(unsafe.Pointer(out))
+
+	var _, err = w.Write(C.GoBytes(unsafe.Pointer(out), C.int(size)))
+	return err
+}
+
+// toNRGBA returns m as a zero-origin NRGBA image, which is the pixel layout
+// libwebp's RGBA functions expect
+func toNRGBA(m image.Image) *image.NRGBA {
+	if n, ok := m.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+
+	var b = m.Bounds()
+	var n = image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(n, n.Rect, m, b.Min, draw.Src)
+	return n
+}
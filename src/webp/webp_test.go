@@ -0,0 +1,53 @@
+package webp
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+
+	"github.com/uoregon-libraries/gopkg/assert"
+)
+
+func testImage() image.Image {
+	var m = image.NewRGBA(image.Rect(10, 10, 74, 42))
+	for y := m.Rect.Min.Y; y < m.Rect.Max.Y; y++ {
+		for x := m.Rect.Min.X; x < m.Rect.Max.X; x++ {
+			m.Set(x, y, color.RGBA{uint8(x * 3), uint8(y * 5), 128, 255})
+		}
+	}
+	return m
+}
+
+func assertWebP(data []byte, t *testing.T) {
+	assert.True(len(data) > 12, "data has a header", t)
+	assert.Equal("RIFF", string(data[0:4]), "RIFF container", t)
+	assert.Equal("WEBP", string(data[8:12]), "WebP form type", t)
+}
+
+func TestEncodeLossy(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Encode(&buf, testImage(), &Options{Quality: 80})
+	assert.NilError(err, "lossy encode", t)
+	assertWebP(buf.Bytes(), t)
+}
+
+func TestEncodeLossless(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Encode(&buf, testImage(), &Options{Lossless: true})
+	assert.NilError(err, "lossless encode", t)
+	assertWebP(buf.Bytes(), t)
+}
+
+func TestEncodeGray(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Encode(&buf, image.NewGray(image.Rect(0, 0, 16, 16)), nil)
+	assert.NilError(err, "gray encode with default options", t)
+	assertWebP(buf.Bytes(), t)
+}
+
+func TestEncodeEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	var err = Encode(&buf, image.NewRGBA(image.Rect(0, 0, 0, 0)), nil)
+	assert.Equal(ErrEncodeFailed, err, "empty image can't be encoded", t)
+}